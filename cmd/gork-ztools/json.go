@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/d-dorazio/gork/gork"
+)
+
+// storyDump is the JSON document of a story, the sections that
+// were not asked for are left out
+type storyDump struct {
+	File          string                `json:"file"`
+	Error         string                `json:"error,omitempty"`
+	Header        *gork.ZHeaderInfo     `json:"header,omitempty"`
+	Objects       *[]gork.ZObjectInfo   `json:"objects,omitempty"`
+	Tree          *[]gork.ZObjectNode   `json:"tree,omitempty"`
+	Abbreviations *[]string             `json:"abbreviations,omitempty"`
+	Dictionary    *gork.ZDictionaryInfo `json:"dictionary,omitempty"`
+	Grammar       *gork.ZGrammar        `json:"grammar,omitempty"`
+	GrammarError  string                `json:"grammar_error,omitempty"`
+}
+
+// dumpStoryJSON prints the document of story, broken stories
+// get a document with just the error
+func dumpStoryJSON(story string, conf *config) {
+	dump := &storyDump{File: story}
+	if err := fillStoryDump(dump, conf); err != nil {
+		dump = &storyDump{File: story, Error: err.Error()}
+	}
+
+	out, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(string(out))
+}
+
+func fillStoryDump(dump *storyDump, conf *config) (err error) {
+	// reading the tables of a broken story panics
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+
+	buf, err := ioutil.ReadFile(dump.File)
+	if err != nil {
+		return err
+	}
+	mem := gork.NewZMemory(buf)
+
+	header, err := gork.NewZHeader(mem)
+	if err != nil {
+		return err
+	}
+
+	if conf.showHeader {
+		info := header.Info()
+		dump.Header = &info
+	}
+
+	if conf.showObjects {
+		objects, err := gork.ZObjectInfos(mem, header)
+		if err != nil {
+			return err
+		}
+		dump.Objects = &objects
+	}
+
+	if conf.showObjectTree {
+		tree, err := gork.ZObjectTree(mem, header)
+		if err != nil {
+			return err
+		}
+		dump.Tree = &tree
+	}
+
+	if conf.showAbbreviations {
+		abbrs := gork.GetAbbreviations(mem, header)
+		dump.Abbreviations = &abbrs
+	}
+
+	if conf.showDictionary {
+		dict := gork.NewZDictionary(mem, header).Info()
+		dump.Dictionary = &dict
+	}
+
+	if conf.showGrammar {
+		grammar, err := gork.NewZGrammar(mem, header)
+		if err != nil {
+			dump.GrammarError = err.Error()
+		} else {
+			dump.Grammar = grammar
+		}
+	}
+
+	return nil
+}
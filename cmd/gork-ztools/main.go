@@ -4,6 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"strings"
 
 	"github.com/d-dorazio/gork/gork"
 )
@@ -14,6 +16,13 @@ type config struct {
 	showObjectTree    bool
 	showAbbreviations bool
 	showDictionary    bool
+	showCode          bool
+	showCensus        bool
+	showGrammar       bool
+	json              bool
+	dot               bool
+	// opcodes missing from gork used by each story in the census
+	missing map[string][]string
 }
 
 func main() {
@@ -22,20 +31,50 @@ func main() {
 	t := flag.Bool("t", false, "show object tree")
 	a := flag.Bool("a", false, "show abbreviations")
 	d := flag.Bool("d", false, "show dictionary")
+	c := flag.Bool("c", false, "disassemble the routines reachable from the start")
+	u := flag.Bool("u", false, "count the opcodes used and flag those gork lacks")
+	g := flag.Bool("g", false, "show the grammar of Infocom v1-4 stories")
+	j := flag.Bool("json", false, "print a JSON document per story instead of text")
+	m := flag.Bool("dot", false, "print the rooms of Infocom v1-3 stories as a Graphviz graph instead of text")
 	flag.Parse()
 
+	if *j && (*c || *u) {
+		fmt.Fprintln(os.Stderr, "-c and -u have no JSON output")
+		os.Exit(1)
+	}
+	if *j && *m {
+		fmt.Fprintln(os.Stderr, "-json and -dot can't be used together")
+		os.Exit(1)
+	}
+
 	conf := &config{
 		showHeader:        *i,
 		showObjects:       *o,
 		showObjectTree:    *t,
 		showAbbreviations: *a,
 		showDictionary:    *d,
+		showCode:          *c,
+		showCensus:        *u,
+		showGrammar:       *g,
+		json:              *j,
+		dot:               *m,
+		missing:           map[string][]string{},
 	}
 
 	for _, story := range flag.Args() {
-		dumpStoryInfo(story, conf)
+		switch {
+		case conf.json:
+			dumpStoryJSON(story, conf)
+		case conf.dot:
+			dumpStoryMap(story)
+		default:
+			dumpStoryInfo(story, conf)
+		}
 	}
 
+	if conf.showCensus && !conf.dot && flag.NArg() > 1 {
+		DumpCensusSummary(flag.Args(), conf.missing)
+	}
 }
 
 func dumpStoryInfo(story string, conf *config) {
@@ -73,9 +112,37 @@ func dumpStoryInfo(story string, conf *config) {
 		fmt.Println(gork.NewZDictionary(mem, header))
 	}
 
+	if conf.showGrammar {
+		DumpGrammar(mem, header)
+	}
+
+	if conf.showCode {
+		DumpCode(mem, header)
+	}
+
+	if conf.showCensus {
+		conf.missing[story] = DumpCensus(mem, header)
+	}
+
 	fmt.Println("")
 }
 
+func dumpStoryMap(story string) {
+	buf, err := ioutil.ReadFile(story)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Unable to open story", story, "Error:", err)
+		return
+	}
+	mem := gork.NewZMemory(buf)
+
+	header, err := gork.NewZHeader(mem)
+	if err != nil {
+		panic(err)
+	}
+
+	DumpMap(mem, header, story)
+}
+
 func DumpAbbreviations(mem *gork.ZMemory, header *gork.ZHeader) {
 	fmt.Print("\n    **** Abbreviations ****\n\n")
 
@@ -100,7 +167,7 @@ func DumpAllZObjects(mem *gork.ZMemory, header *gork.ZHeader) {
 	fmt.Print("\n    **** Objects ****\n\n")
 	fmt.Printf("  Object count = %d\n\n", total)
 
-	for i := uint8(1); i <= total; i++ {
+	for i := uint16(1); i <= total; i++ {
 		obj, err := gork.NewZObject(mem, i, header)
 		if err != nil {
 			panic(err)
@@ -113,49 +180,365 @@ func DumpZObjectsTree(mem *gork.ZMemory, header *gork.ZHeader) {
 
 	fmt.Print("\n    **** Object tree ****\n\n")
 
-	total, err := gork.ZObjectsCount(mem, header)
+	roots, err := gork.ZObjectTree(mem, header)
 	if err != nil {
 		panic(err)
 	}
 
-	var printObject func(obj *gork.ZObject, depth int)
-	printObject = func(obj *gork.ZObject, depth int) {
-		for {
+	var printNode func(node gork.ZObjectNode, depth int)
+	printNode = func(node gork.ZObjectNode, depth int) {
+		obj, err := gork.NewZObject(mem, node.Id, header)
+		if err != nil {
+			panic(err)
+		}
+		info := obj.Info()
+
+		for j := 0; j < depth; j++ {
+			fmt.Print(" . ")
+		}
+		fmt.Printf("[%3d] ", node.Id)
+		fmt.Printf("\"%s\"", node.Name)
 
-			for j := 0; j < depth; j++ {
-				fmt.Print(" . ")
+		if len(info.Attributes) > 0 {
+			attrs := make([]string, len(info.Attributes))
+			for i, attr := range info.Attributes {
+				attrs[i] = fmt.Sprint(attr)
 			}
-			fmt.Printf("[%3d] ", obj.Id())
-			fmt.Printf("\"%s\"\n", obj.Name())
+			fmt.Printf("  attributes %s", strings.Join(attrs, ", "))
+		}
 
-			if obj.ChildId() != 0 {
-				childobj, err := gork.NewZObject(mem, obj.ChildId(), header)
-				if err != nil {
-					panic(err)
+		if len(info.Properties) > 0 {
+			props := make([]string, len(info.Properties))
+			for i, prop := range info.Properties {
+				props[i] = fmt.Sprint(prop.Number)
+			}
+			fmt.Printf("  properties %s", strings.Join(props, ", "))
+		}
+		fmt.Println()
+
+		for _, child := range node.Children {
+			printNode(child, depth+1)
+		}
+	}
+
+	for _, root := range roots {
+		printNode(root, 0)
+	}
+}
+
+// DumpMap prints the rooms and their exits as a Graphviz graph
+func DumpMap(mem *gork.ZMemory, header *gork.ZHeader, story string) {
+	rooms, err := gork.ZRooms(mem, header)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "No map of %s: %s\n", story, err)
+		return
+	}
+
+	fmt.Printf("digraph %q {\n", story)
+	fmt.Println("  node [shape=box];")
+
+	// ZRooms has read the object table
+	total, _ := gork.ZObjectsCount(mem, header)
+
+	// node declares the object id once, false if it doesn't exist:
+	// direction properties can hold messages rather than rooms
+	nodes := map[uint16]bool{}
+	node := func(id uint16) bool {
+		if id == 0 || id > total {
+			return false
+		}
+		if nodes[id] {
+			return true
+		}
+
+		obj, err := gork.NewZObject(mem, id, header)
+		if err != nil {
+			return false
+		}
+		nodes[id] = true
+		fmt.Printf("  o%d [label=%q];\n", id, fmt.Sprintf("%s (%d)", obj.Name(), id))
+		return true
+	}
+
+	for _, room := range rooms {
+		node(room.Id)
+
+		for _, exit := range room.Exits {
+			if exit.Kind != gork.ExitRoutine && exit.Kind != gork.ExitBlocked && !node(exit.Room) {
+				fmt.Printf("  // o%d %s leads to no object (%d)\n", room.Id, exit.Direction, exit.Room)
+				continue
+			}
+
+			switch exit.Kind {
+			case gork.ExitUnconditional:
+				fmt.Printf("  o%d -> o%d [label=%q];\n", room.Id, exit.Room, exit.Direction)
+			case gork.ExitConditional:
+				label := fmt.Sprintf("%s if %s", exit.Direction, formatVariable(byte(exit.Condition)))
+				fmt.Printf("  o%d -> o%d [label=%q, style=dashed];\n", room.Id, exit.Room, label)
+			case gork.ExitDoor:
+				label := exit.Direction
+				if exit.Condition != 0 && exit.Condition <= total {
+					if door, err := gork.NewZObject(mem, exit.Condition, header); err == nil {
+						label = fmt.Sprintf("%s through %s", exit.Direction, door.Name())
+					}
 				}
-				printObject(childobj, depth+1)
+				fmt.Printf("  o%d -> o%d [label=%q, style=dashed];\n", room.Id, exit.Room, label)
+			case gork.ExitRoutine:
+				// only the routine knows where the exit leads
+				fmt.Printf("  r%X [label=\"routine %X\", shape=plaintext];\n", exit.Routine, exit.Routine)
+				fmt.Printf("  o%d -> r%X [label=%q, style=dotted];\n", room.Id, exit.Routine, exit.Direction)
 			}
+		}
+	}
 
-			if obj.SiblingId() == 0 {
-				break
+	fmt.Println("}")
+}
+
+func DumpGrammar(mem *gork.ZMemory, header *gork.ZHeader) {
+	fmt.Print("\n    **** Grammar ****\n\n")
+
+	grammar, err := gork.NewZGrammar(mem, header)
+	if err != nil {
+		fmt.Printf("  No grammar information: %s.\n", err)
+		return
+	}
+
+	words := gork.NewZDictionary(mem, header).Words()
+
+	// the first word of each preposition names it in the syntax lines
+	preps := map[byte]string{}
+	for _, word := range words {
+		if value, ok := word.Value(gork.SpeechPreposition); ok && preps[value] == "" {
+			preps[value] = word.Text
+		}
+	}
+
+	fmt.Printf("  Verb entries = %d, action entries = %d\n", len(grammar.Verbs), len(grammar.Actions))
+
+	for _, verb := range grammar.Verbs {
+		synonyms := []string{}
+		for _, word := range words {
+			if value, ok := word.Value(gork.SpeechVerb); ok && value == verb.Number {
+				synonyms = append(synonyms, fmt.Sprintf("%q", word.Text))
 			}
-			obj, err = gork.NewZObject(mem, obj.SiblingId(), header)
-			if err != nil {
-				panic(err)
+		}
+
+		name := "no-verb"
+		if len(synonyms) > 0 {
+			name = strings.Trim(synonyms[0], "\"")
+		}
+
+		fmt.Printf("\n%3d. verb = %s\n", verb.Number, strings.Join(synonyms, ", "))
+
+		for _, syntax := range verb.Syntaxes {
+			raw := make([]string, 0, 8)
+			for _, b := range syntax.Bytes() {
+				raw = append(raw, fmt.Sprintf("%02x", b))
 			}
+
+			action := fmt.Sprintf("action %d: %X", syntax.Action, grammar.Actions[syntax.Action])
+			if preAction := grammar.PreActions[syntax.Action]; preAction != 0 {
+				action += fmt.Sprintf(", pre-action %X", preAction)
+			}
+
+			fmt.Printf("    [%s] %-40s %s\n", strings.Join(raw, " "), formatSyntax(name, syntax, preps), action)
 		}
 	}
 
-	for i := uint8(1); i <= total; i++ {
-		zobj, err := gork.NewZObject(mem, i, header)
-		if err != nil {
-			panic(err)
+	fmt.Print("\n    **** Parts of speech ****\n\n")
+
+	for i, word := range words {
+		raw := make([]string, len(word.Data))
+		for j, b := range word.Data {
+			raw[j] = fmt.Sprintf("%02x", b)
+		}
+
+		speech := make([]string, 0, 2)
+		for _, part := range word.Speech() {
+			speech = append(speech, part.String())
+		}
+
+		fmt.Printf("  [%4d] %-10s [%s] %s\n", i+1, word.Text, strings.Join(raw, " "), strings.Join(speech, ", "))
+	}
+}
+
+// formatSyntax spells a syntax line as in ZIL, like PUT OBJ IN OBJ
+func formatSyntax(verb string, syntax gork.ZSyntax, preps map[byte]string) string {
+	parts := []string{strings.ToUpper(verb)}
+
+	for i := 0; i < 2; i++ {
+		if prep := syntax.Preps[i]; prep != 0 {
+			if name, ok := preps[prep]; ok {
+				parts = append(parts, strings.ToUpper(name))
+			} else {
+				parts = append(parts, fmt.Sprintf("PREP-%d", prep))
+			}
+		}
+
+		if i >= syntax.Objects {
+			continue
+		}
+
+		parts = append(parts, "OBJ")
+		if syntax.Find[i] != 0 {
+			parts = append(parts, fmt.Sprintf("(find %d)", syntax.Find[i]))
+		}
+		if syntax.Search[i] != 0 {
+			parts = append(parts, fmt.Sprintf("(%s)", syntax.Search[i]))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func DumpCode(mem *gork.ZMemory, header *gork.ZHeader) {
+	fmt.Print("\n    **** Code ****\n")
+
+	for _, routine := range gork.Disassemble(mem, header) {
+		if routine.Main {
+			fmt.Printf("\nMain routine %X\n\n", routine.Addr)
+		} else {
+			fmt.Printf("\nRoutine %X, %d locals", routine.Addr, len(routine.Locals))
+			if len(routine.Locals) > 0 && header.Version() <= 4 {
+				values := make([]string, len(routine.Locals))
+				for i, v := range routine.Locals {
+					values[i] = fmt.Sprintf("%04x", v)
+				}
+				fmt.Printf(" (%s)", strings.Join(values, ", "))
+			}
+			fmt.Print("\n\n")
+		}
+
+		for _, op := range routine.Ops {
+			fmt.Printf("%6X:  %s\n", op.Pos(), formatZOp(op, header))
+		}
+
+		if routine.Err != nil {
+			fmt.Printf("  [stopped: %s]\n", routine.Err)
 		}
+	}
+}
+
+// DumpCensus prints how many instructions use each opcode and returns
+// the names of the ones gork can't run
+func DumpCensus(mem *gork.ZMemory, header *gork.ZHeader) []string {
+	fmt.Print("\n    **** Opcode census ****\n\n")
+
+	routines := gork.Disassemble(mem, header)
+	census := gork.OpcodeCensus(routines)
+
+	total := 0
+	for _, count := range census {
+		total += count.Count
+	}
+	fmt.Printf("  %d routines, %d instructions, %d opcodes\n\n", len(routines), total, len(census))
+
+	var missing []string
+	for _, count := range census {
+		if count.Missing() {
+			fmt.Printf("  %6d  %-16s  not implemented\n", count.Count, count.Name())
+			missing = append(missing, count.Name())
+		} else {
+			fmt.Printf("  %6d  %s\n", count.Count, count.Name())
+		}
+	}
+
+	// what follows a broken routine is not counted
+	for _, routine := range routines {
+		if routine.Err != nil {
+			fmt.Printf("\n  Routine %X not fully decoded: %s\n", routine.Addr, routine.Err)
+		}
+	}
 
-		// root
-		if zobj.ParentId() == 0 {
-			printObject(zobj, 0)
-			break
+	return missing
+}
+
+// DumpCensusSummary tells which stories use opcodes gork lacks, as far
+// as the census knows: code Disassemble doesn't find is not counted
+func DumpCensusSummary(stories []string, missing map[string][]string) {
+	fmt.Print("\n    **** Census summary (reachable code only) ****\n\n")
+
+	for _, story := range stories {
+		names, ok := missing[story]
+		switch {
+		case !ok:
+			fmt.Printf("  %s: not scanned\n", story)
+		case len(names) == 0:
+			fmt.Printf("  %s: nothing missing in the code found\n", story)
+		default:
+			fmt.Printf("  %s: missing %s\n", story, strings.Join(names, ", "))
 		}
 	}
 }
+
+func formatZOp(op *gork.ZOp, header *gork.ZHeader) string {
+	info := op.Info()
+	if info == nil {
+		return fmt.Sprintf("unknown opcode %d (class %d)", op.Opcode(), op.Class())
+	}
+
+	args := []string{}
+	types := op.OperandTypes()
+	for i, v := range op.Operands() {
+		switch {
+		case i == 0 && types[i] != gork.VARIABLE_CONSTANT && info.Calls:
+			// the routine as shown by its header
+			args = append(args, fmt.Sprintf("%X", header.PackedAddress(uint32(v))))
+		case i == 0 && types[i] != gork.VARIABLE_CONSTANT && info.Name == "jump":
+			target, _ := op.JumpTarget()
+			args = append(args, fmt.Sprintf("%X", target))
+		default:
+			args = append(args, formatOperand(types[i], v))
+		}
+	}
+
+	if varnum, ok := op.Store(); ok {
+		args = append(args, "->", formatVariable(varnum))
+	}
+
+	if onTrue, offset, ok := op.Branch(); ok {
+		cond := "?"
+		if !onTrue {
+			cond = "?~"
+		}
+
+		switch offset {
+		case 0:
+			args = append(args, cond+"rfalse")
+		case 1:
+			args = append(args, cond+"rtrue")
+		default:
+			args = append(args, fmt.Sprintf("%s%X", cond, op.BranchTarget()))
+		}
+	}
+
+	if info.Text {
+		args = append(args, fmt.Sprintf("%q", op.Text()))
+	}
+
+	return strings.TrimSpace(fmt.Sprintf("%-16s %s", strings.ToUpper(op.Name()), strings.Join(args, " ")))
+}
+
+func formatOperand(optype byte, v uint16) string {
+	switch optype {
+	case gork.LARGE_CONSTANT:
+		return fmt.Sprintf("#%04x", v)
+	case gork.VARIABLE_CONSTANT:
+		return formatVariable(byte(v))
+	default:
+		return fmt.Sprintf("#%02x", v)
+	}
+}
+
+// formatVariable names variables as txd does: sp, locals
+// from L00 and globals from G00
+func formatVariable(varnum byte) string {
+	switch {
+	case varnum == 0:
+		return "sp"
+	case varnum < 0x10:
+		return fmt.Sprintf("L%02x", varnum-1)
+	default:
+		return fmt.Sprintf("G%02x", varnum-0x10)
+	}
+}
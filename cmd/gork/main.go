@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/d-dorazio/gork/gork"
@@ -16,6 +17,14 @@ func main() {
 	identity := flag.String("identity", "", "ssh key to use to start server")
 	addr := flag.String("address", "0.0.0.0:4273", "address to listen on for ssh connections")
 	ws := flag.Bool("ws", false, "start the web socket server on addr")
+	replay := flag.String("replay", "", "file of commands to play before reading the keyboard")
+	undo := flag.Int("undo", 16, "number of turns #undo can take back, 0 disables undo")
+	meta := flag.String("meta", "#", "prefix of the interpreter commands, empty disables them")
+	seed := flag.Int64("seed", 0, "seed of the random numbers, below 1000 they count up to it, 0 keeps them random")
+	errorPolicy := flag.String("errors", "once", "what to do on spec violations: fatal, always (warn), once (warn) or ignore, "+
+		"unlike the library default (fatal) it lets released stories with such bugs go on")
+	report := flag.Bool("report", false, "log how many spec violations the story did when it ends")
+	saves := flag.String("saves", "saves", "directory of the files of ssh and web socket players, a subdirectory per player")
 	flag.Parse()
 
 	if len(flag.Args()) < 1 {
@@ -25,6 +34,12 @@ func main() {
 
 	story := flag.Args()[0]
 
+	policy, err := gork.ParseErrorPolicy(*errorPolicy)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
 	buf, err := ioutil.ReadFile(story)
 	if err != nil {
 		panic(err)
@@ -36,12 +51,19 @@ func main() {
 		panic(err)
 	}
 
+	opts := []gork.ZOption{gork.UndoDepth(*undo), gork.MetaPrefix(*meta), gork.RandomSeed(*seed), gork.ErrorPolicy(policy)}
+	if *report {
+		opts = append(opts, gork.ReportViolations())
+	}
+
 	if *identity != "" {
 		server := &SshServer{
 			id_rsa: *identity,
 			story:  story,
 			mem:    mem,
 			header: header,
+			opts:   opts,
+			saves:  *saves,
 		}
 		server.run(*addr)
 	} else if *ws {
@@ -49,14 +71,16 @@ func main() {
 			story:  story,
 			mem:    mem,
 			header: header,
+			opts:   opts,
+			saves:  *saves,
 		}
 		server.run(*addr)
 	} else {
-		terminalUI(story, mem, header)
+		terminalUI(story, mem, header, opts, *replay)
 	}
 }
 
-func terminalUI(story string, mem *gork.ZMemory, header *gork.ZHeader) {
+func terminalUI(story string, mem *gork.ZMemory, header *gork.ZHeader, opts []gork.ZOption, replay string) {
 	logfile, err := os.Create(storyLogFilename(story))
 	if err != nil {
 		panic(err)
@@ -65,14 +89,38 @@ func terminalUI(story string, mem *gork.ZMemory, header *gork.ZHeader) {
 
 	logger := log.New(logfile, "", log.LstdFlags)
 
-	zm, err := gork.NewZMachine(mem, header, gork.ZTerminal{}, logger)
+	zm, err := gork.NewZMachine(mem, header, gork.ZTerminal{}, logger, opts...)
 	if err != nil {
 		panic(err)
 	}
 
+	if replay != "" {
+		if err := zm.OpenCommandsFile(replay); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
 	if err := zm.InterpretAll(); err != nil {
-		panic(err)
+		logger.Print(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// playerDir returns the directory of the files of a remote player,
+// player comes from the network and is made a plain filename
+func playerDir(saves string, player string) string {
+	name := []rune(player)
+	for i, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 {
+		name = []rune("_")
 	}
+	return filepath.Join(saves, string(name))
 }
 
 func storyLogFilename(story string) string {
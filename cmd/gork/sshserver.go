@@ -8,6 +8,9 @@ import (
 	"log"
 	"net"
 	"os"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/d-dorazio/gork/gork"
 	"golang.org/x/crypto/ssh"
@@ -19,6 +22,13 @@ type SshServer struct {
 	story  string
 	mem    *gork.ZMemory
 	header *gork.ZHeader
+	opts   []gork.ZOption
+	// directory of the files of the players
+	saves string
+
+	// sessions of each user playing
+	playersLock sync.Mutex
+	players     map[string]int
 }
 
 func (server *SshServer) run(addr string) {
@@ -104,11 +114,18 @@ func (server *SshServer) handleChannel(user string, newChannel ssh.NewChannel) {
 	terminal := terminal.NewTerminal(connection, "")
 	zsshterm := &gork.ZSshTerminal{Term: terminal}
 
-	zm, err := gork.NewZMachine(server.mem, server.header, zsshterm, logger)
+	opts := append([]gork.ZOption{}, server.opts...)
+	opts = append(opts, gork.SaveDir(playerDir(server.saves, user)))
+
+	zm, err := gork.NewZMachine(server.mem.Clone(), server.header, zsshterm, logger, opts...)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	zm.RegisterMetaCommand("who", "lists who is playing", server.metaWho)
+
+	server.join(user)
+	defer server.leave(user)
 
 	go func() {
 		for req := range requests {
@@ -128,12 +145,42 @@ func (server *SshServer) handleChannel(user string, newChannel ssh.NewChannel) {
 		}
 	}()
 
-	defer func() {
-		recover()
-	}()
+	if err := zm.InterpretAll(); err != nil {
+		logger.Print(err)
+		zsshterm.Print(fmt.Sprintf("\n%s\n", err))
+	}
+}
 
-	zm.InterpretAll()
+func (server *SshServer) join(user string) {
+	server.playersLock.Lock()
+	defer server.playersLock.Unlock()
+
+	if server.players == nil {
+		server.players = map[string]int{}
+	}
+	server.players[user]++
+}
+
+func (server *SshServer) leave(user string) {
+	server.playersLock.Lock()
+	defer server.playersLock.Unlock()
+
+	server.players[user]--
+	if server.players[user] <= 0 {
+		delete(server.players, user)
+	}
+}
+
+func (server *SshServer) metaWho(zm *gork.ZMachine, args []string) {
+	server.playersLock.Lock()
+	users := make([]string, 0, len(server.players))
+	for user := range server.players {
+		users = append(users, user)
+	}
+	server.playersLock.Unlock()
 
+	sort.Strings(users)
+	zm.Print("[Playing: ", strings.Join(users, ", "), ".]\n")
 }
 
 func parseDims(b []byte) (int, int) {
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 
@@ -14,6 +15,9 @@ type WSServer struct {
 	story  string
 	mem    *gork.ZMemory
 	header *gork.ZHeader
+	opts   []gork.ZOption
+	// directory of the files of the players
+	saves string
 }
 
 func (server *WSServer) run(addr string) {
@@ -39,11 +43,21 @@ func (server *WSServer) run(addr string) {
 
 		wsdev := &gork.ZWSDev{Conn: conn}
 
-		zm, err := gork.NewZMachine(server.mem, server.header, wsdev, logger)
+		// web socket players are known by their address only
+		host, _, err := net.SplitHostPort(remoteAddr)
+		if err != nil {
+			host = remoteAddr
+		}
+		opts := append([]gork.ZOption{}, server.opts...)
+		opts = append(opts, gork.SaveDir(playerDir(server.saves, host)))
+
+		zm, err := gork.NewZMachine(server.mem.Clone(), server.header, wsdev, logger, opts...)
 		if err != nil {
 			panic(err)
 		}
-		zm.InterpretAll()
+		if err := zm.InterpretAll(); err != nil {
+			logger.Print(err)
+		}
 	}
 
 	http.HandleFunc("/play", wsHandler)
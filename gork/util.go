@@ -55,3 +55,39 @@ func SplitSentence(sentence string, wordsep string) []string {
 
 	return ret
 }
+
+// ZWord is a word of a sentence along with the offset
+// of its first letter
+type ZWord struct {
+	Text string
+	Pos  int
+}
+
+// SplitSentenceWords is SplitSentence keeping track of where
+// each word starts, which is needed to fill the parse table
+func SplitSentenceWords(sentence string, wordsep string) []ZWord {
+	ret := []ZWord{}
+
+	start := -1
+	flush := func(end int) {
+		if start >= 0 {
+			ret = append(ret, ZWord{sentence[start:end], start})
+			start = -1
+		}
+	}
+
+	for i := 0; i < len(sentence); i++ {
+		if unicode.IsSpace(rune(sentence[i])) {
+			flush(i)
+		} else if strings.IndexByte(wordsep, sentence[i]) >= 0 {
+			// separators are words on their own
+			flush(i)
+			ret = append(ret, ZWord{sentence[i : i+1], i})
+		} else if start < 0 {
+			start = i
+		}
+	}
+	flush(len(sentence))
+
+	return ret
+}
@@ -49,3 +49,31 @@ func TestSplitSentence(t *testing.T) {
 	}
 
 }
+
+func TestSplitSentenceWords(t *testing.T) {
+	const wordsep string = ",."
+
+	data := []string{
+		"fred go fishing",
+		"  fred,go  fishing.",
+	}
+	expected := [][]ZWord{
+		[]ZWord{{"fred", 0}, {"go", 5}, {"fishing", 8}},
+		[]ZWord{{"fred", 2}, {",", 6}, {"go", 7}, {"fishing", 11}, {".", 18}},
+	}
+
+	for i, d := range data {
+		words := SplitSentenceWords(d, wordsep)
+
+		if len(words) != len(expected[i]) {
+			t.Fail()
+			continue
+		}
+
+		for j, w := range words {
+			if w != expected[i][j] {
+				t.Fail()
+			}
+		}
+	}
+}
@@ -3,13 +3,25 @@ package gork
 // v3 3 tables * 32 entries each
 const abbrCount = 32 * 3
 
+// abbreviationsCount returns how many abbreviations the story can have,
+// v1 has none and v2 a single table
+func abbreviationsCount(header *ZHeader) uint16 {
+	switch header.version {
+	case 1:
+		return 0
+	case 2:
+		return 32
+	}
+	return abbrCount
+}
+
 func GetAbbreviations(mem *ZMemory, header *ZHeader) []string {
 
 	seq := mem.GetSequential(uint32(header.abbrTblPos))
 
 	ret := []string{}
 
-	for i := uint16(0); i < abbrCount; i++ {
+	for i := uint16(0); i < abbreviationsCount(header); i++ {
 		addr := uint32(seq.ReadWord()) * 2
 		ret = append(ret, mem.DecodeZStringAt(addr, header))
 	}
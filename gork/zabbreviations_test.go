@@ -38,7 +38,7 @@ func createMemory() *ZMemory {
 		ret = append(ret, abbr...)
 	}
 
-	tmp := ZMemory(ret)
+	tmp := *NewZMemory(ret)
 	return &tmp
 }
 
@@ -6,17 +6,31 @@ import (
 )
 
 type ZDictionary struct {
+	addr           uint32
 	wordSeparators []byte
 	entrySize      uint8
 	words          []string
 	entriesPos     uint32
-	// ignore words data, it looks like they are useless to interpreters
+	// v5 user dictionaries can be unsorted
+	sorted bool
+	// the data of the words is read from memory by Words
+	mem    *ZMemory
+	header *ZHeader
 }
 
 func NewZDictionary(mem *ZMemory, header *ZHeader) *ZDictionary {
+	return NewZDictionaryAt(mem, header, uint32(header.dictPos))
+}
+
+// NewZDictionaryAt reads the dictionary at addr, which is not
+// the main one for v5 user dictionaries
+func NewZDictionaryAt(mem *ZMemory, header *ZHeader, addr uint32) *ZDictionary {
 	zdict := new(ZDictionary)
+	zdict.addr = addr
+	zdict.mem = mem
+	zdict.header = header
 
-	seq := mem.GetSequential(uint32(header.dictPos))
+	seq := mem.GetSequential(addr)
 
 	n := seq.ReadByte()
 
@@ -27,11 +41,16 @@ func NewZDictionary(mem *ZMemory, header *ZHeader) *ZDictionary {
 
 	zdict.entrySize = seq.ReadByte()
 
-	entryCount := seq.ReadWord()
+	// a negative count means the entries are unsorted
+	entryCount := int16(seq.ReadWord())
+	zdict.sorted = entryCount >= 0
+	if entryCount < 0 {
+		entryCount = -entryCount
+	}
 
 	zdict.entriesPos = seq.pos
 
-	for i := uint16(0); i < entryCount; i++ {
+	for i := int16(0); i < entryCount; i++ {
 		word := mem.DecodeZStringAt(seq.pos, header)
 		zdict.words = append(zdict.words, word)
 		seq.pos += uint32(zdict.entrySize)
@@ -40,10 +59,69 @@ func NewZDictionary(mem *ZMemory, header *ZHeader) *ZDictionary {
 	return zdict
 }
 
+// ZDictionaryWord is an entry of the dictionary
+type ZDictionaryWord struct {
+	Addr uint32 `json:"addr"`
+	Text string `json:"text"`
+	// the bytes following the encoded text, interpreters ignore
+	// them and their meaning is up to the story
+	Data ZBytes `json:"data"`
+}
+
+// Words returns the entries of the dictionary in their order
+func (dict *ZDictionary) Words() []ZDictionaryWord {
+	textLen := uint32(encodedZStringLen(dict.header)) * 2
+	dataLen := uint32(0)
+	if uint32(dict.entrySize) > textLen {
+		dataLen = uint32(dict.entrySize) - textLen
+	}
+
+	ret := make([]ZDictionaryWord, len(dict.words))
+	for i, text := range dict.words {
+		addr := dict.entriesPos + uint32(i)*uint32(dict.entrySize)
+		data := make(ZBytes, dataLen)
+		for j := range data {
+			data[j] = dict.mem.ByteAt(addr + textLen + uint32(j))
+		}
+		ret[i] = ZDictionaryWord{Addr: addr, Text: text, Data: data}
+	}
+
+	return ret
+}
+
+// Search returns the address of the entry of s, 0 if not found
 func (dict *ZDictionary) Search(s string) uint16 {
-	i := sort.SearchStrings(dict.words, s)
+	encoded := ZStringEncode(s, dict.header)
+
+	// entries are sorted by their encoded form
+	compare := func(i int) int {
+		addr := dict.entriesPos + uint32(i)*uint32(dict.entrySize)
+		for j, w := range encoded {
+			entryWord := dict.mem.WordAt(addr + uint32(j)*2)
+			if entryWord != w {
+				if entryWord < w {
+					return -1
+				}
+				return 1
+			}
+		}
+		return 0
+	}
+
+	if !dict.sorted {
+		for i := range dict.words {
+			if compare(i) == 0 {
+				return uint16(dict.entriesPos + uint32(i)*uint32(dict.entrySize))
+			}
+		}
+		return 0
+	}
+
+	i := sort.Search(len(dict.words), func(i int) bool {
+		return compare(i) >= 0
+	})
 
-	if i < len(dict.words) && dict.words[i] == s {
+	if i < len(dict.words) && compare(i) == 0 {
 		return uint16(dict.entriesPos + uint32(i)*uint32(dict.entrySize))
 	}
 
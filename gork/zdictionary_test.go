@@ -26,7 +26,7 @@ var dictExpected ZDictionary = ZDictionary{
 }
 
 func TestZDictionary(t *testing.T) {
-	mem := ZMemory(dictBuf)
+	mem := *NewZMemory(dictBuf)
 
 	res := NewZDictionary(&mem, &ZHeader{dictPos: 0})
 
@@ -50,7 +50,7 @@ func TestZDictionary(t *testing.T) {
 }
 
 func TestZDictionarySearch(t *testing.T) {
-	mem := ZMemory(dictBuf)
+	mem := *NewZMemory(dictBuf)
 
 	dict := NewZDictionary(&mem, &ZHeader{dictPos: 0})
 
@@ -74,3 +74,26 @@ func TestZDictionarySearch(t *testing.T) {
 		}
 	}
 }
+
+func TestZDictionaryWords(t *testing.T) {
+	// 2 entries of 4 bytes of text and 3 of data
+	buf := []byte{
+		0,
+		7,
+		0, 2,
+		0x23, 0xC8, 0xC6, 0x95, 0x80, 0x00, 0x00,
+		0x7E, 0x97, 0xC0, 0xA5, 0x41, 0xFE, 0x00,
+	}
+
+	words := NewZDictionary(NewZMemory(buf), &ZHeader{version: 3}).Words()
+	if len(words) != 2 {
+		t.FailNow()
+	}
+
+	if words[0].Addr != 4 || words[0].Text != "cyclop" || len(words[0].Data) != 3 || words[0].Data[0] != 0x80 {
+		t.Fail()
+	}
+	if words[1].Addr != 11 || words[1].Text != "zork" || words[1].Data[1] != 0xFE {
+		t.Fail()
+	}
+}
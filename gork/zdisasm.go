@@ -0,0 +1,301 @@
+package gork
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ZRoutineCode is a routine of the story as decoded by Disassemble
+type ZRoutineCode struct {
+	Addr uint32
+	// v1-5 the story starts at an instruction, not at a routine,
+	// so the first routine has no header
+	Main bool
+	// initial values of the locals, v5 they're all 0
+	Locals []uint16
+	Ops    []*ZOp
+	// why the decoding stopped before the end of the routine
+	Err error
+}
+
+// Disassemble decodes the routines reachable from the start of the
+// story, sorted by address. Infocom stories call most of their
+// routines through tables and properties, so besides the calls by
+// constants it follows the actions and pre-actions of the grammar,
+// the packed addresses held by properties and the ones given to store
+// and put_prop: these could be plain numbers, they're kept only if
+// they're in high memory and decode up to the end of a routine
+func Disassemble(mem *ZMemory, header *ZHeader) []*ZRoutineCode {
+	// nil for the guesses that turned out not to be routines
+	routines := map[uint32]*ZRoutineCode{}
+	pending := []*ZRoutineCode{}
+
+	visit := func(addr uint32, main bool, guessed bool) {
+		if _, ok := routines[addr]; ok || addr >= mem.Len() {
+			return
+		}
+
+		routine := disassembleRoutine(mem, header, addr, main)
+		if guessed && (addr < uint32(header.highStart) || routine.Err != nil) {
+			routine = nil
+		} else {
+			pending = append(pending, routine)
+		}
+		routines[addr] = routine
+	}
+
+	visit(uint32(header.pc), true, false)
+	for _, addr := range grammarRoutines(mem, header) {
+		visit(addr, false, false)
+	}
+	for _, addr := range propertyRoutines(mem, header) {
+		visit(addr, false, true)
+	}
+
+	for len(pending) > 0 {
+		routine := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		for _, addr := range routine.Callees(header) {
+			visit(addr, false, false)
+		}
+		for _, addr := range routine.StoredRoutines(header) {
+			visit(addr, false, true)
+		}
+	}
+
+	ret := make([]*ZRoutineCode, 0, len(routines))
+	for _, routine := range routines {
+		if routine != nil {
+			ret = append(ret, routine)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Addr < ret[j].Addr })
+
+	return ret
+}
+
+// Callees returns the addresses of the routines called by constants
+func (routine *ZRoutineCode) Callees(header *ZHeader) []uint32 {
+	var ret []uint32
+	for _, op := range routine.Ops {
+		if op.info == nil || !op.info.Calls || len(op.operands) == 0 || op.optypes[0] == VARIABLE_CONSTANT {
+			continue
+		}
+
+		// calling address 0 does nothing
+		if op.operands[0] != 0 {
+			ret = append(ret, header.PackedAddress(uint32(op.operands[0])))
+		}
+	}
+	return ret
+}
+
+// StoredRoutines returns the constants given to store and put_prop
+// as packed addresses, some of them are routines called later on
+func (routine *ZRoutineCode) StoredRoutines(header *ZHeader) []uint32 {
+	var ret []uint32
+	for _, op := range routine.Ops {
+		if op.info == nil {
+			continue
+		}
+
+		// the operand holding the value
+		value := -1
+		switch op.info.Name {
+		case "store":
+			value = 1
+		case "put_prop":
+			value = 2
+		}
+
+		if value >= 0 && value < len(op.operands) && op.optypes[value] == LARGE_CONSTANT &&
+			op.operands[value] != 0 {
+			ret = append(ret, header.PackedAddress(uint32(op.operands[value])))
+		}
+	}
+	return ret
+}
+
+// grammarRoutines returns the actions and the pre-actions of
+// an Infocom grammar, if the story has one
+func grammarRoutines(mem *ZMemory, header *ZHeader) (ret []uint32) {
+	// reading past the end of the story panics
+	defer func() {
+		recover()
+	}()
+
+	grammar, err := NewZGrammar(mem, header)
+	if err != nil {
+		return nil
+	}
+
+	for _, addr := range append(grammar.Actions, grammar.PreActions...) {
+		// pre-actions are 0 when the action has none
+		if addr != 0 {
+			ret = append(ret, addr)
+		}
+	}
+	return ret
+}
+
+// propertyRoutines returns the words of the properties of 2 bytes as
+// packed addresses, it gives up on an object table it can't read
+func propertyRoutines(mem *ZMemory, header *ZHeader) (ret []uint32) {
+	// reading past the end of the story panics
+	defer func() {
+		recover()
+	}()
+
+	total, err := ZObjectsCount(mem, header)
+	if err != nil {
+		return nil
+	}
+
+	for i := uint16(1); i <= total; i++ {
+		obj, err := NewZObject(mem, i, header)
+		if err != nil {
+			return ret
+		}
+
+		for _, prop := range obj.PropertiesIds() {
+			data := obj.PropertyData(prop)
+			if len(data) == 2 && (data[0] != 0 || data[1] != 0) {
+				ret = append(ret, header.PackedAddress(uint32(data[0])<<8|uint32(data[1])))
+			}
+		}
+	}
+
+	return ret
+}
+
+func disassembleRoutine(mem *ZMemory, header *ZHeader, addr uint32, main bool) *ZRoutineCode {
+	routine := &ZRoutineCode{Addr: addr, Main: main}
+	pos := addr
+
+	if !main {
+		numLocals := mem.ByteAt(pos)
+		if numLocals > maxLocals {
+			routine.Err = fmt.Errorf("routine at %X has %d locals", addr, numLocals)
+			return routine
+		}
+		pos++
+
+		routine.Locals = make([]uint16, numLocals)
+		// v5 locals start at 0 and their initial values are not stored
+		if header.version <= 4 {
+			if pos+uint32(numLocals)*2 > mem.Len() {
+				routine.Err = fmt.Errorf("routine at %X is truncated", addr)
+				return routine
+			}
+			for i := range routine.Locals {
+				routine.Locals[i] = mem.WordAt(pos)
+				pos += 2
+			}
+		}
+	}
+
+	// the routine goes on past an instruction stopping the execution
+	// if an earlier one jumps beyond it
+	furthest := pos
+
+	for {
+		op, err := DecodeZOp(mem, header, pos)
+		if err != nil {
+			routine.Err = err
+			return routine
+		}
+
+		routine.Ops = append(routine.Ops, op)
+		if op.info == nil {
+			routine.Err = fmt.Errorf("unknown opcode at %X", pos)
+			return routine
+		}
+		pos = op.next
+
+		if target, ok := op.JumpTarget(); ok && target > furthest {
+			furthest = target
+		}
+
+		if op.info.Stops && pos > furthest {
+			return routine
+		}
+	}
+}
+
+// JumpTarget returns the address the instruction can jump to
+// within its routine, if any
+func (zop *ZOp) JumpTarget() (uint32, bool) {
+	if zop.info == nil {
+		return 0, false
+	}
+
+	if zop.info.Name == "jump" && len(zop.operands) > 0 && zop.optypes[0] != VARIABLE_CONSTANT {
+		return uint32(int64(zop.next) + int64(int16(zop.operands[0])) - 2), true
+	}
+
+	if _, offset, ok := zop.Branch(); ok && offset != 0 && offset != 1 {
+		return zop.BranchTarget(), true
+	}
+
+	return 0, false
+}
+
+// ZOpCount is how many instructions of a story use an opcode
+type ZOpCount struct {
+	Class  byte
+	Number byte
+	// nil if the opcode doesn't exist in the version of the story
+	Info  *ZOpInfo
+	Count int
+}
+
+// Name returns the name of the opcode, or its class and number
+// if it doesn't exist
+func (count ZOpCount) Name() string {
+	if count.Info == nil {
+		return fmt.Sprintf("unknown %s opcode %02X", ClassName(count.Class), count.Number)
+	}
+	return count.Info.Name
+}
+
+// Missing tells if gork can't run instructions using the opcode
+func (count ZOpCount) Missing() bool {
+	return count.Info == nil || !count.Info.Implemented()
+}
+
+// OpcodeCensus counts the instructions of the routines by opcode,
+// the most used first
+func OpcodeCensus(routines []*ZRoutineCode) []ZOpCount {
+	type key struct {
+		class  byte
+		number byte
+	}
+
+	counts := map[key]*ZOpCount{}
+	for _, routine := range routines {
+		for _, op := range routine.Ops {
+			k := key{op.class, op.opcode}
+			if counts[k] == nil {
+				counts[k] = &ZOpCount{Class: op.class, Number: op.opcode, Info: op.info}
+			}
+			counts[k].Count++
+		}
+	}
+
+	ret := make([]ZOpCount, 0, len(counts))
+	for _, count := range counts {
+		ret = append(ret, *count)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Count != ret[j].Count {
+			return ret[i].Count > ret[j].Count
+		}
+		if ret[i].Class != ret[j].Class {
+			return ret[i].Class < ret[j].Class
+		}
+		return ret[i].Number < ret[j].Number
+	})
+
+	return ret
+}
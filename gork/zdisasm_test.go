@@ -0,0 +1,155 @@
+package gork
+
+import "testing"
+
+func TestDisassemble(t *testing.T) {
+	buf := make([]byte, 0x80)
+	copy(buf[0x40:], []byte{
+		// call 0x60 -> sp
+		0xE0, 0x3F, 0x00, 0x30, 0x00,
+		// jz sp ?(+3)
+		0xA0, 0x00, 0xC3,
+		0xBA,
+		// jump -2, back to quit
+		0x8C, 0xFF, 0xFE,
+		// not reachable
+		0xBA,
+	})
+	copy(buf[0x60:], []byte{
+		// 1 local
+		0x01, 0x00, 0x05,
+		// print "hello"
+		0xB2, 0x35, 0x51, 0xC6, 0x85,
+		0xB0,
+	})
+
+	mem := NewZMemory(buf)
+	routines := Disassemble(mem, &ZHeader{version: 3, pc: 0x40})
+	if len(routines) != 2 {
+		t.FailNow()
+	}
+
+	main, routine := routines[0], routines[1]
+
+	if !main.Main || main.Addr != 0x40 || len(main.Ops) != 4 || main.Err != nil {
+		t.Fail()
+	}
+	if target, ok := main.Ops[1].JumpTarget(); !ok || target != 0x49 {
+		t.Fail()
+	}
+	if target, ok := main.Ops[3].JumpTarget(); !ok || target != 0x48 {
+		t.Fail()
+	}
+
+	if routine.Main || routine.Addr != 0x60 || len(routine.Locals) != 1 || routine.Locals[0] != 5 ||
+		len(routine.Ops) != 2 || routine.Ops[0].Text() != "hello" || routine.Ops[1].Name() != "rtrue" {
+		t.Fail()
+	}
+}
+
+func TestDisassembleBrokenRoutine(t *testing.T) {
+	buf := make([]byte, 0x44)
+	// call 0x40 -> sp, the routine has 16 locals
+	copy(buf[0x30:], []byte{0xE0, 0x3F, 0x00, 0x20, 0x00, 0xBA})
+	buf[0x40] = 16
+
+	routines := Disassemble(NewZMemory(buf), &ZHeader{version: 3, pc: 0x30})
+	if len(routines) != 2 || routines[1].Err == nil || len(routines[1].Ops) != 0 {
+		t.Fail()
+	}
+}
+
+func TestDisassembleNoOperands(t *testing.T) {
+	buf := make([]byte, 0x48)
+	// call without operands -> sp, quit
+	copy(buf[0x40:], []byte{0xE0, 0xFF, 0x00, 0xBA})
+
+	routines := Disassemble(NewZMemory(buf), &ZHeader{version: 3, pc: 0x40})
+	if len(routines) != 1 || len(routines[0].Ops) != 2 {
+		t.FailNow()
+	}
+
+	// the encoding of jump can't leave out its operand, the check
+	// guards ZOps made by hand
+	if _, ok := (&ZOp{info: &ZOpInfo{Name: "jump"}}).JumpTarget(); ok {
+		t.Fail()
+	}
+}
+
+func TestDisassembleTables(t *testing.T) {
+	buf := make([]byte, 0x118)
+
+	// object 1 follows the property defaults, it has an empty name
+	// and property 5 holding the routine at 0x106
+	buf[0x7E+7], buf[0x7E+8] = 0x00, 0x87
+	copy(buf[0x87:], []byte{0x00, 0x25, 0x00, 0x83, 0x00})
+
+	copy(buf[0x8C:], []byte{
+		// verbs
+		0x00, 0x90, 0x00, 0x99,
+		// 255: BRIEF
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// 254: PUT OBJ IN OBJ
+		0x01, 0x02, 0x00, 0xF7, 0x00, 0x00, 0x88, 0x20, 0x01,
+		// actions at 0x100 and 0x102, pre-action at 0x104
+		0x00, 0x80, 0x00, 0x81,
+		0x00, 0x00, 0x00, 0x82,
+	})
+
+	// routines without locals returning true
+	for addr := 0x100; addr <= 0x108; addr += 2 {
+		buf[addr+1] = 0xB0
+	}
+
+	copy(buf[0x10A:], []byte{
+		// store g0 0x84, the routine at 0x108
+		0xCD, 0x4F, 0x10, 0x00, 0x84,
+		// store g0 0x10, not in high memory
+		0xCD, 0x4F, 0x10, 0x00, 0x10,
+		0xBA,
+	})
+
+	header := &ZHeader{version: 3, pc: 0x10A, highStart: 0x100, objTblPos: 0x40, dictPos: 0xB0}
+	routines := Disassemble(NewZMemory(buf), header)
+	if len(routines) != 6 {
+		t.FailNow()
+	}
+
+	for i, routine := range routines {
+		if routine.Addr != 0x100+uint32(i)*2 || routine.Err != nil || routine.Main != (i == 5) {
+			t.Fail()
+		}
+	}
+}
+
+func TestOpcodeCensus(t *testing.T) {
+	buf := make([]byte, 0x50)
+	copy(buf[0x40:], []byte{
+		// set_true_colour 1 2
+		0xBE, 0x0D, 0x5F, 0x01, 0x02,
+		// new_line twice
+		0xBB, 0xBB,
+		// 2OP 0x1F doesn't exist
+		0x1F, 0x01, 0x02,
+	})
+
+	routines := Disassemble(NewZMemory(buf), &ZHeader{version: 5, pc: 0x40})
+	census := OpcodeCensus(routines)
+	if len(census) != 3 {
+		t.FailNow()
+	}
+
+	for i, test := range []struct {
+		name    string
+		count   int
+		missing bool
+	}{
+		{"new_line", 2, false},
+		{"unknown 2OP opcode 1F", 1, true},
+		{"set_true_colour", 1, true},
+	} {
+		if census[i].Name() != test.name || census[i].Count != test.count || census[i].Missing() != test.missing {
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package gork
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ZRuntimeError is returned by Interpret whenever the story
+// cannot be executed any further
+type ZRuntimeError struct {
+	// address of the faulting instruction
+	PC uint32
+	// nil if the instruction could not be decoded
+	Op *ZOp
+	// address of the routine the instruction belongs to
+	RoutineAddr uint32
+	// number of frames on the call stack
+	StackDepth int
+	Err        error
+}
+
+func (zm *ZMachine) newRuntimeError(pc uint32, op *ZOp, err error) *ZRuntimeError {
+	// errors of interrupt routines are already wrapped
+	if rerr, ok := err.(*ZRuntimeError); ok {
+		return rerr
+	}
+
+	rerr := &ZRuntimeError{
+		PC:         pc,
+		Op:         op,
+		StackDepth: len(zm.stack),
+		Err:        err,
+	}
+
+	if len(zm.stack) > 0 {
+		rerr.RoutineAddr = zm.stack.Top().addr
+	}
+
+	return rerr
+}
+
+// fault aborts the execution of the current instruction, handlers
+// must return right after calling it
+func (zm *ZMachine) fault(err error) {
+	// keep the first error, it's the one that matters
+	if zm.err == nil {
+		zm.err = err
+	}
+}
+
+func (zm *ZMachine) faultf(format string, a ...interface{}) {
+	zm.fault(fmt.Errorf(format, a...))
+}
+
+func (e *ZRuntimeError) Error() string {
+	name := "undecoded instruction"
+	if e.Op != nil {
+		name = e.Op.name
+	}
+
+	return fmt.Sprintf("runtime error at PC %X (%s, routine %X, stack depth %d): %s",
+		e.PC, name, e.RoutineAddr, e.StackDepth, e.Err)
+}
+
+func (e *ZRuntimeError) Unwrap() error {
+	return e.Err
+}
+
+var errUnimplementedOpcode = errors.New("unimplemented opcode")
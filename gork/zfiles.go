@@ -0,0 +1,53 @@
+package gork
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SaveDir keeps the files named by the player, or by the story, in
+// dir: saves, transcripts, command recordings and auxiliary files.
+// Remote players must not reach the rest of the server filesystem so
+// only the last element of a name is kept, absolute names and names
+// going up with .. are refused. Without it names are used as given,
+// which is what a player at the local terminal expects
+func SaveDir(dir string) ZOption {
+	return func(zm *ZMachine) {
+		zm.saveDir = dir
+	}
+}
+
+// playerFile returns the path of the file called name,
+// the save directory is created if needed
+func (zm *ZMachine) playerFile(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("empty filename")
+	}
+
+	if zm.saveDir == "" {
+		return name, nil
+	}
+
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("%s: absolute filenames are not allowed", name)
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(name), "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("%s: filenames can't go up with ..", name)
+		}
+	}
+
+	base := filepath.Base(name)
+	if base == "." || base == string(filepath.Separator) {
+		return "", fmt.Errorf("%s: not a filename", name)
+	}
+
+	if err := os.MkdirAll(zm.saveDir, 0700); err != nil {
+		return "", err
+	}
+	return filepath.Join(zm.saveDir, base), nil
+}
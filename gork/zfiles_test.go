@@ -0,0 +1,41 @@
+package gork
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestZMachinePlayerFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "alice")
+	zm := &ZMachine{}
+	SaveDir(dir)(zm)
+
+	for _, test := range []struct {
+		name     string
+		expected string
+	}{
+		{"zork", filepath.Join(dir, "zork")},
+		{" zork.qzl\n", filepath.Join(dir, "zork.qzl")},
+		{"saves/zork", filepath.Join(dir, "zork")},
+		{"../zork", ""},
+		{"saves/../../zork", ""},
+		{"..", ""},
+		{"/etc/passwd", ""},
+		{".", ""},
+		{"", ""},
+	} {
+		path, err := zm.playerFile(test.name)
+		if path != test.expected || (err == nil) != (test.expected != "") {
+			t.Fail()
+		}
+	}
+
+	// the local terminal can name any file
+	local := &ZMachine{}
+	if path, err := local.playerFile("../zork"); err != nil || path != "../zork" {
+		t.Fail()
+	}
+	if _, err := local.playerFile(" "); err == nil {
+		t.Fail()
+	}
+}
@@ -0,0 +1,310 @@
+package gork
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Infocom v1-4 stories keep the tables of their parser between the
+// property tables of the objects and the dictionary:
+//
+//	verbs        a word per verb, the address of its syntax lines
+//	syntaxes     per verb a count byte followed by 8 bytes per line
+//	actions      per action the packed address of its routine
+//	pre-actions  as actions, 0 if the action has none
+//
+// The header doesn't point to them, NewZGrammar finds them by their shape
+
+const syntaxSize = 8
+
+// ZSearchFlags tell the parser where to look for an object of a syntax
+type ZSearchFlags byte
+
+const (
+	SearchHave     ZSearchFlags = 0x02
+	SearchMany     ZSearchFlags = 0x04
+	SearchTake     ZSearchFlags = 0x08
+	SearchOnGround ZSearchFlags = 0x10
+	SearchInRoom   ZSearchFlags = 0x20
+	SearchCarried  ZSearchFlags = 0x40
+	SearchHeld     ZSearchFlags = 0x80
+)
+
+var searchFlagNames = []struct {
+	flag ZSearchFlags
+	name string
+}{
+	{SearchHeld, "held"},
+	{SearchCarried, "carried"},
+	{SearchInRoom, "in-room"},
+	{SearchOnGround, "on-ground"},
+	{SearchTake, "take"},
+	{SearchMany, "many"},
+	{SearchHave, "have"},
+}
+
+func (flags ZSearchFlags) String() string {
+	names := []string{}
+	for _, flag := range searchFlagNames {
+		if flags&flag.flag != 0 {
+			names = append(names, flag.name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// ZSyntax is a sentence accepted by a verb
+type ZSyntax struct {
+	// 0, 1 or 2
+	Objects int `json:"objects"`
+	// preposition before each object, 0 if none
+	Preps [2]byte `json:"preps"`
+	// attribute the parser prefers when guessing each object, 0 if none
+	Find   [2]byte         `json:"find"`
+	Search [2]ZSearchFlags `json:"search"`
+	Action byte            `json:"action"`
+}
+
+// Bytes returns the syntax line as stored by the story
+func (syntax ZSyntax) Bytes() []byte {
+	return []byte{
+		byte(syntax.Objects),
+		syntax.Preps[0], syntax.Preps[1],
+		syntax.Find[0], syntax.Find[1],
+		byte(syntax.Search[0]), byte(syntax.Search[1]),
+		syntax.Action,
+	}
+}
+
+// ZVerb is an entry of the verb table
+type ZVerb struct {
+	// the value of the verb in the dictionary, the first verb is 255
+	Number   byte      `json:"number"`
+	Addr     uint32    `json:"addr"`
+	Syntaxes []ZSyntax `json:"syntaxes"`
+}
+
+// ZGrammar is the parser tables of an Infocom v1-4 story
+type ZGrammar struct {
+	VerbsPos uint32  `json:"verbs_addr"`
+	Verbs    []ZVerb `json:"verbs"`
+	// the routine of each action
+	Actions []uint32 `json:"actions"`
+	// the routine run before each action, 0 if none
+	PreActions []uint32 `json:"pre_actions"`
+}
+
+// NewZGrammar finds and reads the parser tables, Inform stories
+// and later Infocom ones have a different format
+func NewZGrammar(mem *ZMemory, header *ZHeader) (*ZGrammar, error) {
+	if header.version > 4 {
+		return nil, fmt.Errorf("grammar of version %d stories is not supported", header.version)
+	}
+
+	start, err := propertiesEnd(mem, header)
+	if err != nil {
+		return nil, err
+	}
+
+	for addr := start; addr+2 <= uint32(header.dictPos); addr++ {
+		if grammar := readZGrammar(mem, header, addr); grammar != nil {
+			return grammar, nil
+		}
+	}
+
+	return nil, errors.New("no Infocom grammar found")
+}
+
+// propertiesEnd returns the address following the last property table
+func propertiesEnd(mem *ZMemory, header *ZHeader) (uint32, error) {
+	total, err := ZObjectsCount(mem, header)
+	if err != nil {
+		return 0, err
+	}
+
+	end := uint32(0)
+	for i := uint16(1); i <= total; i++ {
+		obj, err := NewZObject(mem, i, header)
+		if err != nil {
+			return 0, err
+		}
+
+		addr := obj.GetFirstPropertySizeAddr()
+		for {
+			propno, length, headerSize := obj.propertyHeader(addr)
+			if propno == 0 {
+				break
+			}
+			addr += headerSize + length
+		}
+
+		// skip the size byte ending the table
+		if addr+1 > end {
+			end = addr + 1
+		}
+	}
+
+	return end, nil
+}
+
+// readZGrammar reads the tables at addr, nil if they don't look right
+func readZGrammar(mem *ZMemory, header *ZHeader, addr uint32) *ZGrammar {
+	end := uint32(header.dictPos)
+
+	// the syntax lines follow the verb table
+	first := uint32(mem.WordAt(addr))
+	if first <= addr || (first-addr)%2 != 0 || first >= end {
+		return nil
+	}
+
+	grammar := &ZGrammar{VerbsPos: addr}
+	actionsCount := 0
+
+	pos := first
+	for i := uint32(0); i < (first-addr)/2; i++ {
+		verbAddr := uint32(mem.WordAt(addr + i*2))
+		if verbAddr != pos || verbAddr >= end {
+			return nil
+		}
+
+		count := uint32(mem.ByteAt(verbAddr))
+		pos = verbAddr + 1 + count*syntaxSize
+		if count == 0 || pos > end {
+			return nil
+		}
+
+		verb := ZVerb{Number: byte(255 - i), Addr: verbAddr}
+		for j := uint32(0); j < count; j++ {
+			line := mem.Slice(verbAddr+1+j*syntaxSize, verbAddr+1+(j+1)*syntaxSize)
+			if line[0] > 2 {
+				return nil
+			}
+
+			syntax := ZSyntax{
+				Objects: int(line[0]),
+				Preps:   [2]byte{line[1], line[2]},
+				Find:    [2]byte{line[3], line[4]},
+				Search:  [2]ZSearchFlags{ZSearchFlags(line[5]), ZSearchFlags(line[6])},
+				Action:  line[7],
+			}
+			if int(syntax.Action) >= actionsCount {
+				actionsCount = int(syntax.Action) + 1
+			}
+			verb.Syntaxes = append(verb.Syntaxes, syntax)
+		}
+		grammar.Verbs = append(grammar.Verbs, verb)
+	}
+
+	// the actions and the pre-actions follow the syntax lines
+	if pos+uint32(actionsCount)*4 > end {
+		return nil
+	}
+
+	for i := 0; i < actionsCount; i++ {
+		action := header.PackedAddress(uint32(mem.WordAt(pos + uint32(i)*2)))
+		preAction := header.PackedAddress(uint32(mem.WordAt(pos + uint32(actionsCount+i)*2)))
+		if action == 0 || action >= mem.Len() || preAction >= mem.Len() {
+			return nil
+		}
+
+		grammar.Actions = append(grammar.Actions, action)
+		grammar.PreActions = append(grammar.PreActions, preAction)
+	}
+
+	return grammar
+}
+
+// ZPartOfSpeech is a flag of the first data byte of the words
+// of Infocom v1-4 dictionaries
+type ZPartOfSpeech byte
+
+const (
+	SpeechBuzzWord    ZPartOfSpeech = 0x04
+	SpeechPreposition ZPartOfSpeech = 0x08
+	SpeechDirection   ZPartOfSpeech = 0x10
+	SpeechAdjective   ZPartOfSpeech = 0x20
+	SpeechVerb        ZPartOfSpeech = 0x40
+	SpeechObject      ZPartOfSpeech = 0x80
+)
+
+// partsOfSpeech are listed from the highest flag, slot is the value
+// of the low bits of the flags byte when the part of speech has its
+// value in the first value byte, -1 if it has no value
+var partsOfSpeech = []struct {
+	part ZPartOfSpeech
+	name string
+	slot int
+}{
+	{SpeechObject, "noun", -1},
+	{SpeechVerb, "verb", 1},
+	{SpeechAdjective, "adjective", 2},
+	{SpeechDirection, "direction", 3},
+	{SpeechPreposition, "preposition", 0},
+	{SpeechBuzzWord, "buzz", -1},
+}
+
+func (part ZPartOfSpeech) String() string {
+	for _, speech := range partsOfSpeech {
+		if speech.part == part {
+			return speech.name
+		}
+	}
+	return fmt.Sprintf("ZPartOfSpeech(%02X)", byte(part))
+}
+
+// ZSpeech is a part of speech of a word with the value the story gives
+// it: the number of the verb, of the adjective or of the preposition,
+// the property of the direction
+type ZSpeech struct {
+	Part     ZPartOfSpeech
+	Value    byte
+	HasValue bool
+}
+
+func (speech ZSpeech) String() string {
+	if speech.HasValue {
+		return fmt.Sprintf("%s %d", speech.Part, speech.Value)
+	}
+	return speech.Part.String()
+}
+
+// Speech decodes the parts of speech of a word of an Infocom v1-4
+// dictionary: a flags byte followed by two value bytes, the low bits
+// of the flags tell which part of speech has the first value
+func (word ZDictionaryWord) Speech() []ZSpeech {
+	if len(word.Data) < 3 {
+		return nil
+	}
+
+	flags := word.Data[0]
+	ret := []ZSpeech{}
+	for _, speech := range partsOfSpeech {
+		if flags&byte(speech.part) == 0 {
+			continue
+		}
+
+		if speech.slot < 0 {
+			ret = append(ret, ZSpeech{Part: speech.part})
+			continue
+		}
+
+		value := word.Data[2]
+		if int(flags&0x03) == speech.slot {
+			value = word.Data[1]
+		}
+		ret = append(ret, ZSpeech{Part: speech.part, Value: value, HasValue: true})
+	}
+
+	return ret
+}
+
+// Value returns the value of the part of speech of the word
+func (word ZDictionaryWord) Value(part ZPartOfSpeech) (byte, bool) {
+	for _, speech := range word.Speech() {
+		if speech.Part == part {
+			return speech.Value, speech.HasValue
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,86 @@
+package gork
+
+import "testing"
+
+func TestNewZGrammar(t *testing.T) {
+	buf := make([]byte, 0x110)
+
+	// object 1 follows the property defaults, its properties
+	// are an empty name and the end byte
+	buf[0x7E+7], buf[0x7E+8] = 0x00, 0x87
+
+	copy(buf[0x8A:], []byte{
+		// verbs
+		0x00, 0x8E, 0x00, 0x97,
+		// 255: BRIEF
+		0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// 254: PUT OBJ IN OBJ
+		0x01, 0x02, 0x00, 0xF7, 0x00, 0x00, 0x88, 0x20, 0x01,
+		// actions
+		0x00, 0x80, 0x00, 0x81,
+		// pre-actions
+		0x00, 0x00, 0x00, 0x82,
+	})
+
+	header := &ZHeader{version: 3, objTblPos: 0x40, dictPos: 0xB0}
+	grammar, err := NewZGrammar(NewZMemory(buf), header)
+	if err != nil {
+		t.FailNow()
+	}
+
+	if grammar.VerbsPos != 0x8A || len(grammar.Verbs) != 2 {
+		t.FailNow()
+	}
+
+	brief, put := grammar.Verbs[0], grammar.Verbs[1]
+	if brief.Number != 255 || brief.Addr != 0x8E || len(brief.Syntaxes) != 1 || brief.Syntaxes[0].Objects != 0 {
+		t.Fail()
+	}
+	if put.Number != 254 || len(put.Syntaxes) != 1 {
+		t.FailNow()
+	}
+
+	syntax := put.Syntaxes[0]
+	if syntax.Objects != 2 || syntax.Preps[1] != 0xF7 || syntax.Action != 1 ||
+		syntax.Search[0] != SearchHeld|SearchTake || syntax.Search[1] != SearchInRoom {
+		t.Fail()
+	}
+	if syntax.Search[0].String() != "held take" {
+		t.Fail()
+	}
+
+	if len(grammar.Actions) != 2 || grammar.Actions[1] != 0x102 ||
+		grammar.PreActions[0] != 0 || grammar.PreActions[1] != 0x104 {
+		t.Fail()
+	}
+}
+
+func TestZWordSpeech(t *testing.T) {
+	for _, test := range []struct {
+		data     []byte
+		expected []ZSpeech
+	}{
+		{[]byte{0x80, 0x00, 0x00}, []ZSpeech{{SpeechObject, 0, false}}},
+		{[]byte{0x04, 0x00, 0x00}, []ZSpeech{{SpeechBuzzWord, 0, false}}},
+		// the verb has the first value
+		{[]byte{0xC1, 0xFE, 0x00}, []ZSpeech{{SpeechObject, 0, false}, {SpeechVerb, 0xFE, true}}},
+		// the preposition has the first value, the direction the second one
+		{[]byte{0x18, 0xF7, 0x1B}, []ZSpeech{{SpeechDirection, 0x1B, true}, {SpeechPreposition, 0xF7, true}}},
+		{[]byte{0x80}, nil},
+	} {
+		speech := ZDictionaryWord{Data: test.data}.Speech()
+		if len(speech) != len(test.expected) {
+			t.Fail()
+			continue
+		}
+		for i := range speech {
+			if speech[i] != test.expected[i] {
+				t.Fail()
+			}
+		}
+	}
+
+	if value, ok := (ZDictionaryWord{Data: []byte{0x41, 0xFE, 0x00}}).Value(SpeechVerb); !ok || value != 0xFE {
+		t.Fail()
+	}
+}
@@ -7,6 +7,9 @@ import (
 
 const (
 	SerialSize = 6
+
+	// the latest supported version
+	maxVersion = 8
 )
 
 // dynamic memory range: [0, dynMemSize)
@@ -26,6 +29,12 @@ type ZHeader struct {
 	abbrTblPos   uint16
 	fileLength   uint64
 	fileChecksum uint16
+	// v5
+	termCharsPos   uint16
+	alphabetTblPos uint16
+	extTblPos      uint16
+	// nil unless the story has its own alphabets or unicode table
+	customCharset *zcharset
 }
 
 func NewZHeader(mem *ZMemory) (*ZHeader, error) {
@@ -41,8 +50,12 @@ func (header *ZHeader) configure(mem *ZMemory) error {
 
 	header.version = seq.ReadByte()
 
-	if header.version > 3 {
-		return errors.New("versions > 3 are not supported!")
+	if header.version > maxVersion {
+		return fmt.Errorf("versions > %d are not supported!", maxVersion)
+	}
+
+	if header.version == 6 || header.version == 7 {
+		return fmt.Errorf("version %d is not supported!", header.version)
 	}
 
 	header.config = seq.ReadByte()
@@ -75,25 +88,103 @@ func (header *ZHeader) configure(mem *ZMemory) error {
 
 	header.abbrTblPos = seq.ReadWord()
 
-	// v3
-	header.fileLength = uint64(seq.ReadWord()) * 2
+	// v1 has no abbreviations
+	if header.version == 1 {
+		header.abbrTblPos = 0
+	}
+
+	header.fileLength = uint64(seq.ReadWord()) * header.fileLengthScale()
 
-	// v3 max file length 128K
-	if header.fileLength > 128*1024 {
+	if header.fileLength > header.maxFileLength() {
 		return errors.New("mem file too big!")
 	}
 
 	header.fileChecksum = seq.ReadWord()
 
+	// file length and checksum were introduced by v3
+	if header.version <= 2 {
+		header.fileLength = 0
+		header.fileChecksum = 0
+	}
+
+	if header.version >= 5 {
+		seq.pos = 0x2E
+		header.termCharsPos = seq.ReadWord()
+
+		seq.pos = 0x34
+		header.alphabetTblPos = seq.ReadWord()
+		header.extTblPos = seq.ReadWord()
+
+		header.customCharset = loadCharset(mem, header.alphabetTblPos, header.ExtensionWord(mem, 3))
+	}
+
 	return nil
 }
 
+// ExtensionWord returns the word #n (1-based) of the header
+// extension table, 0 if the table doesn't have it
+func (header *ZHeader) ExtensionWord(mem *ZMemory, n uint16) uint16 {
+	if header.extTblPos == 0 || mem.WordAt(uint32(header.extTblPos)) < n {
+		return 0
+	}
+	return mem.WordAt(uint32(header.extTblPos) + uint32(n)*2)
+}
+
+// fileLengthScale is the factor the file length in the header
+// is multiplied by
+func (header *ZHeader) fileLengthScale() uint64 {
+	switch {
+	case header.version <= 3:
+		return 2
+	case header.version <= 7:
+		return 4
+	}
+	return 8
+}
+
+func (header *ZHeader) maxFileLength() uint64 {
+	switch {
+	case header.version <= 3:
+		return 128 * 1024
+	case header.version <= 7:
+		return 256 * 1024
+	}
+	return 512 * 1024
+}
+
+// packedScale is the factor packed addresses are multiplied by
+func (header *ZHeader) packedScale() uint32 {
+	switch {
+	case header.version <= 3:
+		return 2
+	case header.version <= 7:
+		return 4
+	}
+	return 8
+}
+
+// PackedAddress converts a packed address to a byte address
+func (header *ZHeader) PackedAddress(addr uint32) uint32 {
+	return addr * header.packedScale()
+}
+
+func (header *ZHeader) IsPackedAddress(addr uint32) bool {
+	return addr%header.packedScale() == 0
+}
+
+func (header *ZHeader) Version() byte {
+	return header.version
+}
+
 func (header *ZHeader) String() string {
 	ret := "\n    **** Story file header ****\n\n"
 	ret += fmt.Sprintf("  Z-code version:           %d\n", header.version)
 
 	ret += fmt.Sprint("  Interpreter flags:        ")
-	if header.config&0x01 == 0x01 {
+	if header.version >= 4 {
+		// bits are set by the interpreter
+		ret += fmt.Sprintf("%02x\n", header.config)
+	} else if header.config&0x02 == 0x02 {
 		ret += fmt.Sprintln("Display hours:min")
 	} else {
 		ret += fmt.Sprintln("Display score/turns")
@@ -107,9 +198,19 @@ func (header *ZHeader) String() string {
 	ret += fmt.Sprintf("  Global variables address: %04x\n", header.globalsPos)
 	ret += fmt.Sprintf("  Size of dynamic memory:   %04x\n", header.dynMemSize)
 	ret += fmt.Sprintf("  Serial number:            %c%c%c%c%c%c\n", header.serial[0], header.serial[1], header.serial[2], header.serial[3], header.serial[4], header.serial[5])
-	ret += fmt.Sprintf("  Abbreviations address:    %04x\n", header.abbrTblPos)
-	ret += fmt.Sprintf("  File size:                %05x\n", header.fileLength)
-	ret += fmt.Sprintf("  Checksum:                 %04x\n", header.fileChecksum)
+	if header.version != 1 {
+		ret += fmt.Sprintf("  Abbreviations address:    %04x\n", header.abbrTblPos)
+	}
+	if header.version >= 3 {
+		ret += fmt.Sprintf("  File size:                %05x\n", header.fileLength)
+		ret += fmt.Sprintf("  Checksum:                 %04x\n", header.fileChecksum)
+	}
+
+	if header.version >= 5 {
+		ret += fmt.Sprintf("  Terminating keys address: %04x\n", header.termCharsPos)
+		ret += fmt.Sprintf("  Alphabet address:         %04x\n", header.alphabetTblPos)
+		ret += fmt.Sprintf("  Header extension address: %04x\n", header.extTblPos)
+	}
 
 	return ret
 }
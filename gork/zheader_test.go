@@ -35,11 +35,95 @@ var expectedHeader ZHeader = ZHeader{
 	fileChecksum: 0xA129,
 }
 
+func TestPackedAddres(t *testing.T) {
+	for version := byte(1); version <= maxVersion; version++ {
+		header := &ZHeader{version: version}
+		for i := uint32(0); i < 10; i++ {
+			if !header.IsPackedAddress(header.PackedAddress(i)) {
+				t.Fail()
+			}
+		}
+	}
+
+	if (&ZHeader{version: 4}).PackedAddress(3) != 12 ||
+		(&ZHeader{version: 8}).PackedAddress(0x8000) != 0x40000 {
+		t.Fail()
+	}
+}
+
+func TestZHeaderFileLength(t *testing.T) {
+	versions := []byte{3, 5, 8}
+	expected := []uint64{0xA5C6 * 2, 0xA5C6 * 4, 0xA5C6 * 8}
+
+	for i, version := range versions {
+		buf := make([]byte, 0x40)
+		copy(buf, headerBuf)
+		buf[0] = version
+
+		mem := *NewZMemory(buf)
+		header, err := NewZHeader(&mem)
+		if err != nil || header.fileLength != expected[i] {
+			t.Fail()
+		}
+	}
+
+	buf := make([]byte, 0x40)
+	copy(buf, headerBuf)
+	mem := *NewZMemory(buf)
+
+	for _, version := range []byte{6, 7, 9} {
+		buf[0] = version
+		if _, err := NewZHeader(&mem); err == nil {
+			t.Fail()
+		}
+	}
+}
+
 func TestZHeaderConfigure(t *testing.T) {
-	mem := ZMemory(headerBuf)
+	mem := *NewZMemory(headerBuf)
 	header, err := NewZHeader(&mem)
 
 	if err != nil || *header != expectedHeader {
 		t.Fail()
 	}
 }
+
+func TestZHeaderV5(t *testing.T) {
+	buf := make([]byte, 0x100)
+	copy(buf, headerBuf)
+	buf[0] = 5
+
+	// alphabet table at 0x40, A0 is upper case
+	buf[0x34], buf[0x35] = 0x00, 0x40
+	copy(buf[0x40:], Alphabets[1]+Alphabets[0]+Alphabets[2])
+
+	// header extension table at 0x90 with the unicode table at 0xA0
+	buf[0x36], buf[0x37] = 0x00, 0x90
+	copy(buf[0x90:], []byte{0x00, 0x03, 0, 0, 0, 0, 0x00, 0xA0})
+	copy(buf[0xA0:], []byte{2, 0x20, 0xAC, 0x00, 0xE9})
+
+	buf[0x2E], buf[0x2F] = 0x00, 0xB0
+
+	mem := *NewZMemory(buf)
+	header, err := NewZHeader(&mem)
+	if err != nil {
+		t.FailNow()
+	}
+
+	if header.termCharsPos != 0xB0 || header.extTblPos != 0x90 ||
+		header.ExtensionWord(&mem, 3) != 0xA0 || header.ExtensionWord(&mem, 4) != 0 {
+		t.Fail()
+	}
+
+	if header.charset().alphabets[0][0] != 'A' || header.charset().alphabets[2][1] != '\n' {
+		t.Fail()
+	}
+
+	if header.ZSCIIToRune(155) != '€' || header.ZSCIIToRune(156) != 'é' || header.ZSCIIToRune(157) != '?' {
+		t.Fail()
+	}
+
+	if c, ok := header.RuneToZSCII('é'); !ok || c != 156 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,228 @@
+package gork
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The types of this file describe the tables of a story as plain
+// values, their JSON form is meant to be read by scripts so fields
+// are never renamed and empty lists are [] rather than null
+
+// ZBytes is raw story data, in JSON it's an array of numbers
+// rather than a base64 string
+type ZBytes []byte
+
+func (data ZBytes) MarshalJSON() ([]byte, error) {
+	values := make([]int, len(data))
+	for i, b := range data {
+		values[i] = int(b)
+	}
+	return json.Marshal(values)
+}
+
+func (data *ZBytes) UnmarshalJSON(text []byte) error {
+	var values []int
+	if err := json.Unmarshal(text, &values); err != nil {
+		return err
+	}
+
+	*data = make(ZBytes, len(values))
+	for i, v := range values {
+		if v < 0 || v > 0xFF {
+			return fmt.Errorf("%d is not a byte", v)
+		}
+		(*data)[i] = byte(v)
+	}
+	return nil
+}
+
+// ZHeaderInfo is the header of a story, fields the version
+// doesn't have are 0
+type ZHeaderInfo struct {
+	Version         byte   `json:"version"`
+	Flags           byte   `json:"flags"`
+	Release         uint16 `json:"release"`
+	HighStart       uint16 `json:"high_start"`
+	PC              uint16 `json:"pc"`
+	Dictionary      uint16 `json:"dictionary"`
+	ObjectTable     uint16 `json:"object_table"`
+	Globals         uint16 `json:"globals"`
+	DynamicSize     uint16 `json:"dynamic_size"`
+	Serial          string `json:"serial"`
+	Abbreviations   uint16 `json:"abbreviations"`
+	FileLength      uint64 `json:"file_length"`
+	Checksum        uint16 `json:"checksum"`
+	TerminatingKeys uint16 `json:"terminating_keys"`
+	Alphabet        uint16 `json:"alphabet"`
+	Extension       uint16 `json:"extension"`
+}
+
+func (header *ZHeader) Info() ZHeaderInfo {
+	return ZHeaderInfo{
+		Version:         header.version,
+		Flags:           header.config,
+		Release:         header.release,
+		HighStart:       header.highStart,
+		PC:              header.pc,
+		Dictionary:      header.dictPos,
+		ObjectTable:     header.objTblPos,
+		Globals:         header.globalsPos,
+		DynamicSize:     header.dynMemSize,
+		Serial:          string(header.serial[:]),
+		Abbreviations:   header.abbrTblPos,
+		FileLength:      header.fileLength,
+		Checksum:        header.fileChecksum,
+		TerminatingKeys: header.termCharsPos,
+		Alphabet:        header.alphabetTblPos,
+		Extension:       header.extTblPos,
+	}
+}
+
+// ZPropertyInfo is a property of an object with its raw data
+type ZPropertyInfo struct {
+	Number byte   `json:"number"`
+	Addr   uint32 `json:"addr"`
+	Data   ZBytes `json:"data"`
+}
+
+// ZObjectInfo is an entry of the object table
+type ZObjectInfo struct {
+	Id         uint16          `json:"id"`
+	Name       string          `json:"name"`
+	Attributes []uint16        `json:"attributes"`
+	Parent     uint16          `json:"parent"`
+	Sibling    uint16          `json:"sibling"`
+	Child      uint16          `json:"child"`
+	Properties []ZPropertyInfo `json:"properties"`
+	// address of the property table
+	PropertiesAddr uint16 `json:"properties_addr"`
+}
+
+func (obj *ZObject) Info() ZObjectInfo {
+	info := ZObjectInfo{
+		Id:             obj.Id(),
+		Name:           obj.Name(),
+		Attributes:     []uint16{},
+		Parent:         obj.ParentId(),
+		Sibling:        obj.SiblingId(),
+		Child:          obj.ChildId(),
+		Properties:     []ZPropertyInfo{},
+		PropertiesAddr: obj.PropertiesPos(),
+	}
+
+	for i := uint16(0); i < obj.AttributesCount(); i++ {
+		if obj.Attribute(i) {
+			info.Attributes = append(info.Attributes, i)
+		}
+	}
+
+	for _, k := range obj.PropertiesIds() {
+		info.Properties = append(info.Properties, ZPropertyInfo{
+			Number: k,
+			Addr:   obj.GetPropertyAddr(k),
+			Data:   obj.PropertyData(k),
+		})
+	}
+
+	return info
+}
+
+// ZObjectInfos returns every object of the story
+func ZObjectInfos(mem *ZMemory, header *ZHeader) ([]ZObjectInfo, error) {
+	total, err := ZObjectsCount(mem, header)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]ZObjectInfo, 0, total)
+	for i := uint16(1); i <= total; i++ {
+		obj, err := NewZObject(mem, i, header)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, obj.Info())
+	}
+
+	return ret, nil
+}
+
+// ZObjectNode is an object of the object tree
+type ZObjectNode struct {
+	Id       uint16        `json:"id"`
+	Name     string        `json:"name"`
+	Children []ZObjectNode `json:"children"`
+}
+
+// ZObjectTree returns the objects without parent with their
+// descendants, in the order of the object table
+func ZObjectTree(mem *ZMemory, header *ZHeader) ([]ZObjectNode, error) {
+	total, err := ZObjectsCount(mem, header)
+	if err != nil {
+		return nil, err
+	}
+
+	// a broken sibling chain could go on forever
+	visited := map[uint16]bool{}
+
+	var children func(first uint16) ([]ZObjectNode, error)
+	children = func(first uint16) ([]ZObjectNode, error) {
+		ret := []ZObjectNode{}
+		for id := first; id != NULL_OBJECT_INDEX && id <= total && !visited[id]; {
+			visited[id] = true
+
+			obj, err := NewZObject(mem, id, header)
+			if err != nil {
+				return nil, err
+			}
+
+			node := ZObjectNode{Id: id, Name: obj.Name()}
+			if node.Children, err = children(obj.ChildId()); err != nil {
+				return nil, err
+			}
+			ret = append(ret, node)
+
+			id = obj.SiblingId()
+		}
+		return ret, nil
+	}
+
+	ret := []ZObjectNode{}
+	for i := uint16(1); i <= total; i++ {
+		obj, err := NewZObject(mem, i, header)
+		if err != nil {
+			return nil, err
+		}
+
+		if obj.ParentId() == NULL_OBJECT_INDEX && !visited[i] {
+			visited[i] = true
+
+			node := ZObjectNode{Id: i, Name: obj.Name()}
+			if node.Children, err = children(obj.ChildId()); err != nil {
+				return nil, err
+			}
+			ret = append(ret, node)
+		}
+	}
+
+	return ret, nil
+}
+
+// ZDictionaryInfo is the dictionary with the data of its words
+type ZDictionaryInfo struct {
+	Addr       uint32            `json:"addr"`
+	Separators string            `json:"separators"`
+	EntrySize  uint8             `json:"entry_size"`
+	Sorted     bool              `json:"sorted"`
+	Words      []ZDictionaryWord `json:"words"`
+}
+
+func (dict *ZDictionary) Info() ZDictionaryInfo {
+	return ZDictionaryInfo{
+		Addr:       dict.addr,
+		Separators: string(dict.wordSeparators),
+		EntrySize:  dict.entrySize,
+		Sorted:     dict.sorted,
+		Words:      dict.Words(),
+	}
+}
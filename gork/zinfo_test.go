@@ -0,0 +1,67 @@
+package gork
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestZBytesJSON(t *testing.T) {
+	out, err := json.Marshal(ZBytes{0x00, 0x82, 0xFF})
+	if err != nil || string(out) != "[0,130,255]" {
+		t.Fail()
+	}
+
+	var data ZBytes
+	if err := json.Unmarshal(out, &data); err != nil || len(data) != 3 || data[1] != 0x82 {
+		t.Fail()
+	}
+
+	if err := json.Unmarshal([]byte("[256]"), &data); err == nil {
+		t.Fail()
+	}
+}
+
+func TestZObjectInfo(t *testing.T) {
+	mem, header, _ := prelude()
+
+	obj, err := NewZObject(mem, 2, header)
+	if err != nil {
+		t.FailNow()
+	}
+
+	info := obj.Info()
+	if info.Id != 2 || info.Name != "zork" || info.Parent != 1 || info.PropertiesAddr != 0x64 {
+		t.Fail()
+	}
+
+	if len(info.Attributes) != 3 || info.Attributes[0] != 7 || info.Attributes[2] != 23 {
+		t.Fail()
+	}
+
+	if len(info.Properties) != 2 || info.Properties[0].Number != 18 || info.Properties[1].Number != 16 ||
+		len(info.Properties[1].Data) != 2 || info.Properties[1].Data[1] != 0x21 {
+		t.Fail()
+	}
+}
+
+func TestZObjectTree(t *testing.T) {
+	buf := createZObjectBuf()
+	objectAt := func(id int) int { return 31*2 + (id-1)*int(zobjectLayoutV3.size) }
+
+	// 1 has the child 3 and 2 is another root
+	buf[objectAt(1)+6] = 3
+	buf[objectAt(2)+4] = 0
+	buf[objectAt(3)+5] = 0
+
+	tree, err := ZObjectTree(NewZMemory(buf), &ZHeader{objTblPos: 0x00})
+	if err != nil || len(tree) != 2 {
+		t.FailNow()
+	}
+
+	if tree[0].Id != 1 || len(tree[0].Children) != 1 || tree[0].Children[0].Name != "cyclop" {
+		t.Fail()
+	}
+	if tree[1].Id != 2 || len(tree[1].Children) != 0 {
+		t.Fail()
+	}
+}
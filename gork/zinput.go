@@ -0,0 +1,166 @@
+package gork
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+)
+
+type zlineResult struct {
+	line string
+	err  error
+}
+
+// zinput reads lines from a ZIODev, optionally giving up after a
+// timeout, the line being read when a timeout expires is not lost and
+// it is returned by the next read
+type zinput struct {
+	iodev ZIODev
+	// not nil while a read is in flight
+	pending chan zlineResult
+
+	// input stream 1, lines are taken from it until it's exhausted
+	commands     *bufio.Scanner
+	commandsFile io.Closer
+}
+
+func newZInput(iodev ZIODev) *zinput {
+	return &zinput{iodev: iodev}
+}
+
+// readLine blocks until a line is read or timeout expires,
+// a timeout of 0 means no timeout
+func (in *zinput) readLine(timeout time.Duration) (line string, timedOut bool, err error) {
+	if in.pending == nil {
+		if timeout == 0 {
+			line, err = in.iodev.ReadLine()
+			return line, false, err
+		}
+
+		in.pending = make(chan zlineResult, 1)
+		go func(pending chan<- zlineResult) {
+			line, err := in.iodev.ReadLine()
+			pending <- zlineResult{line, err}
+		}(in.pending)
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+
+	select {
+	case res := <-in.pending:
+		in.pending = nil
+		return res.line, false, res.err
+	case <-expired:
+		return "", true, nil
+	}
+}
+
+// setCommands selects input stream 1 reading lines from r,
+// nil goes back to the keyboard
+func (in *zinput) setCommands(r io.ReadCloser) {
+	if in.commandsFile != nil {
+		in.commandsFile.Close()
+	}
+
+	in.commands, in.commandsFile = nil, nil
+	if r != nil {
+		in.commands, in.commandsFile = bufio.NewScanner(r), r
+	}
+}
+
+// readCommand returns the next line of input stream 1, ok is false
+// when it's not selected, it falls back to the keyboard once exhausted
+func (in *zinput) readCommand() (line string, ok bool) {
+	if in.commands == nil {
+		return "", false
+	}
+
+	if !in.commands.Scan() {
+		in.setCommands(nil)
+		return "", false
+	}
+	return strings.TrimRight(in.commands.Text(), "\r"), true
+}
+
+// escape sequences terminals send for the ZSCII function keys
+var functionKeySequences = []struct {
+	seq string
+	key uint16
+}{
+	{"\x1b[A", 129}, {"\x1b[B", 130}, {"\x1b[D", 131}, {"\x1b[C", 132},
+	{"\x1bOP", 133}, {"\x1bOQ", 134}, {"\x1bOR", 135}, {"\x1bOS", 136},
+}
+
+// functionKeyAt returns the ZSCII code of the function key whose
+// escape sequence starts line, 0 if there is none
+func functionKeyAt(line string) (key uint16, length int) {
+	for _, fk := range functionKeySequences {
+		if strings.HasPrefix(line, fk.seq) {
+			return fk.key, len(fk.seq)
+		}
+	}
+	return 0, 0
+}
+
+// isTerminatingKey tells if key ends v5 aread, according to the
+// terminating characters table
+func (zm *ZMachine) isTerminatingKey(key uint16) bool {
+	if zm.header.version < 5 || zm.header.termCharsPos == 0 {
+		return false
+	}
+
+	for addr := uint32(zm.header.termCharsPos); ; addr++ {
+		c := uint16(zm.seq.mem.ByteAt(addr))
+		switch {
+		case c == 0:
+			return false
+		case c == key:
+			return true
+		case c == 255 && (key >= 129 && key <= 154 || key >= 252 && key <= 254):
+			// any function key
+			return true
+		}
+	}
+}
+
+// splitTerminator returns the part of line before the key that
+// terminated it, which is a newline unless it's a terminating key
+func (zm *ZMachine) splitTerminator(line string) (string, uint16) {
+	for i := 0; i < len(line); i++ {
+		if line[i] != 0x1b {
+			continue
+		}
+
+		if key, _ := functionKeyAt(line[i:]); key != 0 && zm.isTerminatingKey(key) {
+			return line[:i], key
+		}
+	}
+	return line, 13
+}
+
+// inputToZSCII converts typed text to lower case ZSCII,
+// characters without a ZSCII code become '?'
+func (zm *ZMachine) inputToZSCII(s string) []byte {
+	ret := []byte{}
+	for _, r := range strings.ToLower(s) {
+		c, ok := zm.header.RuneToZSCII(r)
+		if !ok || c > 0xFF || c == 13 {
+			c = '?'
+		}
+		ret = append(ret, byte(c))
+	}
+	return ret
+}
+
+// zsciiToString is the inverse of inputToZSCII
+func (zm *ZMachine) zsciiToString(text []byte) string {
+	ret := make([]rune, len(text))
+	for i, c := range text {
+		ret[i] = zm.header.ZSCIIToRune(uint16(c))
+	}
+	return string(ret)
+}
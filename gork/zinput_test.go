@@ -0,0 +1,62 @@
+package gork
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+// slowIODev returns a line only once it's sent on lines
+type slowIODev struct {
+	lines chan string
+}
+
+func (_ *slowIODev) Print(...interface{}) {}
+func (_ *slowIODev) Render(_ *ZScreen)    {}
+
+func (dev *slowIODev) ReadLine() (string, error) {
+	return <-dev.lines, nil
+}
+
+func TestZInputTimeout(t *testing.T) {
+	dev := &slowIODev{lines: make(chan string)}
+	in := newZInput(dev)
+
+	if _, timedOut, err := in.readLine(time.Millisecond); !timedOut || err != nil {
+		t.Fail()
+	}
+
+	// the line read after the timeout must not be lost
+	dev.lines <- "look"
+
+	line, timedOut, err := in.readLine(time.Second)
+	if line != "look" || timedOut || err != nil {
+		t.Fail()
+	}
+}
+
+func TestZInputCommands(t *testing.T) {
+	dev := &scriptedIODev{lines: []string{"inventory"}}
+	zm := &ZMachine{
+		iodev:   dev,
+		input:   newZInput(dev),
+		screen:  NewZScreen(80, 25),
+		streams: zstreams{screen: true},
+		logger:  nullLogger{},
+	}
+
+	zm.input.setCommands(ioutil.NopCloser(strings.NewReader("open mailbox\r\nread leaflet\n")))
+
+	expected := []string{"open mailbox", "read leaflet", "inventory"}
+	for _, e := range expected {
+		if line, aborted := zm.readLine(0, 0); line != e || aborted {
+			t.Fail()
+		}
+	}
+
+	// replayed lines are shown as if they had been typed
+	if dev.output != "open mailbox\nread leaflet\n" {
+		t.Fail()
+	}
+}
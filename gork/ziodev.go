@@ -2,6 +2,7 @@ package gork
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -13,9 +14,128 @@ import (
 
 type ZIODev interface {
 	Print(...interface{})
-	ReadLine() string
+	ReadLine() (string, error)
+	// Render draws what changed on the screen since the last call,
+	// it's called before reading input and when the story stops
+	Render(*ZScreen)
 }
 
+// ZStatusLine is the content of the status line
+type ZStatusLine struct {
+	Location string
+	// time games show hours and minutes instead of score and moves
+	TimeGame bool
+	Score    int16
+	Moves    uint16
+	Hours    uint16
+	Minutes  uint16
+}
+
+// Right returns the right hand side of the status line
+func (status *ZStatusLine) Right() string {
+	if !status.TimeGame {
+		return fmt.Sprintf("Score: %d  Moves: %d", status.Score, status.Moves)
+	}
+
+	hours, suffix := status.Hours%12, "AM"
+	if status.Hours%24 >= 12 {
+		suffix = "PM"
+	}
+	if hours == 0 {
+		hours = 12
+	}
+	return fmt.Sprintf("Time: %d:%02d %s", hours, status.Minutes, suffix)
+}
+
+// Format lays the status line out over width columns,
+// the location is truncated if needed
+func (status *ZStatusLine) Format(width int) string {
+	if width < 0 {
+		width = 0
+	}
+
+	right := []rune(status.Right() + " ")
+	left := []rune(" " + status.Location)
+
+	// narrow screens keep the start of the right hand side only
+	if len(right) > width {
+		right = right[:width]
+	}
+
+	if max := width - len(right) - 1; len(left) > max {
+		if max < 0 {
+			max = 0
+		}
+		left = left[:max]
+	}
+
+	spaces := width - len(left) - len(right)
+	if spaces < 0 {
+		spaces = 0
+	}
+	return string(left) + strings.Repeat(" ", spaces) + string(right)
+}
+
+// ansiStyle returns the escape sequence selecting style
+func ansiStyle(style uint16) string {
+	codes := "0"
+	if style&StyleBold != 0 {
+		codes += ";1"
+	}
+	if style&StyleItalic != 0 {
+		codes += ";3"
+	}
+	if style&StyleReverse != 0 {
+		codes += ";7"
+	}
+	return "\x1b[" + codes + "m"
+}
+
+// renderANSI returns what ANSI terminals need to draw screen, the upper
+// window stays on top because only the lines below it are scrolled
+func renderANSI(screen *ZScreen, newline string) string {
+	ret := ""
+
+	spans, cleared := screen.TakeLower()
+	if cleared {
+		ret += "\x1b[2J"
+	}
+
+	if upper, changed := screen.Upper(); changed {
+		if len(upper) == 0 {
+			// the whole screen scrolls again
+			ret += "\x1b[r"
+		} else {
+			ret += fmt.Sprintf("\x1b[%d;%dr", len(upper)+1, screen.Height)
+			for i, line := range upper {
+				ret += fmt.Sprintf("\x1b[%d;1H", i+1)
+				for _, cell := range line {
+					ret += ansiStyle(cell.Style) + string(cell.Char)
+				}
+				ret += ansiReset
+			}
+		}
+		// lower window text is printed at the bottom
+		ret += fmt.Sprintf("\x1b[%d;1H", screen.Height)
+	} else if cleared {
+		ret += fmt.Sprintf("\x1b[%d;1H", screen.Height)
+	}
+
+	for _, span := range spans {
+		text := strings.Replace(span.Text, "\n", newline, -1)
+		if span.Style == StyleRoman {
+			ret += text
+		} else {
+			ret += ansiStyle(span.Style) + text + ansiReset
+		}
+	}
+
+	return ret
+}
+
+// resets the style
+const ansiReset = "\x1b[0m"
+
 type ZTerminal struct{}
 
 func (_ ZTerminal) Print(s ...interface{}) {
@@ -24,15 +144,20 @@ func (_ ZTerminal) Print(s ...interface{}) {
 	}
 }
 
-func (_ ZTerminal) ReadLine() string {
+func (_ ZTerminal) Render(screen *ZScreen) {
+	fmt.Print(renderANSI(screen, "\n"))
+}
+
+func (_ ZTerminal) ReadLine() (string, error) {
 	r := bufio.NewReader(os.Stdin)
 
 	s, err := r.ReadString('\n')
-	if err != nil && err != io.EOF {
-		panic(err)
+	if err == io.EOF && s != "" {
+		// last line without newline
+		err = nil
 	}
 
-	return s
+	return s, err
 }
 
 type ZSshTerminal struct {
@@ -47,14 +172,12 @@ func (sshTerm ZSshTerminal) Print(s ...interface{}) {
 	}
 }
 
-func (sshTerm ZSshTerminal) ReadLine() string {
-	l, err := sshTerm.Term.ReadLine()
-
-	if err != nil {
-		panic(err)
-	}
+func (sshTerm ZSshTerminal) Render(screen *ZScreen) {
+	sshTerm.Term.Write([]byte(renderANSI(screen, "\r\n")))
+}
 
-	return l
+func (sshTerm ZSshTerminal) ReadLine() (string, error) {
+	return sshTerm.Term.ReadLine()
 }
 
 type ZWSDev struct {
@@ -68,12 +191,34 @@ func (ws *ZWSDev) Print(s ...interface{}) {
 	}
 }
 
-func (ws *ZWSDev) ReadLine() string {
+// Render sends the upper window, when it changes, and
+// then the lower window text as plain text
+func (ws *ZWSDev) Render(screen *ZScreen) {
+	text := ""
+
+	if upper, changed := screen.Upper(); changed && len(upper) > 0 {
+		text += strings.Join(screen.UpperText(), "\n") + "\n"
+	}
+
+	spans, _ := screen.TakeLower()
+	for _, span := range spans {
+		text += span.Text
+	}
+
+	if text != "" {
+		ws.Conn.WriteMessage(websocket.TextMessage, []byte(text))
+	}
+}
+
+func (ws *ZWSDev) ReadLine() (string, error) {
 	msg_type, l, err := ws.Conn.ReadMessage()
+	if err != nil {
+		return "", err
+	}
 
-	if msg_type != websocket.TextMessage || err != nil {
-		panic(err)
+	if msg_type != websocket.TextMessage {
+		return "", errors.New("websocket message is not text")
 	}
 
-	return string(l)
+	return string(l), nil
 }
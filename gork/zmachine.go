@@ -1,6 +1,9 @@
 package gork
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // bottom is in #0
 // top is in #len(stack-1)
@@ -21,155 +24,537 @@ func (zstack *ZStack) Top() *ZRoutine {
 	return (*zstack)[len(*zstack)-1]
 }
 
+// clone copies the routines, locals and evaluation stacks included
+func (zstack ZStack) clone() ZStack {
+	ret := make(ZStack, len(zstack))
+	for i, routine := range zstack {
+		copied := *routine
+		copied.locals = append([]uint16{}, routine.locals...)
+		copied.stack = append([]uint16{}, routine.stack...)
+		ret[i] = &copied
+	}
+	return ret
+}
+
 type ZLogger interface {
 	Print(...interface{})
 	Printf(string, ...interface{})
-	Panic(...interface{})
 }
 
 type ZMachine struct {
 	header *ZHeader
 	// pc is seq.pos
-	seq        *ZMemorySequential
-	objects    []*ZObject
-	dictionary *ZDictionary
-	iodev      ZIODev
-	stack      ZStack
-	logger     ZLogger
-	quitted    bool
+	seq          *ZMemorySequential
+	objectsCount uint16
+	dictionary   *ZDictionary
+	iodev        ZIODev
+	stack        ZStack
+	logger       ZLogger
+	quitted      bool
+	// error raised by the instruction being executed
+	err     error
+	streams zstreams
+	screen  *ZScreen
+	// v5 font selected by set_font
+	font uint16
+	// snapshots of the previous turns and of the undone ones
+	undo zsnapshots
+	redo zsnapshots
+	// set when going back to a turn by undo or redo
+	resumed bool
+	// instruction being executed and its address
+	op     *ZOp
+	opPos  uint32
+	meta   *zmeta
+	random zrandom
+	// dynamic memory as it was when the story has been loaded
+	original   []byte
+	input      *zinput
+	violations zviolations
+	// where the files named by the player are kept, see SaveDir
+	saveDir string
 }
 
-func NewZMachine(mem *ZMemory, header *ZHeader, iodev ZIODev, logger ZLogger) (*ZMachine, error) {
-	// cache objects
+const (
+	// the interpreter claims to be an IBM PC one
+	interpreterNumber  = 6
+	interpreterVersion = 'A'
+
+	screenHeight = 25
+	screenWidth  = 80
+)
+
+// ZOption configures a ZMachine, see NewZMachine
+type ZOption func(*ZMachine)
+
+func NewZMachine(mem *ZMemory, header *ZHeader, iodev ZIODev, logger ZLogger, opts ...ZOption) (*ZMachine, error) {
+	stack := ZStack{}
+	stack.Push(MainRoutine(mem, header))
+
+	original := make([]byte, header.dynMemSize)
+	copy(original, mem.Slice(0, uint32(header.dynMemSize)))
+
 	count, err := ZObjectsCount(mem, header)
 	if err != nil {
 		return nil, err
 	}
 
-	objects := make([]*ZObject, count)
+	zm := &ZMachine{
+		header:       header,
+		seq:          mem.GetSequential(uint32(header.pc)),
+		objectsCount: count,
+		dictionary:   NewZDictionary(mem, header),
+		iodev:        iodev,
+		logger:       logger,
+		quitted:      false,
+		stack:        stack,
+		original:     original,
+		input:        newZInput(iodev),
+		screen:       NewZScreen(screenWidth, screenHeight),
+		streams:      zstreams{screen: true},
+		undo:         zsnapshots{depth: defaultUndoDepth},
+		redo:         zsnapshots{depth: defaultUndoDepth},
+		meta:         newZMeta(),
+	}
+	zm.random.randomize()
 
-	for i := uint8(1); i <= count; i++ {
-		// objects are 1-based
-		obj, err := NewZObject(mem, i, header)
-		if err != nil {
-			return nil, err
+	for _, opt := range opts {
+		opt(zm)
+	}
+
+	zm.resetScreen()
+	zm.initHeader()
+
+	return zm, nil
+}
+
+// initHeader fills the header fields the interpreter is
+// responsible for
+func (zm *ZMachine) initHeader() {
+	mem := zm.seq.mem
+
+	if zm.header.version <= 3 {
+		// the status line is available
+		mem.setHeaderByte(0x01, mem.ByteAt(0x01)&^0x10)
+		return
+	}
+
+	// timed keyboard input is available
+	mem.setHeaderByte(0x01, mem.ByteAt(0x01)|0x80)
+
+	mem.setHeaderByte(0x1E, interpreterNumber)
+	mem.setHeaderByte(0x1F, interpreterVersion)
+	mem.setHeaderByte(0x20, screenHeight)
+	mem.setHeaderByte(0x21, screenWidth)
+
+	if zm.header.version < 5 {
+		return
+	}
+
+	// screen size in units, a unit being a character
+	mem.setHeaderWord(0x22, screenWidth)
+	mem.setHeaderWord(0x24, screenHeight)
+	mem.setHeaderByte(0x26, 1)
+	mem.setHeaderByte(0x27, 1)
+
+	// default background and foreground colours
+	mem.setHeaderByte(0x2C, 2)
+	mem.setHeaderByte(0x2D, 9)
+
+	// pictures, mouse, colours and sounds are not available, undo is
+	// unless it's disabled
+	flags2 := mem.ByteAt(0x11)
+	flags2 &^= 0x08 | 0x20 | 0x40 | 0x80
+	if zm.undo.depth <= 0 {
+		flags2 &^= 0x10
+	}
+	mem.setHeaderByte(0x11, flags2)
+}
+
+func (zm *ZMachine) resetScreen() {
+	zm.screen.Reset()
+	zm.font = 1
+}
+
+// Restart reloads dynamic memory from the story and starts
+// executing it again from the beginning
+func (zm *ZMachine) Restart() {
+	zm.loadDynamicMemory(zm.original)
+
+	zm.stack = ZStack{}
+	zm.stack.Push(MainRoutine(zm.seq.mem, zm.header))
+	zm.seq.pos = uint32(zm.header.pc)
+
+	zm.resetScreen()
+	zm.initHeader()
+}
+
+// loadDynamicMemory replaces dynamic memory with dynMem except for
+// Flags 2, which belongs to the interpreter
+func (zm *ZMachine) loadDynamicMemory(dynMem []byte) {
+	mem := zm.seq.mem.Slice(0, uint32(len(dynMem)))
+
+	flags2 := [2]byte{mem[0x10], mem[0x11]}
+	copy(mem, dynMem)
+	mem[0x10], mem[0x11] = flags2[0], flags2[1]
+}
+
+// Verify sums the bytes of the story file from 0x40 up to its
+// length and compares the result with the checksum in the header
+func (zm *ZMachine) Verify() bool {
+	mem := zm.seq.mem
+
+	// v1 and v2 don't store the length of the file
+	end := zm.header.fileLength
+	if end == 0 || end > uint64(mem.Len()) {
+		end = uint64(mem.Len())
+	}
+
+	sum := uint16(0)
+	for addr := uint64(0x40); addr < end; addr++ {
+		// dynamic memory could have been changed by the story
+		if addr < uint64(len(zm.original)) {
+			sum += uint16(zm.original[addr])
+		} else {
+			sum += uint16(mem.ByteAt(uint32(addr)))
 		}
-		objects[i-1] = obj
 	}
 
-	stack := ZStack{}
-	stack.Push(MainRoutine(mem, header))
+	return sum == zm.header.fileChecksum
+}
 
-	return &ZMachine{
-		header:     header,
-		seq:        mem.GetSequential(uint32(header.pc)),
-		objects:    objects,
-		dictionary: NewZDictionary(mem, header),
-		iodev:      iodev,
-		logger:     logger,
-		quitted:    false,
-		stack:      stack,
-	}, nil
+// render lets the io device draw what has been printed so far
+func (zm *ZMachine) render() {
+	zm.iodev.Render(zm.screen)
 }
 
+// showStatus draws the v1-3 status line above the upper window
+func (zm *ZMachine) showStatus() {
+	status := zm.statusLine()
+	if status == nil {
+		return
+	}
+
+	zm.screen.SetStatus(status.Format(zm.screen.Width))
+	zm.render()
+}
+
+// statusLine returns the content of the v1-3 status line, nil for later
+// versions: the location is the object in global 0 and score and moves
+// (hours and minutes) are in 1 and 2
+func (zm *ZMachine) statusLine() *ZStatusLine {
+	if zm.header.version > 3 {
+		return nil
+	}
+
+	status := &ZStatusLine{
+		// Flags 1 bit 1 marks time games
+		TimeGame: zm.header.config&0x02 != 0,
+	}
+
+	location := zm.GetIndirectVarAt(0x10)
+	if location != 0 && location <= zm.objectsCount {
+		if obj, err := NewZObject(zm.seq.mem, location, zm.header); err == nil {
+			status.Location = obj.Name()
+		}
+	}
+
+	first, second := zm.GetIndirectVarAt(0x11), zm.GetIndirectVarAt(0x12)
+	if status.TimeGame {
+		status.Hours, status.Minutes = first, second
+	} else {
+		status.Score, status.Moves = int16(first), second
+	}
+
+	return status
+}
+
+// object returns the object objectId or nil, after having raised
+// a fault or a violation, if it doesn't exist
+func (zm *ZMachine) object(objectId uint16) *ZObject {
+	if objectId == 0 {
+		zm.violatef(ObjectZero, "invalid object 0")
+		return nil
+	}
+	if objectId > zm.objectsCount {
+		zm.faultf("invalid object %d", objectId)
+		return nil
+	}
+
+	obj, err := NewZObject(zm.seq.mem, objectId, zm.header)
+	if err != nil {
+		zm.fault(err)
+		return nil
+	}
+	return obj
+}
+
+// attrObject is object plus the check of attrId
+func (zm *ZMachine) attrObject(objectId uint16, attrId uint16) *ZObject {
+	obj := zm.object(objectId)
+	if obj != nil && attrId >= obj.AttributesCount() {
+		zm.violatef(InvalidAttribute, "invalid attribute %d", attrId)
+		return nil
+	}
+	return obj
+}
+
+// propertyObject is object plus the check of propertyId,
+// 0 is accepted if allowZero is set
+func (zm *ZMachine) propertyObject(objectId uint16, propertyId uint16, allowZero bool) *ZObject {
+	obj := zm.object(objectId)
+	if obj == nil {
+		return nil
+	}
+	if (propertyId == 0 && !allowZero) || propertyId > uint16(obj.layout.maxProperty) {
+		zm.violatef(InvalidProperty, "invalid property %d", propertyId)
+		return nil
+	}
+	return obj
+}
+
+// GetVarAt reads variable varnum, reading the stack pops it
 func (zm *ZMachine) GetVarAt(varnum byte) uint16 {
 	if varnum == 0 {
 		// top of stack
-		return zm.stack.Top().locals[len(zm.stack.Top().locals)-1]
-	} else if varnum < 0x10 {
-		// local variable
-		return zm.stack.Top().locals[varnum-1]
-	} else {
-		// global variable
-		globalAddr := uint32(zm.header.globalsPos) + uint32(varnum-0x10)*2
-		return zm.seq.mem.WordAt(globalAddr)
+		val, err := zm.stack.Top().pop()
+		if err != nil {
+			zm.violate(StackUnderflow, err)
+		}
+		return val
 	}
+	return zm.GetIndirectVarAt(varnum)
 }
 
+// StoreVarAt writes variable varnum, writing the stack pushes to it
 func (zm *ZMachine) StoreVarAt(varnum byte, val uint16) {
 	if varnum == 0 {
 		// push to top of the stack
-		topRoutinelocals := &zm.stack.Top().locals
-		*topRoutinelocals = append(*topRoutinelocals, val)
-	} else if varnum < 0x10 {
-		// local variable
-		// starting from 0
-		zm.stack.Top().locals[varnum-1] = val
-	} else {
-		// global variable
-		// globals table is a table of 240 words
-		globalAddr := uint32(zm.header.globalsPos) + uint32(varnum-0x10)*2
-		zm.seq.mem.WriteWordAt(globalAddr, val)
+		zm.stack.Top().push(val)
+		return
 	}
+	zm.StoreIndirectVarAt(varnum, val)
 }
 
-func (zm *ZMachine) UpdateVarAt(varnum byte, val int16) uint16 {
-	newValue := uint16(0)
+// varAddr returns where the local variable or the top of the stack
+// lives, it doesn't handle globals
+func (zm *ZMachine) varAddr(varnum byte) *uint16 {
+	var ptr *uint16
+	var err error
+
 	if varnum == 0 {
-		// top of the stack
-		newValue = uint16(int16(zm.stack.Top().locals[len(zm.stack.Top().locals)-1]) + val)
-		zm.stack.Top().locals[len(zm.stack.Top().locals)-1] = newValue
-	} else if varnum < 0x10 {
-		// local variable
-		// starting from 0
-		zm.stack.Top().locals[varnum-1] += uint16(val)
-		newValue = zm.stack.Top().locals[varnum-1]
+		if ptr, err = zm.stack.Top().top(); err != nil {
+			zm.violate(StackUnderflow, err)
+			return nil
+		}
 	} else {
-		// global variable
-		// globals table is a table of 240 words
-		globalAddr := uint32(zm.header.globalsPos) + uint32(varnum-0x10)*2
-		newValue = zm.seq.mem.WordAt(globalAddr) + uint16(val)
-		zm.seq.mem.WriteWordAt(globalAddr, newValue)
+		ptr, err = zm.stack.Top().local(varnum)
+	}
+
+	if err != nil {
+		zm.fault(err)
+		return nil
+	}
+	return ptr
+}
+
+func (zm *ZMachine) globalAddr(varnum byte) uint32 {
+	// globals table is a table of 240 words
+	return uint32(zm.header.globalsPos) + uint32(varnum-0x10)*2
+}
+
+// GetIndirectVarAt is GetVarAt for the opcodes taking a variable
+// reference as operand, the top of the stack is read in place
+func (zm *ZMachine) GetIndirectVarAt(varnum byte) uint16 {
+	if varnum >= 0x10 {
+		return zm.seq.mem.WordAt(zm.globalAddr(varnum))
+	}
+
+	ptr := zm.varAddr(varnum)
+	if ptr == nil {
+		return 0
+	}
+	return *ptr
+}
+
+// StoreIndirectVarAt is StoreVarAt for the opcodes taking a variable
+// reference as operand, the top of the stack is written in place
+func (zm *ZMachine) StoreIndirectVarAt(varnum byte, val uint16) {
+	if varnum >= 0x10 {
+		zm.seq.mem.WriteWordAt(zm.globalAddr(varnum), val)
+		return
+	}
+
+	if ptr := zm.varAddr(varnum); ptr != nil {
+		*ptr = val
 	}
+}
+
+func (zm *ZMachine) UpdateVarAt(varnum byte, val int16) uint16 {
+	newValue := zm.GetIndirectVarAt(varnum) + uint16(val)
+	zm.StoreIndirectVarAt(varnum, newValue)
 	return newValue
 }
 
+// StoreReturn stores val to the store variable of the instruction
 func (zm *ZMachine) StoreReturn(val uint16) {
-	varnum := zm.seq.ReadByte()
-	zm.StoreVarAt(varnum, val)
+	zm.StoreVarAt(zm.op.store, val)
 }
 
+// Branch follows the branch data of the instruction if conditionOk
+// is what it branches on
 func (zm *ZMachine) Branch(conditionOk bool) {
-	info := zm.seq.ReadByte()
+	if conditionOk != zm.op.branchOnTrue {
+		return
+	}
+
+	switch offset := zm.op.branchOffset; offset {
+	case 0:
+		// offset of 0 means return false from current routine
+		ZReturnFalse(zm)
+	case 1:
+		// offset of 1 means return true from current routine
+		ZReturnTrue(zm)
+	default:
+		// otherwise we move to instruction to the given offset
+		zm.seq.pos = zm.CalcJumpAddress(offset)
+		zm.logger.Printf("Jumping to address: %X offset: %X\n", zm.seq.pos, offset)
+	}
+}
 
-	// if bit #7 is set than branch on true
-	branchOnTrue := (info >> 7) != 0x00
+// call calls the routine at packed address paddr, the value it returns
+// is stored to storeVar unless discard is true
+func (zm *ZMachine) call(paddr uint16, args []uint16, storeVar byte, discard bool) {
+	if paddr == 0 {
+		// calling address 0 does nothing and returns false
+		if !discard {
+			zm.StoreVarAt(storeVar, 0)
+		}
+		return
+	}
 
-	// offset is relative to current PC and it can be negative
-	var offset int32
+	retAddr := zm.seq.pos
+	zm.seq.pos = zm.header.PackedAddress(uint32(paddr))
 
-	// if bit #6 is set than the offset is stored in the bottom
-	// 6 bits
-	if info&0x40 != 0x00 {
-		offset = int32(info & 0x3F)
-	} else {
-		// if bit #6 is clear than the offset is store in a 14 bit signed
-		// integer composed by the bottom 5 bits of info and 8 bits of an
-		// additional byte
-		firstPart := uint16(info & 0x3F)
-
-		// if sign bit(#6) is set then it's a negative number
-		// in two complement form, so set the bits #6 and #7 too
-		if firstPart&0x20 != 0x00 {
-			firstPart |= 0x3 << 6
+	routine, err := NewZRoutine(zm.seq, retAddr, zm.header)
+	if err != nil {
+		zm.fault(err)
+		return
+	}
+
+	routine.storeVar = storeVar
+	routine.discard = discard
+	routine.argCount = byte(len(args))
+
+	// copy arguments to locals, the exceeding ones are lost
+	for i := 0; i < len(args) && i < len(routine.locals); i++ {
+		routine.locals[i] = args[i]
+	}
+
+	zm.stack.Push(routine)
+	zm.logger.Print("Call ", routine)
+}
+
+// callInterrupt runs the routine at packed address paddr to the end,
+// before going on with the current instruction, and returns its value
+func (zm *ZMachine) callInterrupt(paddr uint16) uint16 {
+	depth := len(zm.stack)
+
+	// Interpret replaces the instruction being executed
+	op, opPos := zm.op, zm.opPos
+	defer func() {
+		zm.op, zm.opPos = op, opPos
+	}()
+
+	// the return value is pushed to the current frame,
+	// where it is picked up once the routine is done
+	zm.call(paddr, nil, 0, false)
+
+	for zm.err == nil && !zm.quitted && len(zm.stack) > depth {
+		// Interpret resets the error of the current instruction
+		err := zm.err
+		if ierr := zm.Interpret(); ierr != nil {
+			err = ierr
 		}
+		zm.err = err
+	}
 
-		offset = int32(int16(firstPart<<8) | int16(zm.seq.ReadByte()))
+	if zm.err != nil || zm.quitted {
+		return 0
 	}
 
-	// jump if conditionOk and branchOnTrue are both true or false
-	if conditionOk == branchOnTrue {
-		if offset == 0 {
-			// offset of 0 means return false from current routine
-			ZReturnFalse(zm)
-		} else if offset == 1 {
-			// offset of 1 means return false from current routine
-			ZReturnTrue(zm)
-		} else {
-			// otherwise we move to instruction to the given offset
-			zm.seq.pos = zm.CalcJumpAddress(offset)
-			zm.logger.Printf("Jumping to address: %X offset: %X\n", zm.seq.pos, offset)
+	return zm.GetVarAt(0)
+}
+
+// readLine reads a line of input, calling the routine at packed
+// address routine every tenths/10 seconds while waiting, if the
+// routine returns true the read is aborted
+func (zm *ZMachine) readLine(tenths uint16, routine uint16) (line string, aborted bool) {
+	timeout := time.Duration(0)
+	if tenths != 0 && routine != 0 {
+		timeout = time.Duration(tenths) * 100 * time.Millisecond
+	}
+
+	// the keyboard is not used while replaying a commands file,
+	// the lines are shown as if they had been typed
+	if line, ok := zm.input.readCommand(); ok {
+		if zm.streams.screen {
+			zm.screen.Print(line + "\n")
+		}
+		zm.logger.Printf("Replay %s", line)
+		return line, false
+	}
+
+	for {
+		zm.render()
+
+		line, timedOut, err := zm.input.readLine(timeout)
+		if err != nil {
+			zm.fault(err)
+			return "", false
+		}
+
+		if !timedOut {
+			zm.logger.Printf("Read %s", line)
+			return line, false
+		}
+
+		if zm.callInterrupt(routine) != 0 || zm.err != nil || zm.quitted {
+			return "", true
+		}
+	}
+}
+
+// tokenise splits the ZSCII text into words and fills the parse table
+// at parseTblPos, textStart is the offset of text in the text buffer,
+// if skipUnknown is set the entries of unknown words are left untouched
+func (zm *ZMachine) tokenise(text string, textStart int, parseTblPos uint32, dict *ZDictionary, skipUnknown bool) {
+	mem := zm.seq.mem
+
+	words := SplitSentenceWords(text, string(dict.wordSeparators))
+
+	maxWords := int(mem.ByteAt(parseTblPos))
+	if maxWords < len(words) {
+		words = words[:maxWords]
+	}
+
+	seq := mem.GetSequential(parseTblPos + 1)
+	seq.WriteByte(byte(len(words)))
+
+	for _, w := range words {
+		// 4 byte block
+		// word: address of word searched in the dict
+		// byte: #chars of the word
+		// byte: position of the first letter of the word in text-buffer
+		addr := dict.Search(zm.zsciiToString([]byte(w.Text)))
+		if addr == 0 && skipUnknown {
+			seq.pos += 4
+			continue
 		}
+
+		seq.WriteWord(addr)
+		seq.WriteByte(byte(len(w.Text)))
+		seq.WriteByte(byte(textStart + w.Pos))
 	}
 }
 
@@ -183,43 +568,82 @@ func (zm *ZMachine) InterpretAll() error {
 	for err == nil && !zm.quitted {
 		err = zm.Interpret()
 	}
+
+	// show the last words of the story
+	zm.render()
+	zm.closeStreams()
+	zm.input.setCommands(nil)
+	zm.reportViolations()
+
 	return err
 }
 
-func (zm *ZMachine) Interpret() error {
+func (zm *ZMachine) Interpret() (err error) {
 	tmpPc := zm.seq.pos
-	op, err := NewZOp(zm)
+	zm.opPos = tmpPc
+
+	var op *ZOp
+
+	// story files are not trusted, so anything going wrong
+	// while executing them is reported as a runtime error
+	defer func() {
+		if r := recover(); r != nil {
+			// memory violations are errors, keep them as they are
+			rerr, ok := r.(error)
+			if !ok {
+				rerr = fmt.Errorf("%v", r)
+			}
+			err = zm.newRuntimeError(tmpPc, op, rerr)
+		}
+	}()
+
+	// decoding reads the variable operands, it can fault too
+	zm.err = nil
+	op, err = NewZOp(zm)
 	if err != nil {
-		return err
+		return zm.newRuntimeError(tmpPc, nil, err)
 	}
 	zm.logger.Printf("Interpreting instruction at PC %X\n%s", tmpPc, op)
 
-	switch op.class {
-	case ZEROOP:
-		zeroOpFuncs[op.opcode](zm)
-	case ONEOP:
-		oneOpFuncs[op.opcode](zm, op.operands[0])
-	case TWOOP:
-		if op.opcode == 1 {
-			// ZJe is a two op func but it accepts VAR count of args,
-			// so we must handle separetly
-			ZJe(zm, op.operands)
-		} else {
-			twoOpFuncs[op.opcode](zm, op.operands[0], op.operands[1])
-		}
-	case VAROP:
-		varOpFuncs[op.opcode](zm, op.operands)
+	zm.op = op
+	if zm.err == nil {
+		zm.execute(op)
+	}
+
+	if zm.err != nil {
+		return zm.newRuntimeError(tmpPc, op, zm.err)
 	}
+
 	return nil
 }
 
+func (zm *ZMachine) execute(op *ZOp) {
+	if op.info != nil && len(op.operands) < op.info.Operands {
+		zm.faultf("%s with %d operands", op.name, len(op.operands))
+		return
+	}
+
+	switch fn := op.handler().(type) {
+	case ZeroOpFunc:
+		fn(zm)
+	case OneOpFunc:
+		fn(zm, op.operands[0])
+	case TwoOpFunc:
+		fn(zm, op.operands[0], op.operands[1])
+	case VarOpFunc:
+		fn(zm, op.operands)
+	default:
+		zm.fault(errUnimplementedOpcode)
+	}
+}
+
 func (zm *ZMachine) String() string {
 	// not properly formatted
 	ret := ""
 
 	ret += fmt.Sprintf("PC: %X\n", zm.seq.pos)
 	ret += fmt.Sprintf("Stack: %s\n", zm.stack)
-	ret += fmt.Sprintf("Quitted: %b\n", zm.quitted)
+	ret += fmt.Sprintf("Quitted: %t\n", zm.quitted)
 
 	return ret
 }
@@ -233,3 +657,14 @@ func (zstack *ZStack) String() string {
 
 	return ret
 }
+
+// printZSCII prints the ZSCII character c, the ones which
+// are not defined for output are ignored
+func (zm *ZMachine) printZSCII(c uint16) {
+	switch {
+	case c == 13:
+		zm.print("\n")
+	case c >= 32 && c <= 126, c >= firstExtraZSCII && c <= 251:
+		zm.print(string(zm.header.ZSCIIToRune(c)))
+	}
+}
@@ -1,6 +1,12 @@
 package gork
 
-import "testing"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
 
 var someRoutines []*ZRoutine = []*ZRoutine{
 	&ZRoutine{
@@ -44,3 +50,425 @@ func TestZStackPop(t *testing.T) {
 	}
 
 }
+
+func TestZMachineVerify(t *testing.T) {
+	buf := make([]byte, 0x48)
+	for i := 0x40; i < len(buf); i++ {
+		buf[i] = byte(i)
+	}
+	mem := *NewZMemory(buf)
+
+	zm := &ZMachine{
+		header: &ZHeader{
+			fileLength:   uint64(len(buf)),
+			fileChecksum: 0x40 + 0x41 + 0x42 + 0x43 + 0x44 + 0x45 + 0x46 + 0x47,
+			dynMemSize:   0x42,
+		},
+		seq:      mem.GetSequential(0),
+		original: append([]byte{}, buf[:0x42]...),
+	}
+
+	if !zm.Verify() {
+		t.Fail()
+	}
+
+	// changes to dynamic memory must not affect the checksum
+	buf[0x41] = 0
+	if !zm.Verify() {
+		t.Fail()
+	}
+
+	buf[0x42] = 0
+	if zm.Verify() {
+		t.Fail()
+	}
+}
+
+type nullLogger struct{}
+
+func (_ nullLogger) Print(...interface{})          {}
+func (_ nullLogger) Printf(string, ...interface{}) {}
+
+var faultyInstructions [][]byte = [][]byte{
+	// div 5 0 -> sp
+	[]byte{0x17, 0x05, 0x00, 0x00},
+	// v3 extended opcode
+	[]byte{0xBE, 0x00, 0xFF},
+	// get_parent of object 0
+	[]byte{0x93, 0x00, 0x00},
+}
+
+func TestZRuntimeError(t *testing.T) {
+	for _, buf := range faultyInstructions {
+		mem := *NewZMemory(append(make([]byte, 4), buf...))
+
+		zm := &ZMachine{
+			header: &ZHeader{version: 3},
+			seq:    mem.GetSequential(4),
+			stack:  ZStack{&ZRoutine{addr: 4, locals: []uint16{}}},
+			logger: nullLogger{},
+		}
+
+		err := zm.Interpret()
+
+		rerr, ok := err.(*ZRuntimeError)
+		if !ok || rerr.PC != 4 || rerr.RoutineAddr != 4 || rerr.StackDepth != 1 {
+			t.Fail()
+		}
+	}
+}
+
+func TestZMachineMemoryViolation(t *testing.T) {
+	buf := make([]byte, 0x80)
+	// dynamic memory ends at 0x60
+	buf[0x0F] = 0x60
+	// storeb 0x70 0 42
+	copy(buf[0x70:], []byte{0xE2, 0x57, 0x70, 0x00, 0x2A})
+
+	mem := NewZMemory(buf)
+	zm := &ZMachine{
+		header: &ZHeader{version: 3},
+		seq:    mem.GetSequential(0x70),
+		stack:  ZStack{&ZRoutine{addr: 0x70, locals: []uint16{}}},
+		logger: nullLogger{},
+	}
+
+	err := zm.Interpret()
+
+	var rerr *ZRuntimeError
+	var merr *ZMemoryError
+	if !errors.As(err, &rerr) || rerr.PC != 0x70 || !errors.As(err, &merr) {
+		t.FailNow()
+	}
+	if merr.Addr != 0x70 || !merr.Write || buf[0x70] != 0xE2 {
+		t.Fail()
+	}
+}
+
+func TestZMachineVariables(t *testing.T) {
+	mem := *NewZMemory(make([]byte, 0x20))
+	zm := &ZMachine{
+		header: &ZHeader{globalsPos: 0},
+		seq:    mem.GetSequential(0),
+		stack:  ZStack{&ZRoutine{locals: []uint16{10, 20}}},
+	}
+
+	zm.StoreVarAt(0, 42)
+	zm.StoreVarAt(0, 73)
+
+	// stack values must not overlap locals
+	if zm.GetVarAt(1) != 10 || zm.GetVarAt(2) != 20 {
+		t.Fail()
+	}
+
+	// indirect references work in place
+	zm.StoreIndirectVarAt(0, 96)
+	if zm.GetIndirectVarAt(0) != 96 || len(zm.stack.Top().stack) != 2 {
+		t.Fail()
+	}
+
+	if zm.GetVarAt(0) != 96 || zm.GetVarAt(0) != 42 || zm.err != nil {
+		t.Fail()
+	}
+
+	zm.GetVarAt(0)
+	if zm.err != errStackUnderflow {
+		t.Fail()
+	}
+
+	zm.err = nil
+	zm.StoreVarAt(3, 1)
+	if zm.err == nil {
+		t.Fail()
+	}
+
+	zm.err = nil
+	zm.StoreVarAt(0x11, 0x1234)
+	if mem.WordAt(2) != 0x1234 || zm.UpdateVarAt(0x11, -1) != 0x1233 {
+		t.Fail()
+	}
+}
+
+// scriptedIODev returns its lines one by one and keeps the text
+// printed and rendered
+type scriptedIODev struct {
+	lines  []string
+	output string
+}
+
+func (dev *scriptedIODev) Print(a ...interface{}) {
+	dev.output += fmt.Sprint(a...)
+}
+
+func (dev *scriptedIODev) Render(screen *ZScreen) {
+	spans, _ := screen.TakeLower()
+	for _, span := range spans {
+		dev.output += span.Text
+	}
+}
+
+func (dev *scriptedIODev) ReadLine() (string, error) {
+	if len(dev.lines) == 0 {
+		return "", io.EOF
+	}
+	line := dev.lines[0]
+	dev.lines = dev.lines[1:]
+	return line, nil
+}
+
+func TestZMachineRead(t *testing.T) {
+	// past the header, stories can't write to it
+	const textPos, parseTblPos = 0x40, 0x60
+
+	buf := append(dictBuf, make([]byte, 0x80-len(dictBuf))...)
+	mem := *NewZMemory(buf)
+	buf[textPos] = 14
+	buf[parseTblPos] = 3
+
+	header := &ZHeader{dictPos: 0}
+	dev := &scriptedIODev{lines: []string{"  Zork,  cyclop go\n"}}
+
+	zm := &ZMachine{
+		header:     header,
+		seq:        mem.GetSequential(0),
+		dictionary: NewZDictionary(&mem, header),
+		iodev:      dev,
+		input:      newZInput(dev),
+		screen:     NewZScreen(80, 25),
+		logger:     nullLogger{},
+	}
+
+	ZRead(zm, []uint16{textPos, parseTblPos})
+	if zm.err != nil {
+		t.FailNow()
+	}
+
+	// 13 letters fit in the buffer
+	text := buf[textPos+1 : textPos+15]
+	if string(text) != "zork,  cyclop\x00" {
+		t.Fail()
+	}
+
+	expected := []byte{
+		3, 3,
+		0x00, 0x0B, 4, 1,
+		0x00, 0x00, 1, 5,
+		0x00, 0x07, 6, 8,
+	}
+	for i, b := range expected {
+		if buf[parseTblPos+i] != b {
+			t.Fail()
+		}
+	}
+}
+
+func TestZMachineCatchThrow(t *testing.T) {
+	buf := make([]byte, 0x100)
+	mem := *NewZMemory(buf)
+	buf[0x90] = 0x00
+
+	zm := &ZMachine{
+		header: &ZHeader{version: 5, globalsPos: 0x40},
+		seq:    mem.GetSequential(0x90),
+		op:     &ZOp{},
+		stack: ZStack{
+			&ZRoutine{locals: []uint16{}},
+			&ZRoutine{retAddr: 0x80, storeVar: 0x10, locals: []uint16{}},
+			&ZRoutine{locals: []uint16{}},
+		},
+		logger: nullLogger{},
+	}
+
+	ZCatch(zm)
+	if frame, _ := zm.stack.Top().pop(); frame != 3 {
+		t.Fail()
+	}
+
+	ZThrow(zm, 42, 2)
+	if zm.err != nil || len(zm.stack) != 1 || zm.seq.pos != 0x80 || mem.WordAt(0x40) != 42 {
+		t.Fail()
+	}
+
+	ZThrow(zm, 42, 2)
+	if zm.err == nil {
+		t.Fail()
+	}
+}
+
+func TestZMachineTableOpcodes(t *testing.T) {
+	buf := make([]byte, 0x80)
+	mem := *NewZMemory(buf)
+	copy(buf[0x50:], []byte{1, 2, 3, 4, 5})
+
+	zm := &ZMachine{
+		header: &ZHeader{version: 5},
+		seq:    mem.GetSequential(0x70),
+		// results are pushed
+		op:    &ZOp{},
+		stack: ZStack{&ZRoutine{locals: []uint16{}}},
+	}
+
+	// negative size copies forwards, spreading the first byte
+	ZCopyTable(zm, []uint16{0x50, 0x51, uint16(0xFFFC)})
+	if string(buf[0x50:0x55]) != "\x01\x01\x01\x01\x01" {
+		t.Fail()
+	}
+
+	copy(buf[0x50:], []byte{1, 2, 3, 4, 5})
+	ZCopyTable(zm, []uint16{0x50, 0x51, 4})
+	if string(buf[0x50:0x55]) != "\x01\x01\x02\x03\x04" {
+		t.Fail()
+	}
+
+	ZCopyTable(zm, []uint16{0x50, 0, 5})
+	if string(buf[0x50:0x55]) != "\x00\x00\x00\x00\x00" {
+		t.Fail()
+	}
+
+	ZArtShift(zm, []uint16{uint16(0xFFF0), uint16(0xFFFE)})
+	ZLogShift(zm, []uint16{uint16(0xFFF0), uint16(0xFFFE)})
+	ZLogShift(zm, []uint16{1, 3})
+
+	if v, _ := zm.stack.Top().pop(); v != 8 {
+		t.Fail()
+	}
+	if v, _ := zm.stack.Top().pop(); v != 0x3FFC {
+		t.Fail()
+	}
+	if v, _ := zm.stack.Top().pop(); v != 0xFFFC {
+		t.Fail()
+	}
+}
+
+func TestZMachineAread(t *testing.T) {
+	const textPos = 0x50
+
+	buf := make([]byte, 0x80)
+	mem := *NewZMemory(buf)
+	// terminating characters: cursor up
+	buf[0x10] = 129
+
+	buf[textPos] = 10
+	// chars already in the buffer
+	buf[textPos+1] = 2
+	copy(buf[textPos+2:], "go")
+
+	dev := &scriptedIODev{lines: []string{" North\x1b[A\n"}}
+
+	zm := &ZMachine{
+		header: &ZHeader{version: 5, termCharsPos: 0x10},
+		seq:    mem.GetSequential(0x7F),
+		op:     &ZOp{},
+		stack:  ZStack{&ZRoutine{locals: []uint16{}}},
+		iodev:  dev,
+		input:  newZInput(dev),
+		screen: NewZScreen(80, 25),
+		logger: nullLogger{},
+	}
+
+	ZRead(zm, []uint16{textPos, 0})
+
+	if zm.err != nil || buf[textPos+1] != 7 || string(buf[textPos+2:textPos+9]) != "gonorth" {
+		t.Fail()
+	}
+
+	if v, _ := zm.stack.Top().pop(); v != 129 {
+		t.Fail()
+	}
+}
+
+func TestZMachineStatusLine(t *testing.T) {
+	mem, header, count := prelude()
+	header.version = 3
+
+	// globals follow the objects
+	header.globalsPos = uint16(mem.Len())
+	mem = NewZMemory(append(mem.Slice(0, mem.Len()), 0x00, 0x02, 0xFF, 0xFE, 0x00, 0x2A))
+
+	dev := &scriptedIODev{}
+	zm := &ZMachine{
+		header:       header,
+		seq:          mem.GetSequential(0),
+		objectsCount: count,
+		iodev:        dev,
+		screen:       NewZScreen(80, 25),
+	}
+
+	status := zm.statusLine()
+	if status == nil || status.Location != "zork" || status.Score != -2 || status.Moves != 42 {
+		t.FailNow()
+	}
+
+	line := status.Format(40)
+	if len(line) != 40 || line != " zork"+strings.Repeat(" ", 14)+"Score: -2  Moves: 42 " {
+		t.Fail()
+	}
+
+	// show_status draws it above the upper window
+	ZShowStatus(zm)
+	if upper := zm.screen.UpperText(); len(upper) != 1 || upper[0] != status.Format(80)[:79] {
+		t.Fail()
+	}
+
+	// the location is cut between runes
+	status.Location = "éééé"
+	if line := status.Format(25); line != " éé Score: -2  Moves: 42 " {
+		t.Fail()
+	}
+
+	// narrow screens cut the score too
+	if status.Format(10) != "Score: -2 " || status.Format(0) != "" {
+		t.Fail()
+	}
+
+	// time games
+	header.config = 0x02
+	mem.WriteWordAt(uint32(header.globalsPos)+2, 13)
+	mem.WriteWordAt(uint32(header.globalsPos)+4, 5)
+
+	if status := zm.statusLine(); status == nil || status.Right() != "Time: 1:05 PM" {
+		t.Fail()
+	}
+
+	// v4 has no status line
+	header.version = 4
+	zm.screen = NewZScreen(80, 25)
+	ZShowStatus(zm)
+	if zm.statusLine() != nil || len(zm.screen.UpperText()) != 0 {
+		t.Fail()
+	}
+}
+
+func TestZMachineDecodedResults(t *testing.T) {
+	buf := make([]byte, 0x100)
+	copy(buf[0x40:], []byte{
+		// call 0x60 -> g00
+		0xE0, 0x3F, 0x00, 0x30, 0x10,
+		// je g00 42 ?(+3)
+		0x41, 0x10, 0x2A, 0xC3,
+		0xBA,
+		// print "hello"
+		0xB2, 0x35, 0x51, 0xC6, 0x85,
+		0xBA,
+	})
+	// ret 42
+	copy(buf[0x60:], []byte{0x00, 0x9B, 0x2A})
+
+	mem := NewZMemory(buf)
+	zm := &ZMachine{
+		header: &ZHeader{version: 3, globalsPos: 0xC0},
+		seq:    mem.GetSequential(0x40),
+		stack:  ZStack{&ZRoutine{addr: 0x40, locals: []uint16{}}},
+		logger: nullLogger{},
+	}
+
+	for _, pos := range []uint32{0x61, 0x45, 0x4A, 0x4F} {
+		if err := zm.Interpret(); err != nil || zm.seq.pos != pos {
+			t.FailNow()
+		}
+	}
+
+	if mem.WordAt(0xC0) != 42 || zm.op.name != "print" || zm.op.text != "hello" {
+		t.Fail()
+	}
+}
@@ -0,0 +1,125 @@
+package gork
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ZExitKind tells how an exit of an Infocom room works, ZIL tells
+// them apart by the length of the direction property
+type ZExitKind int
+
+const (
+	// UEXIT, the room
+	ExitUnconditional ZExitKind = iota + 1
+	// NEXIT, the message printed when going that way
+	ExitBlocked
+	// FEXIT, the routine returning the room
+	ExitRoutine
+	// CEXIT, the room, the global variable that opens the exit
+	// and the message printed when it's closed
+	ExitConditional
+	// DEXIT, the room, the door and the message printed when it's closed
+	ExitDoor
+)
+
+var exitKindNames = [...]string{"", "unconditional", "blocked", "routine", "conditional", "door"}
+
+func (kind ZExitKind) String() string {
+	if kind < ExitUnconditional || kind > ExitDoor {
+		return fmt.Sprintf("ZExitKind(%d)", int(kind))
+	}
+	return exitKindNames[kind]
+}
+
+// ZExit is a direction property of a room
+type ZExit struct {
+	// the longest dictionary word of the direction
+	Direction string
+	Property  byte
+	Kind      ZExitKind
+	// the room the exit leads to, 0 if only a routine knows it
+	Room uint16
+	// the variable of ExitConditional exits, the door of ExitDoor ones
+	Condition uint16
+	// the routine of ExitRoutine exits
+	Routine uint32
+}
+
+// ZRoom is an object with exits
+type ZRoom struct {
+	Id    uint16
+	Name  string
+	Exits []ZExit
+}
+
+// ZRooms returns the objects of an Infocom v1-3 story having direction
+// properties, the properties of the words the dictionary marks as
+// directions, with their exits
+func ZRooms(mem *ZMemory, header *ZHeader) ([]ZRoom, error) {
+	if header.version > 3 {
+		return nil, fmt.Errorf("exits of version %d stories are not supported", header.version)
+	}
+
+	directions := map[byte]string{}
+	for _, word := range NewZDictionary(mem, header).Words() {
+		prop, ok := word.Value(SpeechDirection)
+		if ok && len(word.Text) > len(directions[prop]) {
+			directions[prop] = word.Text
+		}
+	}
+	if len(directions) == 0 {
+		return nil, errors.New("the dictionary has no direction")
+	}
+
+	total, err := ZObjectsCount(mem, header)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []ZRoom{}
+	for i := uint16(1); i <= total; i++ {
+		obj, err := NewZObject(mem, i, header)
+		if err != nil {
+			return nil, err
+		}
+
+		room := ZRoom{Id: i, Name: obj.Name(), Exits: []ZExit{}}
+		for _, prop := range obj.PropertiesIds() {
+			direction, ok := directions[prop]
+			if !ok {
+				continue
+			}
+
+			exit, ok := readZExit(obj.PropertyData(prop), header)
+			if ok {
+				exit.Direction, exit.Property = direction, prop
+				room.Exits = append(room.Exits, exit)
+			}
+		}
+
+		if len(room.Exits) > 0 {
+			ret = append(ret, room)
+		}
+	}
+
+	return ret, nil
+}
+
+func readZExit(data []byte, header *ZHeader) (ZExit, bool) {
+	exit := ZExit{Kind: ZExitKind(len(data))}
+
+	switch exit.Kind {
+	case ExitUnconditional:
+		exit.Room = uint16(data[0])
+	case ExitBlocked:
+	case ExitRoutine:
+		exit.Routine = header.PackedAddress(uint32(data[0])<<8 | uint32(data[1]))
+	case ExitConditional, ExitDoor:
+		exit.Room, exit.Condition = uint16(data[0]), uint16(data[1])
+	default:
+		return exit, false
+	}
+
+	return exit, true
+}
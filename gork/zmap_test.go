@@ -0,0 +1,56 @@
+package gork
+
+import "testing"
+
+func TestZRooms(t *testing.T) {
+	header := &ZHeader{version: 3, objTblPos: 0, dictPos: 100}
+
+	// the property tables of the objects are at 80 and 95
+	buf := make([]byte, 62+2*9)
+	buf[62+8], buf[71+8] = 80, 95
+	buf = append(buf,
+		// hall: north to cellar, south by routine, east if G05, property 28
+		0x00, 0x1F, 0x02, 0x5E, 0x00, 0x90, 0x00, 0x7D, 0x02, 0x15, 0x00, 0x00, 0x1C, 0x05, 0x00,
+		// cellar: south to hall
+		0x00, 0x1E, 0x01, 0x00,
+		0x00,
+		// dictionary
+		0x00, 7, 0x00, 4,
+	)
+
+	for _, word := range []struct {
+		text string
+		prop byte
+	}{
+		{"east", 29}, {"n", 31}, {"north", 31}, {"south", 30},
+	} {
+		for _, w := range ZStringEncode(word.text, header) {
+			buf = append(buf, byte(w>>8), byte(w))
+		}
+		buf = append(buf, byte(SpeechDirection)|0x03, word.prop, 0)
+	}
+
+	rooms, err := ZRooms(NewZMemory(buf), header)
+	if err != nil || len(rooms) != 2 {
+		t.FailNow()
+	}
+
+	hall, cellar := rooms[0], rooms[1]
+	if hall.Id != 1 || len(hall.Exits) != 3 || cellar.Id != 2 || len(cellar.Exits) != 1 {
+		t.FailNow()
+	}
+
+	for i, expected := range []ZExit{
+		{Direction: "north", Property: 31, Kind: ExitUnconditional, Room: 2},
+		{Direction: "south", Property: 30, Kind: ExitRoutine, Routine: 0x120},
+		{Direction: "east", Property: 29, Kind: ExitConditional, Room: 2, Condition: 0x15},
+	} {
+		if hall.Exits[i] != expected {
+			t.Fail()
+		}
+	}
+
+	if cellar.Exits[0] != (ZExit{Direction: "south", Property: 30, Kind: ExitUnconditional, Room: 1}) {
+		t.Fail()
+	}
+}
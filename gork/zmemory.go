@@ -5,10 +5,6 @@ import (
 	"strings"
 )
 
-// v3
-// number of words, not characters!
-const encodedZstringLen int = 2
-
 // v3
 var Alphabets = [3]string{
 	"abcdefghijklmnopqrstuvwxyz",
@@ -16,43 +12,154 @@ var Alphabets = [3]string{
 	" \n0123456789.,!?_#'\"/\\-:()",
 }
 
-type ZMemory []byte
+// ZMemory is the memory of a story, dynamic memory is private to each
+// clone while static and high memory are shared by all of them since
+// they can't be written
+type ZMemory struct {
+	// the story as loaded, only the part after dynamic is read
+	story []byte
+	// the first bytes of story, up to the end of dynamic memory
+	dynamic []byte
+}
+
 type ZMemorySequential struct {
 	mem *ZMemory
 	pos uint32
 }
 
-func NewZMemory(mem []byte) *ZMemory {
-	zmem := new(ZMemory)
-	*zmem = mem
-	return zmem
+// NewZMemory wraps the story buf, the size of dynamic memory is read
+// from its header, buffers too short to have one are all dynamic
+func NewZMemory(buf []byte) *ZMemory {
+	dynSize := len(buf)
+	if len(buf) >= 0x40 {
+		size := int(buf[0x0E])<<8 | int(buf[0x0F])
+		if size > 0 && size <= len(buf) {
+			dynSize = size
+		}
+	}
+
+	return &ZMemory{story: buf, dynamic: buf[:dynSize]}
+}
+
+// Clone returns a copy of the memory sharing static and high memory,
+// so it costs only the size of dynamic memory
+func (zmem *ZMemory) Clone() *ZMemory {
+	dynamic := make([]byte, len(zmem.dynamic))
+	copy(dynamic, zmem.dynamic)
+
+	return &ZMemory{story: zmem.story, dynamic: dynamic}
+}
+
+// Len returns the size of the memory
+func (zmem *ZMemory) Len() uint32 {
+	return uint32(len(zmem.story))
+}
+
+// DynamicLen returns the size of dynamic memory
+func (zmem *ZMemory) DynamicLen() uint32 {
+	return uint32(len(zmem.dynamic))
+}
+
+// Slice returns the bytes from start to end, it can be written
+// only when it's in dynamic memory, otherwise it's a copy or
+// it's shared with the clones
+func (zmem *ZMemory) Slice(start uint32, end uint32) []byte {
+	dynLen := zmem.DynamicLen()
+
+	switch {
+	case start > end || end > zmem.Len():
+		zmem.violation(end-1, false, "beyond the end of the story")
+	case end <= dynLen:
+		return zmem.dynamic[start:end]
+	case start >= dynLen:
+		return zmem.story[start:end]
+	}
+
+	ret := make([]byte, 0, end-start)
+	ret = append(ret, zmem.dynamic[start:]...)
+	return append(ret, zmem.story[dynLen:end]...)
+}
+
+// ZMemoryError is an access to memory the story is not allowed to make,
+// Interpret reports it wrapped in a ZRuntimeError
+type ZMemoryError struct {
+	Addr   uint32
+	Write  bool
+	Reason string
+}
+
+func (e *ZMemoryError) Error() string {
+	access := "read from"
+	if e.Write {
+		access = "write to"
+	}
+	return fmt.Sprintf("illegal %s %X: %s", access, e.Addr, e.Reason)
+}
+
+const headerSize = 0x40
+
+// Flags 2 bits the story can change: transcript, fixed pitch font
+// and (v6) redraw
+const flags2Writable = 0x07
+
+// memory accessors have no error to return, so violations panic
+// and Interpret turns them into runtime errors
+func (zmem *ZMemory) violation(addr uint32, write bool, reason string) {
+	panic(&ZMemoryError{Addr: addr, Write: write, Reason: reason})
 }
 
 func (zmem *ZMemory) ByteAt(addr uint32) byte {
-	return (*zmem)[addr]
+	if addr < uint32(len(zmem.dynamic)) {
+		return zmem.dynamic[addr]
+	}
+	if addr >= uint32(len(zmem.story)) {
+		zmem.violation(addr, false, "beyond the end of the story")
+	}
+	return zmem.story[addr]
 }
 
 func (zmem *ZMemory) WordAt(addr uint32) uint16 {
 	// Big Endian
-	return (uint16((*zmem)[addr]) << 8) |
-		(uint16((*zmem)[addr+1]))
+	return (uint16(zmem.ByteAt(addr)) << 8) |
+		(uint16(zmem.ByteAt(addr + 1)))
 }
 
 func (zmem *ZMemory) UInt32At(addr uint32) uint32 {
 	// Big Endian
-	return (uint32((*zmem)[addr]) << 24) |
-		(uint32((*zmem)[addr+1]) << 16) |
-		(uint32((*zmem)[addr+2]) << 8) |
-		uint32((*zmem)[addr+3])
+	return (uint32(zmem.WordAt(addr)) << 16) |
+		uint32(zmem.WordAt(addr+2))
 }
 
+// WriteByteAt is a write made by the story, it can only change dynamic
+// memory and the header fields the story is responsible for
 func (zmem *ZMemory) WriteByteAt(addr uint32, val byte) {
-	(*zmem)[addr] = val
+	switch {
+	case addr >= uint32(len(zmem.dynamic)):
+		zmem.violation(addr, true, "static memory")
+	case addr < headerSize && len(zmem.story) >= headerSize:
+		// rewriting the same value is harmless, Flags 2 is
+		// usually changed by writing the whole word
+		old := zmem.dynamic[addr]
+		if old != val && (addr != 0x11 || (old^val)&^flags2Writable != 0) {
+			zmem.violation(addr, true, "read-only header field")
+		}
+	}
+	zmem.dynamic[addr] = val
 }
 
 func (zmem *ZMemory) WriteWordAt(addr uint32, val uint16) {
-	(*zmem)[addr] = byte(val >> 8)
-	(*zmem)[addr+1] = byte(val & 0X00FF)
+	zmem.WriteByteAt(addr, byte(val>>8))
+	zmem.WriteByteAt(addr+1, byte(val&0X00FF))
+}
+
+// setHeaderByte is a write made by the interpreter to the header
+func (zmem *ZMemory) setHeaderByte(addr uint32, val byte) {
+	zmem.dynamic[addr] = val
+}
+
+func (zmem *ZMemory) setHeaderWord(addr uint32, val uint16) {
+	zmem.setHeaderByte(addr, byte(val>>8))
+	zmem.setHeaderByte(addr+1, byte(val))
 }
 
 func (zmem *ZMemory) GetSequential(addr uint32) *ZMemorySequential {
@@ -104,13 +211,19 @@ func (zmem *ZMemory) DecodeZStringAt(addr uint32, header *ZHeader) string {
 }
 
 func (zmem *ZMemorySequential) DecodeZString(header *ZHeader) string {
-	// v3
+	alphabets := header.charset().alphabets
+
+	version := byte(3)
+	if header != nil && header.version != 0 {
+		version = header.version
+	}
 
 	ret := ""
 	data := uint16(0)
 	code := uint16(0)
 
 	alphabet := uint8(0)
+	// v1 and v2 only, from v3 shifts are temporary
 	shiftLock := uint8(0)
 
 	synonimFlag := false
@@ -143,22 +256,41 @@ func (zmem *ZMemorySequential) DecodeZString(header *ZHeader) string {
 					asciiFirstPart = code << 5
 				} else {
 					asciiPart = 0
-					ret += string(asciiFirstPart | code)
+					if zscii := asciiFirstPart | code; zscii != 0 {
+						ret += string(header.ZSCIIToRune(zscii))
+					}
 				}
 			} else if code > 5 {
 				code -= 6
 
 				if alphabet == 2 && code == 0 {
 					asciiPart = 1
+				} else if alphabet == 2 && code == 1 && version != 1 {
+					ret += "\n"
 				} else {
-					ret += string(Alphabets[alphabet][code])
+					ret += string(header.ZSCIIToRune(uint16(alphabets[alphabet][code])))
 				}
 				alphabet = shiftLock
 			} else if code == 0 {
 				ret += " "
-			} else if code < 4 {
+			} else if code == 1 && version == 1 {
+				ret += "\n"
+			} else if code == 1 || code < 4 && version >= 3 {
+				// v2 has a single table of abbreviations
 				synonimFlag = true
 				synonim = code
+			} else if version <= 2 {
+				// 2 and 4 shift up, 3 and 5 shift down,
+				// 4 and 5 lock the new alphabet
+				next := (shiftLock + 1) % 3
+				if code == 3 || code == 5 {
+					next = (shiftLock + 2) % 3
+				}
+
+				alphabet = next
+				if code >= 4 {
+					shiftLock = next
+				}
 			} else {
 				alphabet = uint8(code - 3)
 				shiftLock = 0
@@ -169,67 +301,75 @@ func (zmem *ZMemorySequential) DecodeZString(header *ZHeader) string {
 	return ret
 }
 
-func ZStringEncode(what string) [encodedZstringLen]uint16 {
+// ZStringEncode encodes what as a dictionary word, truncating or
+// padding it to the length of dictionary entries
+func ZStringEncode(what string, header *ZHeader) []uint16 {
 	const padding byte = 0x05
 
-	ret := [encodedZstringLen]uint16{}
-
-	what = strings.ToLower(what)
-
-	curWordIdx := 0
-	offset := 10
-
-	writeChar := func(ch byte) {
-		ret[curWordIdx] |= uint16(ch) << uint8(offset)
-		offset -= 5
+	wordsCount := encodedZStringLen(header)
+	alphabets := header.charset().alphabets
+	zchars := []byte{}
+
+	// v1 and v2 shift down from A0 to reach A2,
+	// v1 has no newline in A2
+	shiftA2, firstA2 := byte(0x05), 2
+	if header != nil && header.version == 1 {
+		shiftA2, firstA2 = 0x03, 1
+	} else if header != nil && header.version == 2 {
+		shiftA2 = 0x03
+	}
 
-		if offset < 0 {
-			// new word
-			offset = 10
-			curWordIdx++
+	for _, r := range strings.ToLower(what) {
+		ch, ok := header.RuneToZSCII(r)
+		if !ok {
+			ch = '?'
 		}
-	}
 
-	for c := range what {
-		i := strings.IndexByte(Alphabets[0], what[c])
-		if i < 0 {
-			// v3
-			// shift to A2
-			writeChar(0x05)
-			i = strings.IndexByte(Alphabets[2], what[c])
+		if i := strings.IndexByte(alphabets[0], byte(ch)); i >= 0 && ch < 256 {
+			zchars = append(zchars, byte(i+6))
+			continue
 		}
 
-		if i < 0 {
+		// shift to A2
+		zchars = append(zchars, shiftA2)
+
+		if ch == 13 && firstA2 == 2 {
+			// A2 #1 is the newline
+			zchars = append(zchars, 7)
+		} else if i := strings.IndexByte(alphabets[2], byte(ch)); i >= firstA2 && ch < 256 {
+			zchars = append(zchars, byte(i+6))
+		} else {
 			// 10 bit zscii
-			writeChar(0x06)
-			writeChar(what[c] >> 5)
-			writeChar(what[c] & 0x1F)
+			zchars = append(zchars, 0x06, byte(ch>>5), byte(ch&0x1F))
 		}
+	}
 
-		writeChar(byte(i + 6))
-
+	// 3 zchars per word
+	n := wordsCount * 3
+	for len(zchars) < n {
+		zchars = append(zchars, padding)
 	}
+	zchars = zchars[:n]
 
-	for curWordIdx < encodedZstringLen {
-		writeChar(padding)
+	ret := make([]uint16, wordsCount)
+	for i := range ret {
+		ret[i] = uint16(zchars[i*3])<<10 | uint16(zchars[i*3+1])<<5 | uint16(zchars[i*3+2])
 	}
 
 	// end of the string
-	ret[encodedZstringLen-1] |= 1 << 15
+	ret[wordsCount-1] |= 1 << 15
 
 	return ret
 }
 
-func PackedAddress(addr uint32) uint32 {
-	// v3
-	return addr * 2
-}
-
-func IsPackedAddress(addr uint32) bool {
-	// v3
-	return addr%2 == 0
+// encodedZStringLen is the number of words of dictionary entries
+func encodedZStringLen(header *ZHeader) int {
+	if header == nil || header.version <= 3 {
+		return 2
+	}
+	return 3
 }
 
 func (zmem *ZMemory) String() string {
-	return fmt.Sprintf("buf: %v\n", []byte(*zmem))
+	return fmt.Sprintf("buf: %v\n", zmem.Slice(0, zmem.Len()))
 }
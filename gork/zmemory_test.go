@@ -46,7 +46,7 @@ var encodedZstringsExpected [][]uint16 = [][]uint16{
 var byteOrder binary.ByteOrder = binary.BigEndian
 
 func TestByteAt(t *testing.T) {
-	mem := ZMemory(readTestData)
+	mem := *NewZMemory(readTestData)
 
 	for i := range readTestData {
 		if readTestData[i] != mem.ByteAt(uint32(i)) {
@@ -56,27 +56,27 @@ func TestByteAt(t *testing.T) {
 }
 
 func TestWordAt(t *testing.T) {
-	mem := ZMemory(readTestData)
+	mem := *NewZMemory(readTestData)
 
 	for i := uint32(0); i < uint32(len(readTestData)/2); i++ {
-		if byteOrder.Uint16(mem[i:i+2]) != mem.WordAt(i) {
+		if byteOrder.Uint16(readTestData[i:i+2]) != mem.WordAt(i) {
 			t.Fail()
 		}
 	}
 }
 
 func TestUint32At(t *testing.T) {
-	mem := ZMemory(readTestData)
+	mem := *NewZMemory(readTestData)
 
 	for i := uint32(0); i < uint32(len(readTestData)/4); i++ {
-		if byteOrder.Uint16(mem[i:i+4]) != mem.WordAt(i) {
+		if byteOrder.Uint16(readTestData[i:i+4]) != mem.WordAt(i) {
 			t.Fail()
 		}
 	}
 }
 
 func TestWriteByteAt(t *testing.T) {
-	mem := ZMemory(readTestData)
+	mem := *NewZMemory(readTestData)
 
 	for i := range readTestData {
 		mem.WriteByteAt(uint32(i), writeTestData[i])
@@ -87,7 +87,7 @@ func TestWriteByteAt(t *testing.T) {
 }
 
 func TestWriteWordAt(t *testing.T) {
-	mem := ZMemory(readTestData)
+	mem := *NewZMemory(readTestData)
 
 	for i := uint32(0); i < uint32(len(readTestData)/2); i++ {
 		toWrite := byteOrder.Uint16(writeTestData[i : i+2])
@@ -98,8 +98,86 @@ func TestWriteWordAt(t *testing.T) {
 	}
 }
 
+func TestZMemoryClone(t *testing.T) {
+	buf := make([]byte, 0x80)
+	// dynamic memory ends at 0x60
+	buf[0x0F] = 0x60
+	buf[0x70] = 42
+
+	mem := NewZMemory(buf)
+	clone := mem.Clone()
+
+	clone.WriteByteAt(0x50, 1)
+	if mem.ByteAt(0x50) != 0 || clone.ByteAt(0x50) != 1 || clone.ByteAt(0x70) != 42 {
+		t.Fail()
+	}
+
+	if clone.DynamicLen() != 0x60 || clone.Len() != 0x80 {
+		t.Fail()
+	}
+
+	// slices across the end of dynamic memory are copies
+	s := clone.Slice(0x5F, 0x71)
+	if len(s) != 0x12 || s[0x11] != 42 {
+		t.Fail()
+	}
+
+	defer func() {
+		if recover() == nil || buf[0x70] != 42 {
+			t.Fail()
+		}
+	}()
+
+	// static memory is shared and can't be written
+	clone.WriteByteAt(0x70, 0)
+}
+
+func TestZMemoryHeaderRules(t *testing.T) {
+	buf := make([]byte, 0x80)
+	buf[0x0F] = 0x60
+	buf[0x11] = 0x10
+	mem := NewZMemory(buf)
+
+	violation := func(write func()) (merr *ZMemoryError) {
+		defer func() {
+			merr, _ = recover().(*ZMemoryError)
+		}()
+		write()
+		return nil
+	}
+
+	// transcript and fixed pitch bits of Flags 2, whole word stored
+	if violation(func() { mem.WriteWordAt(0x10, 0x0013) }) != nil || buf[0x11] != 0x13 {
+		t.Fail()
+	}
+
+	// the same value can be stored anywhere in the header
+	if violation(func() { mem.WriteByteAt(0x0F, 0x60) }) != nil {
+		t.Fail()
+	}
+
+	expected := []struct {
+		write  func()
+		addr   uint32
+		isRead bool
+	}{
+		{func() { mem.WriteByteAt(0x11, 0x03) }, 0x11, false},
+		{func() { mem.WriteWordAt(0x1E, 0x0101) }, 0x1E, false},
+		{func() { mem.WriteByteAt(0x60, 1) }, 0x60, false},
+		{func() { mem.ByteAt(0x80) }, 0x80, true},
+		{func() { mem.Slice(0x70, 0x90) }, 0x8F, true},
+	}
+
+	for _, e := range expected {
+		merr := violation(e.write)
+		if merr == nil || merr.Addr != e.addr || merr.Write == e.isRead {
+			t.Fail()
+		}
+	}
+}
+
 func TestPeekByte(t *testing.T) {
-	mem := ZMemory(readTestData)
+	mem := *NewZMemory(readTestData)
 	seq := mem.GetSequential(0)
 
 	for i := range readTestData {
@@ -111,10 +189,10 @@ func TestPeekByte(t *testing.T) {
 }
 
 func TestPeekWord(t *testing.T) {
-	mem := ZMemory(readTestData)
+	mem := *NewZMemory(readTestData)
 	seq := mem.GetSequential(0)
 
-	for i := uint32(0); i < uint32(len(mem)/2); i++ {
+	for i := uint32(0); i < uint32(len(readTestData)/2); i++ {
 		if seq.PeekWord() != seq.mem.WordAt(seq.pos) || seq.pos != uint32(i*2) {
 			t.Fail()
 		}
@@ -123,10 +201,10 @@ func TestPeekWord(t *testing.T) {
 }
 
 func TestPeekUint32(t *testing.T) {
-	mem := ZMemory(readTestData)
+	mem := *NewZMemory(readTestData)
 	seq := mem.GetSequential(0)
 
-	for i := uint32(0); i < uint32(len(mem)/4); i++ {
+	for i := uint32(0); i < uint32(len(readTestData)/4); i++ {
 		if seq.PeekUInt32() != seq.mem.UInt32At(seq.pos) || seq.pos != uint32(i*4) {
 			t.Fail()
 		}
@@ -135,7 +213,7 @@ func TestPeekUint32(t *testing.T) {
 }
 
 func TestReadByte(t *testing.T) {
-	mem := ZMemory(readTestData)
+	mem := *NewZMemory(readTestData)
 	seq := mem.GetSequential(0)
 
 	for i := range readTestData {
@@ -146,10 +224,10 @@ func TestReadByte(t *testing.T) {
 }
 
 func TestReadWord(t *testing.T) {
-	mem := ZMemory(readTestData)
+	mem := *NewZMemory(readTestData)
 	seq := mem.GetSequential(0)
 
-	for i := uint32(0); i < uint32(len(mem)/2); i++ {
+	for i := uint32(0); i < uint32(len(readTestData)/2); i++ {
 		if seq.pos != uint32(i*2) || seq.ReadWord() != seq.mem.WordAt(i*2) {
 			t.Fail()
 		}
@@ -157,10 +235,10 @@ func TestReadWord(t *testing.T) {
 }
 
 func TestReadUint32(t *testing.T) {
-	mem := ZMemory(readTestData)
+	mem := *NewZMemory(readTestData)
 	seq := mem.GetSequential(0)
 
-	for i := uint32(0); i < uint32(len(mem)/4); i++ {
+	for i := uint32(0); i < uint32(len(readTestData)/4); i++ {
 		if seq.pos != uint32(i*4) || seq.ReadUint32() != seq.mem.UInt32At(i*4) {
 			t.Fail()
 		}
@@ -168,7 +246,7 @@ func TestReadUint32(t *testing.T) {
 }
 
 func TestWriteByte(t *testing.T) {
-	mem := ZMemory(readTestData)
+	mem := *NewZMemory(readTestData)
 	seq := mem.GetSequential(0)
 
 	for i := range readTestData {
@@ -180,7 +258,7 @@ func TestWriteByte(t *testing.T) {
 }
 
 func TestWriteWord(t *testing.T) {
-	mem := ZMemory(readTestData)
+	mem := *NewZMemory(readTestData)
 	seq := mem.GetSequential(0)
 
 	for i := uint32(0); i < uint32(len(readTestData)/2); i++ {
@@ -199,17 +277,9 @@ func TestWriteWord(t *testing.T) {
 	}
 }
 
-func TestPackedAddres(t *testing.T) {
-	for i := uint32(0); i < 10; i++ {
-		if !IsPackedAddress(PackedAddress(i)) {
-			t.Fail()
-		}
-	}
-}
-
 func TestZStringDecodeAt(t *testing.T) {
 	for i, zstring := range zstrings {
-		mem := ZMemory(zstring)
+		mem := *NewZMemory(zstring)
 
 		// in this case zstring doesn't have abbreviations,
 		// so don't pass the header
@@ -221,7 +291,7 @@ func TestZStringDecodeAt(t *testing.T) {
 
 func TestZStringDecode(t *testing.T) {
 	for _, zstring := range zstrings {
-		mem := ZMemory(zstring)
+		mem := *NewZMemory(zstring)
 		seq := mem.GetSequential(0)
 
 		if mem.DecodeZStringAt(0, header) != seq.DecodeZString(header) {
@@ -235,7 +305,7 @@ func TestZStringDecode(t *testing.T) {
 func TestZStringEncode(t *testing.T) {
 	for i, zstr := range encodedZstrings {
 		expected := encodedZstringsExpected[i]
-		encoded := ZStringEncode(zstr)
+		encoded := ZStringEncode(zstr, nil)
 
 		for i := range encoded {
 			if encoded[i] != expected[i] {
@@ -248,7 +318,7 @@ func TestZStringEncode(t *testing.T) {
 				buf[i*2+1] = byte(v)
 			}
 
-			seq := ZMemory(buf)
+			seq := *NewZMemory(buf)
 			decoded := seq.DecodeZStringAt(0, nil)
 
 			if decoded != zstr {
@@ -258,3 +328,102 @@ func TestZStringEncode(t *testing.T) {
 
 	}
 }
+
+func TestZStringEncodeV4(t *testing.T) {
+	header := &ZHeader{version: 4}
+
+	encoded := ZStringEncode("lanterns", header)
+	if len(encoded) != 3 || encoded[2]&0x8000 == 0 || encoded[1]&0x8000 != 0 {
+		t.Fail()
+	}
+
+	buf := make([]byte, len(encoded)*2)
+	for i, v := range encoded {
+		buf[i*2] = byte(v >> 8)
+		buf[i*2+1] = byte(v)
+	}
+
+	// v4 words have room for 9 zchars
+	mem := *NewZMemory(buf)
+	if mem.DecodeZStringAt(0, header) != "lanterns" {
+		t.Fail()
+	}
+
+	if len(ZStringEncode("a-very-long-word", header)) != 3 {
+		t.Fail()
+	}
+}
+
+func TestZStringDecodeCustomAlphabet(t *testing.T) {
+	header := &ZHeader{version: 5}
+	header.customCharset = &zcharset{
+		alphabets: [3]string{Alphabets[1], Alphabets[0], Alphabets[2]},
+		unicode:   defaultCharset.unicode,
+	}
+
+	mem := *NewZMemory([]byte{
+		// Z O R
+		0x7E, 0x97,
+		// K, shift A2, ZSCII escape
+		0x40, 0xA6,
+		// 155 (4 27), padding
+		0x93, 0x65,
+	})
+
+	if mem.DecodeZStringAt(0, header) != "ZORKä" {
+		t.Fail()
+	}
+}
+
+func TestZStringDecodeEarlyVersions(t *testing.T) {
+	data := [][]byte{
+		// v1 shift up, H, i, newline, shift lock down, 0, <
+		[]byte{0x09, 0xAE, 0x04, 0xA7, 0xEC, 0xA5},
+		// v2 shift lock up, A, B, C, shift lock up, 0
+		[]byte{0x10, 0xC7, 0xA0, 0x88},
+	}
+	versions := []byte{1, 2}
+	expected := []string{"Hi\n0<", "ABC0"}
+
+	for i, d := range data {
+		mem := *NewZMemory(d)
+		if mem.DecodeZStringAt(0, &ZHeader{version: versions[i]}) != expected[i] {
+			t.Fail()
+		}
+	}
+}
+
+func TestZStringDecodeV2Abbreviations(t *testing.T) {
+	buf := make([]byte, 0x30)
+	mem := *NewZMemory(buf)
+
+	// abbreviation #2 is "zork" at 0x20
+	mem.WriteWordAt(0x14, 0x20/2)
+	copy(buf[0x20:], []byte{0x7E, 0x97, 0xC0, 0xA5})
+	// abbreviation 1 2
+	copy(buf[0x00:], []byte{0x84, 0x45})
+
+	header := &ZHeader{version: 2, abbrTblPos: 0x10}
+	if mem.DecodeZStringAt(0, header) != "zork" || abbreviationsCount(header) != 32 {
+		t.Fail()
+	}
+}
+
+func TestZStringEncodeEarlyVersions(t *testing.T) {
+	for _, version := range []byte{1, 2} {
+		header := &ZHeader{version: version}
+
+		encoded := ZStringEncode("a0", header)
+
+		// v1 and v2 shift down to A2
+		if encoded[0]>>10 != 6 || (encoded[0]>>5)&0x1F != 3 {
+			t.Fail()
+		}
+
+		buf := []byte{byte(encoded[0] >> 8), byte(encoded[0]), byte(encoded[1] >> 8), byte(encoded[1])}
+		mem := *NewZMemory(buf)
+		if mem.DecodeZStringAt(0, header) != "a0" {
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package gork
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// meta-commands start with it by default, see MetaPrefix
+const defaultMetaPrefix = "#"
+
+// ZMetaFunc runs a meta-command, args are the words typed after its name
+type ZMetaFunc func(zm *ZMachine, args []string)
+
+type zmetaCommand struct {
+	help string
+	run  ZMetaFunc
+}
+
+// zmeta holds the commands handled by the interpreter instead of
+// the story, they're typed at any read prefixed by prefix
+type zmeta struct {
+	prefix   string
+	commands map[string]zmetaCommand
+	// state of the turn the command has been typed at
+	current zsnapshot
+}
+
+func newZMeta() *zmeta {
+	meta := &zmeta{
+		prefix:   defaultMetaPrefix,
+		commands: map[string]zmetaCommand{},
+	}
+
+	meta.register("help", "lists the commands", metaHelp)
+	meta.register("quit", "quits the game", metaQuit)
+	meta.register("undo", "takes back the last turn", metaUndo)
+	meta.register("redo", "redoes the turn taken back last", metaRedo)
+	meta.register("save", "[slot] saves the game to the slot", metaSave)
+	meta.register("restore", "[slot] restores the game saved to the slot", metaRestore)
+	meta.register("transcript", "on [file] | off starts or stops the transcript", metaTranscript)
+	meta.register("seed", "[n] makes random numbers predictable, random without n", metaSeed)
+	meta.register("status", "shows the state of the interpreter", metaStatus)
+
+	return meta
+}
+
+func (meta *zmeta) register(name string, help string, run ZMetaFunc) {
+	meta.commands[strings.ToLower(name)] = zmetaCommand{help, run}
+}
+
+// MetaPrefix sets what meta-commands start with, "" disables them
+func MetaPrefix(prefix string) ZOption {
+	return func(zm *ZMachine) {
+		zm.meta.prefix = prefix
+	}
+}
+
+// RegisterMetaCommand adds the meta-command name, or replaces it,
+// help is shown by the help command
+func (zm *ZMachine) RegisterMetaCommand(name string, help string, run ZMetaFunc) {
+	zm.meta.register(name, help, run)
+}
+
+// Print shows text to the player as the story does,
+// meta-commands print through it
+func (zm *ZMachine) Print(a ...interface{}) {
+	zm.print(a...)
+}
+
+// metaCommand runs the meta-command typed at the read instruction at pc,
+// it returns false if line is not one, otherwise the read is executed
+// again unless the command replaced the state
+func (zm *ZMachine) metaCommand(line string, pc uint32) bool {
+	if zm.meta == nil || zm.meta.prefix == "" {
+		return false
+	}
+
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, zm.meta.prefix) {
+		return false
+	}
+
+	words := strings.Fields(line[len(zm.meta.prefix):])
+	if len(words) == 0 {
+		return false
+	}
+
+	zm.meta.current = zm.resumeTurn(pc)
+
+	name := strings.ToLower(words[0])
+	if cmd, ok := zm.meta.commands[name]; ok {
+		cmd.run(zm, words[1:])
+	} else {
+		zm.print("[Unknown command ", zm.meta.prefix, name, ", type ",
+			zm.meta.prefix, "help for the list.]\n")
+	}
+
+	return true
+}
+
+func metaHelp(zm *ZMachine, args []string) {
+	names := make([]string, 0, len(zm.meta.commands))
+	for name := range zm.meta.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		zm.print(zm.meta.prefix, name, " ", zm.meta.commands[name].help, "\n")
+	}
+}
+
+func metaQuit(zm *ZMachine, args []string) {
+	zm.quitted = true
+}
+
+func metaUndo(zm *ZMachine, args []string) {
+	zm.undoTurn(zm.meta.current)
+}
+
+func metaRedo(zm *ZMachine, args []string) {
+	zm.redoTurn(zm.meta.current)
+}
+
+func slotName(args []string) string {
+	if len(args) == 0 {
+		return "default"
+	}
+	return strings.ToLower(args[0])
+}
+
+// slotFile returns the file #save writes the slot name to, it's kept
+// with the other files of the player
+func (zm *ZMachine) slotFile(name string) (string, error) {
+	return zm.playerFile(name + ".slot")
+}
+
+func metaSave(zm *ZMachine, args []string) {
+	name := slotName(args)
+	state := zm.meta.current
+
+	path, err := zm.slotFile(name)
+	if err == nil {
+		buf := new(bytes.Buffer)
+		if err = zm.writeQuetzal(buf, slotForm, state.pc, state.mem, state.stack); err == nil {
+			err = ioutil.WriteFile(path, buf.Bytes(), 0644)
+		}
+	}
+	if err != nil {
+		zm.print("[Save failed: ", err, ".]\n")
+		return
+	}
+
+	zm.print("[Saved to ", name, ".]\n")
+}
+
+func metaRestore(zm *ZMachine, args []string) {
+	name := slotName(args)
+
+	state, err := zm.readSlot(name)
+	if os.IsNotExist(err) {
+		zm.print("[Nothing saved to ", name, ".]\n")
+		return
+	}
+	if err != nil {
+		zm.print("[Restore failed: ", err, ".]\n")
+		return
+	}
+
+	// the current turn can be undone as usual
+	if zm.autoUndo() {
+		zm.undo.push(zm.meta.current)
+	}
+	state.random = zm.random
+	zm.restoreSnapshot(state)
+	zm.initHeader()
+	zm.print("[Restored ", name, ".]\n")
+}
+
+func (zm *ZMachine) readSlot(name string) (zsnapshot, error) {
+	path, err := zm.slotFile(name)
+	if err != nil {
+		return zsnapshot{}, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return zsnapshot{}, err
+	}
+	defer f.Close()
+
+	return zm.readQuetzal(f, slotForm)
+}
+
+func metaTranscript(zm *ZMachine, args []string) {
+	switch {
+	case len(args) == 0:
+		if zm.Transcribing() {
+			zm.print("[Transcript is on.]\n")
+		} else {
+			zm.print("[Transcript is off.]\n")
+		}
+	case args[0] == "off":
+		zm.CloseTranscript()
+		zm.print("[Transcript off.]\n")
+	case args[0] == "on" && len(args) > 1:
+		if err := zm.OpenTranscript(args[1]); err != nil {
+			zm.print("[Transcript failed: ", err, ".]\n")
+			return
+		}
+		zm.print("[Transcript on.]\n")
+	case args[0] == "on":
+		zm.setTranscriptFlag(true)
+		if zm.syncTranscript() {
+			zm.print("[Transcript on.]\n")
+		}
+	default:
+		zm.print("[Type transcript on or off.]\n")
+	}
+}
+
+func metaSeed(zm *ZMachine, args []string) {
+	if len(args) == 0 {
+		zm.random.fixed = 0
+		zm.random.randomize()
+		zm.print("[Random numbers are random.]\n")
+		return
+	}
+
+	seed, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		zm.print("[", args[0], " is not a number.]\n")
+		return
+	}
+
+	RandomSeed(seed)(zm)
+	zm.print("[Random numbers are predictable.]\n")
+}
+
+func metaStatus(zm *ZMachine, args []string) {
+	transcript := "off"
+	if zm.Transcribing() {
+		transcript = "on"
+	}
+
+	zm.print(fmt.Sprintf("[Version %d, release %d, serial %s.]\n",
+		zm.header.version, zm.header.release, string(zm.header.serial[:])))
+	zm.print(fmt.Sprintf("[%d turns can be undone, %d redone, transcript %s.]\n",
+		zm.undo.len(), zm.redo.len(), transcript))
+}
@@ -0,0 +1,155 @@
+package gork
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newMetaZMachine(mem ZMemory) *ZMachine {
+	return &ZMachine{
+		header:   &ZHeader{version: 3, dynMemSize: uint16(mem.Len())},
+		seq:      mem.GetSequential(0),
+		stack:    ZStack{&ZRoutine{locals: []uint16{}}},
+		screen:   NewZScreen(80, 25),
+		streams:  zstreams{screen: true},
+		undo:     zsnapshots{depth: defaultUndoDepth},
+		redo:     zsnapshots{depth: defaultUndoDepth},
+		meta:     newZMeta(),
+		original: make([]byte, mem.Len()),
+	}
+}
+
+func TestZMetaCommands(t *testing.T) {
+	mem := *NewZMemory(make([]byte, 0x80))
+	zm := newMetaZMachine(mem)
+
+	var got []string
+	zm.RegisterMetaCommand("Who", "lists the players", func(zm *ZMachine, args []string) {
+		got = args
+		zm.Print("[alice]\n")
+	})
+
+	expected := []struct {
+		line   string
+		meta   bool
+		output string
+	}{
+		{"look", false, ""},
+		{"#", false, ""},
+		{" #who  is there ", true, "[alice]\n"},
+		{"#dance", true, "[Unknown command #dance, type #help for the list.]\n"},
+		{"#quit", true, ""},
+	}
+
+	for _, e := range expected {
+		zm.seq.pos = 0x30
+		if zm.metaCommand(e.line, 0x10) != e.meta {
+			t.FailNow()
+		}
+
+		spans, _ := zm.screen.TakeLower()
+		output := ""
+		for _, span := range spans {
+			output += span.Text
+		}
+
+		// the read is executed again
+		if output != e.output || e.meta && zm.seq.pos != 0x10 {
+			t.FailNow()
+		}
+	}
+
+	if len(got) != 2 || got[0] != "is" || got[1] != "there" || !zm.quitted {
+		t.Fail()
+	}
+
+	MetaPrefix("/")(zm)
+	if zm.metaCommand("#who", 0x10) || !zm.metaCommand("/who", 0x10) {
+		t.Fail()
+	}
+
+	MetaPrefix("")(zm)
+	if zm.metaCommand("/who", 0x10) {
+		t.Fail()
+	}
+}
+
+func TestZMetaSlots(t *testing.T) {
+	dir := t.TempDir()
+	buf := make([]byte, 0x80)
+	mem := *NewZMemory(buf)
+	zm := newMetaZMachine(mem)
+	SaveDir(dir)(zm)
+
+	// as the read instruction at pc does
+	read := func(zm *ZMachine, line string, pc uint32) {
+		zm.snapshotTurn(pc)
+		zm.metaCommand(line, pc)
+	}
+
+	buf[0x40] = 1
+	read(zm, "#save before", 0x10)
+
+	buf[0x40] = 2
+	zm.stack.Top().push(42)
+	read(zm, "#restore nothing", 0x20)
+	if buf[0x40] != 2 {
+		t.FailNow()
+	}
+
+	read(zm, "#restore BEFORE", 0x20)
+	if buf[0x40] != 1 || len(zm.stack.Top().stack) != 0 || zm.seq.pos != 0x10 {
+		t.FailNow()
+	}
+
+	// the restore can be undone
+	read(zm, "#undo", 0x10)
+	if buf[0x40] != 2 || zm.seq.pos != 0x20 {
+		t.FailNow()
+	}
+
+	// and the slot restored again
+	read(zm, "#restore before", 0x20)
+	if buf[0x40] != 1 {
+		t.Fail()
+	}
+
+	// slots are files surviving the session
+	other := newMetaZMachine(*NewZMemory(make([]byte, 0x80)))
+	SaveDir(dir)(other)
+	read(other, "#restore before", 0x30)
+	if other.seq.mem.ByteAt(0x40) != 1 || other.seq.pos != 0x10 {
+		t.Fail()
+	}
+
+	// the save opcode can't restore them, nor #restore a save file
+	f, err := os.Open(filepath.Join(dir, "before.slot"))
+	if err != nil {
+		t.FailNow()
+	}
+	defer f.Close()
+	if other.RestoreQuetzal(f) == nil {
+		t.Fail()
+	}
+
+	if other.saveToFile("game.slot") != nil {
+		t.FailNow()
+	}
+	read(other, "#restore game", 0x30)
+	if other.seq.pos != 0x30 {
+		t.Fail()
+	}
+
+	spans, _ := other.screen.TakeLower()
+	if len(spans) != 1 || spans[0].Text != "[Restored before.]\n"+
+		"[Restore failed: not a slot file.]\n" {
+		t.Fail()
+	}
+
+	// remote players can't name files outside of their directory
+	read(other, "#transcript on ../transcript", 0x30)
+	if _, err := os.Stat(filepath.Join(dir, "..", "transcript")); !os.IsNotExist(err) {
+		t.Fail()
+	}
+}
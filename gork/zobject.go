@@ -3,292 +3,381 @@ package gork
 import (
 	"errors"
 	"fmt"
-	"sort"
+	"strings"
 )
 
-const (
-	MaxZObjects       = 255       // v3
-	zobjectSize       = uint32(9) // v3
-	propertyOffset    = uint32(7) //v3
-	NULL_OBJECT_INDEX = uint8(0)
-)
+const NULL_OBJECT_INDEX = uint16(0)
 
+// ZObject is a view over an entry of the object table, every read
+// and write goes straight to memory so that the story observes the
+// same object state either via object opcodes or via loads and stores
 type ZObject struct {
-	number        uint8
-	attributes    [32]bool //v3 never gonna be more than 32
-	parent        byte
-	sibling       byte
-	child         byte
-	name          string
-	propertiesPos uint16
-	properties    map[byte][]byte
-	mem           *ZMemory
-	header        *ZHeader
-}
-
-func NewZObject(mem *ZMemory, number uint8, header *ZHeader) (*ZObject, error) {
-	obj := new(ZObject)
-	if err := obj.configure(mem, number, header); err != nil {
+	number uint16
+	addr   uint32
+	mem    *ZMemory
+	header *ZHeader
+	layout *zobjectLayout
+}
+
+// zobjectLayout describes the object table of a given version
+type zobjectLayout struct {
+	maxObjects      uint16
+	defaultsCount   uint32
+	maxProperty     byte
+	attributesCount uint16
+	size            uint32
+	// offsets of the fields of an object table entry,
+	// attributes are at offset 0
+	parentOffset   uint32
+	siblingOffset  uint32
+	childOffset    uint32
+	propertyOffset uint32
+	// relatives are bytes in v1-3 and words from v4
+	wideRelatives bool
+}
+
+var zobjectLayoutV3 = &zobjectLayout{
+	maxObjects:      255,
+	defaultsCount:   31,
+	maxProperty:     31,
+	attributesCount: 32,
+	size:            9,
+	parentOffset:    4,
+	siblingOffset:   5,
+	childOffset:     6,
+	propertyOffset:  7,
+	wideRelatives:   false,
+}
+
+var zobjectLayoutV4 = &zobjectLayout{
+	maxObjects:      65535,
+	defaultsCount:   63,
+	maxProperty:     63,
+	attributesCount: 48,
+	size:            14,
+	parentOffset:    6,
+	siblingOffset:   8,
+	childOffset:     10,
+	propertyOffset:  12,
+	wideRelatives:   true,
+}
+
+func objectLayout(header *ZHeader) *zobjectLayout {
+	if header.version <= 3 {
+		return zobjectLayoutV3
+	}
+	return zobjectLayoutV4
+}
+
+func MaxZObjects(header *ZHeader) uint16 {
+	return objectLayout(header).maxObjects
+}
+
+func NewZObject(mem *ZMemory, number uint16, header *ZHeader) (*ZObject, error) {
+	addr, err := ZObjectAddress(number, header)
+	if err != nil {
 		return nil, err
 	}
-	return obj, nil
+
+	return &ZObject{
+		number: number,
+		addr:   addr,
+		mem:    mem,
+		header: header,
+		layout: objectLayout(header),
+	}, nil
 }
 
-func (obj *ZObject) configure(mem *ZMemory, number uint8, header *ZHeader) error {
-	obj.number = number
+// relative returns another object of the same table
+func (obj *ZObject) relative(number uint16) *ZObject {
+	if number == NULL_OBJECT_INDEX {
+		return nil
+	}
+
+	other, _ := NewZObject(obj.mem, number, obj.header)
+	return other
+}
 
-	obj.mem = mem
-	obj.header = header
+// attributes are 32 bit in v1-3 and 48 bit from v4
+// more significant bit <-> attribute # smaller
+//
+// Bit  #  0 1 2 3 4 5 6 7
+// Attr #  7 6 5 4 3 2 1 0
+func (obj *ZObject) Attribute(attr uint16) bool {
+	bits := obj.mem.ByteAt(obj.addr + uint32(attr/8))
+	return bits&(0x80>>(attr%8)) != 0
+}
 
-	addr, err := ZObjectAddress(number, header)
+func (obj *ZObject) SetAttribute(attr uint16, value bool) {
+	addr := obj.addr + uint32(attr/8)
+	mask := byte(0x80 >> (attr % 8))
 
-	if err != nil {
-		return err
+	bits := obj.mem.ByteAt(addr)
+	if value {
+		bits |= mask
+	} else {
+		bits &^= mask
 	}
+	obj.mem.WriteByteAt(addr, bits)
+}
 
-	seq := mem.GetSequential(addr)
+func (obj *ZObject) AttributesCount() uint16 {
+	return obj.layout.attributesCount
+}
 
-	// v3 attributes is 32 bit
-	// more significant bit <-> attribute # smaller
-	//
-	// Bit  #  0 1 2 3 4 5 6 7
-	// Attr #  7 6 5 4 3 2 1 0
-	attr := seq.ReadUint32()
-	for i := 3; i >= 0; i-- {
-		byteno := uint8(3 - i)
-		bits := uint8(attr >> (uint8(i) * 8))
-		for j := 7; j >= 0; j-- {
-			obj.attributes[byteno*8+7-uint8(j)] = (bits>>uint8(j))&0x01 == 0x01
-		}
+func (obj *ZObject) relativeAt(offset uint32) uint16 {
+	if obj.layout.wideRelatives {
+		return obj.mem.WordAt(obj.addr + offset)
 	}
+	return uint16(obj.mem.ByteAt(obj.addr + offset))
+}
 
-	obj.parent = seq.ReadByte()
-	obj.sibling = seq.ReadByte()
-	obj.child = seq.ReadByte()
-	obj.propertiesPos = seq.ReadWord()
+func (obj *ZObject) setRelativeAt(offset uint32, number uint16) {
+	if obj.layout.wideRelatives {
+		obj.mem.WriteWordAt(obj.addr+offset, number)
+	} else {
+		obj.mem.WriteByteAt(obj.addr+offset, byte(number))
+	}
+}
 
-	obj.readProperties(header)
-	return nil
+func (obj *ZObject) setParent(parent uint16) {
+	obj.setRelativeAt(obj.layout.parentOffset, parent)
 }
 
-func (obj *ZObject) readProperties(header *ZHeader) {
-	// v3
+func (obj *ZObject) setSibling(sibling uint16) {
+	obj.setRelativeAt(obj.layout.siblingOffset, sibling)
+}
 
-	obj.properties = make(map[byte][]byte)
+func (obj *ZObject) setChild(child uint16) {
+	obj.setRelativeAt(obj.layout.childOffset, child)
+}
 
-	seq := obj.mem.GetSequential(uint32(obj.propertiesPos))
+func (obj *ZObject) PropertiesPos() uint16 {
+	return obj.mem.WordAt(obj.addr + obj.layout.propertyOffset)
+}
 
-	// number of words
-	textLength := uint16(seq.ReadByte())
-	if textLength != 0 {
-		obj.name = string(seq.DecodeZString(header))
+// propertyHeader decodes the size byte(s) of the property at addr and
+// returns its number, the length of its data and the size of the
+// header itself, id is 0 at the end of the property list
+func (obj *ZObject) propertyHeader(addr uint32) (id byte, length uint32, headerSize uint32) {
+	size := obj.mem.ByteAt(addr)
+
+	if obj.header.version <= 3 {
+		return size & 0x1F, uint32(size>>5) + 1, 1
 	}
 
-	dataSize := seq.ReadByte()
-	for dataSize > 0 {
-		prop := dataSize & (0x20 - 1)
-		count := ((dataSize & 0xE0) >> 5) + 1
+	id = size & 0x3F
 
-		for i := byte(0); i < count; i++ {
-			obj.properties[prop] = append(obj.properties[prop],
-				seq.ReadByte())
-		}
-		dataSize = seq.ReadByte()
+	if size&0x80 == 0 {
+		// bit #6 set means 2 bytes long
+		return id, uint32((size>>6)&0x01) + 1, 1
+	}
+
+	// length is in the bottom 6 bits of the second size byte
+	length = uint32(obj.mem.ByteAt(addr+1) & 0x3F)
+	if length == 0 {
+		length = 64
 	}
+	return id, length, 2
 }
 
 func (obj *ZObject) SetProperty(propertyId byte, value uint16) error {
-	if _, ok := obj.properties[propertyId]; !ok {
-		return errors.New(fmt.Sprintf("Property %d not found\n", propertyId))
+	addr := obj.GetPropertyAddr(propertyId)
+	if addr == 0 {
+		return fmt.Errorf("property %d of object %d not found", propertyId, obj.number)
 	}
 
-	// TODO
-	// check if it is legal for a story to get/set property
-	// directly via store/load or it is forced to use putprop/getprop
-	// in case it's legal try to uncomment the commented lines and hope
-	// the best
-	// however if the story table address can be modified as well
-	// than ZMachine cannot cache objects, because otherwise it would
-	// be too complex to intercept writes to the address of the property
-	// table of an object
-
-	// addr := obj.GetPropertyAddr(propertyId)
-
-	// it seems we cannot take address of values of a map :'(
-	switch len(obj.properties[propertyId]) {
+	switch GetPropertyLen(obj.mem, addr, obj.header) {
 	case 1:
 		// store only least significant byte
-		data := byte(value & 0x00FF)
-		obj.properties[propertyId][0] = data
-		// obj.mem.WriteByteAt(addr, data)
+		obj.mem.WriteByteAt(addr, byte(value&0x00FF))
 	case 2:
-		// big endian
-		obj.properties[propertyId][0] = byte((value >> 8) & 0x00FF)
-		obj.properties[propertyId][1] = byte(value & 0x00FF)
-		// obj.mem.WriteWordAt(addr, value)
+		obj.mem.WriteWordAt(addr, value)
 	default:
-		return errors.New(fmt.Sprintf("cannot set property, because its length is > 2 bytes"))
+		return errors.New("cannot set property, because its length is > 2 bytes")
 	}
 	return nil
 }
 
 func (obj *ZObject) GetProperty(propertyId byte) (uint16, error) {
-	if _, ok := obj.properties[propertyId]; !ok {
+	addr := obj.GetPropertyAddr(propertyId)
+
+	if addr == 0 {
 		// DON'T PANIC, cause the property could be in the
 		// global default properties table
 
-		// v3
-		if propertyId < 1 || propertyId > 31 {
-			return 0, errors.New(fmt.Sprintf("Invalid propertyIndex %d, values range in v3 is [1,31]\n", propertyId))
+		if propertyId < 1 || propertyId > obj.layout.maxProperty {
+			return 0, fmt.Errorf("Invalid propertyIndex %d, values range is [1,%d]", propertyId, obj.layout.maxProperty)
 		}
 
 		// property table is a sequence of words
-		addr := uint32(obj.header.objTblPos) + uint32((propertyId-1)*2)
-		return obj.mem.WordAt(addr), nil
+		defaultAddr := uint32(obj.header.objTblPos) + uint32(propertyId-1)*2
+		return obj.mem.WordAt(defaultAddr), nil
 	}
 
-	res := uint16(0)
-
-	// it seems we cannot take address of values of a map :'(
-	switch len(obj.properties[propertyId]) {
+	switch GetPropertyLen(obj.mem, addr, obj.header) {
 	case 1:
-		res = uint16(obj.properties[propertyId][0])
+		return uint16(obj.mem.ByteAt(addr)), nil
 	case 2:
-		// big endian
-		res |= uint16(obj.properties[propertyId][0]) << 8
-		res |= uint16(obj.properties[propertyId][1])
+		return obj.mem.WordAt(addr), nil
 	default:
 		return 0, errors.New("cannot get property, because its length is > 2 bytes")
 	}
+}
+
+// PropertyData returns a copy of the data of propertyId,
+// nil if the object doesn't have it
+func (obj *ZObject) PropertyData(propertyId byte) []byte {
+	addr := obj.GetPropertyAddr(propertyId)
+	if addr == 0 {
+		return nil
+	}
+
+	n := uint32(GetPropertyLen(obj.mem, addr, obj.header))
+	ret := make([]byte, n)
+	for i := uint32(0); i < n; i++ {
+		ret[i] = obj.mem.ByteAt(addr + i)
+	}
 
-	return res, nil
+	return ret
 }
 
-func GetPropertyLen(mem *ZMemory, propertyPos uint32) uint16 {
+func GetPropertyLen(mem *ZMemory, propertyPos uint32, header *ZHeader) uint16 {
 	// the property size byte is the byte before propertyPos
 	size := mem.ByteAt(uint32(propertyPos - 1))
-	nbytes := (size >> 5) + 1
-	return uint16(nbytes)
+
+	if header.version <= 3 {
+		return uint16(size>>5) + 1
+	}
+
+	if size&0x80 == 0 {
+		return uint16((size>>6)&0x01) + 1
+	}
+
+	// it's the second size byte
+	if size&0x3F == 0 {
+		return 64
+	}
+	return uint16(size & 0x3F)
 }
 
 func (obj *ZObject) GetFirstPropertySizeAddr() uint32 {
 	// returns the address of the size byte
+	propertiesPos := uint32(obj.PropertiesPos())
 
 	// text length is in words
-	textLength := obj.mem.ByteAt(uint32(obj.propertiesPos))
-	return uint32(obj.propertiesPos) + 1 + uint32(textLength)*2
+	textLength := obj.mem.ByteAt(propertiesPos)
+	return propertiesPos + 1 + uint32(textLength)*2
 }
 
 func (obj *ZObject) GetPropertyAddr(propertyId byte) uint32 {
-	// v3
 	addr := obj.GetFirstPropertySizeAddr()
 
 	for {
-		size := obj.mem.ByteAt(addr)
-
-		propno := size & 0x1F
+		propno, length, headerSize := obj.propertyHeader(addr)
 
-		if size == 0 || propno < propertyId {
+		if propno == 0 || propno < propertyId {
 			// must return 0 if property is not present
 			// properties are sorted in descending order
 			return 0
 		}
 
 		// skip size
-		addr++
+		addr += headerSize
 		if propno == propertyId {
 			return addr
 		}
 
-		addr += uint32((size >> 5) + 1)
+		addr += length
 	}
 }
 
-func (obj *ZObject) MakeOrphan(other []*ZObject) {
-	// TODO
-	// the same doubts of ZObject get/set properties apply here
-	// refer to the comment in zobject.go:SetProperty for
-	// better understanding
+func (obj *ZObject) MakeOrphan() {
+	parent := obj.relative(obj.ParentId())
 
-	if obj.parent != NULL_OBJECT_INDEX {
-		parent := other[obj.parent-1]
-		if parent.child == obj.number {
+	if parent != nil {
+		if parent.ChildId() == obj.number {
 			// obj is the first child so move to sibling
-			parent.child = obj.sibling
+			parent.setChild(obj.SiblingId())
 		} else {
-			// we are among the siblings so update previous one
-			curChildId := parent.child
-			prevChildId := NULL_OBJECT_INDEX
-
-			for curChildId != obj.number && curChildId != NULL_OBJECT_INDEX {
-				prevChildId = curChildId
-				curChildId = other[curChildId-1].sibling
+			// we are among the siblings so update previous one,
+			// don't loop forever on a corrupted tree
+			prev := obj.relative(parent.ChildId())
+			for i := uint16(0); prev != nil && i < obj.layout.maxObjects; i++ {
+				if prev.SiblingId() == obj.number {
+					prev.setSibling(obj.SiblingId())
+					break
+				}
+				prev = obj.relative(prev.SiblingId())
 			}
-			// TODO
-			// sanity checks
-
-			// update sibling to next one
-			other[prevChildId-1].sibling = obj.sibling
 		}
 	}
-	obj.parent = NULL_OBJECT_INDEX
+
+	obj.setParent(NULL_OBJECT_INDEX)
+	obj.setSibling(NULL_OBJECT_INDEX)
 }
 
-func (obj *ZObject) ChangeParent(newParentId uint8, other []*ZObject) error {
+func (obj *ZObject) ChangeParent(newParentId uint16) error {
 	if obj.number == newParentId {
 		return errors.New("trying to set object's parent to the object itself, not sure is allowed")
 	}
 
-	obj.MakeOrphan(other)
+	newParent := obj.relative(newParentId)
+	if newParent == nil {
+		return errors.New("cannot move an object into object 0")
+	}
+
+	obj.MakeOrphan()
 
 	// change object so that its sibling is the first child of parent
 	// set parent's child to objectId
 	// set child's parent to the newParent
-	other[obj.number-1].sibling = other[newParentId-1].child
-	other[newParentId-1].child = obj.number
-	other[obj.number-1].parent = newParentId
+	obj.setSibling(newParent.ChildId())
+	newParent.setChild(obj.number)
+	obj.setParent(newParentId)
 
 	return nil
 }
 
-func (obj *ZObject) NextProperty(prop byte) byte {
-	// props are sorted in descending order
+// NextProperty returns the number of the property following prop,
+// the first one if prop is 0 and 0 if prop is the last one
+func (obj *ZObject) NextProperty(prop byte) (byte, error) {
+	addr := obj.GetFirstPropertySizeAddr()
 
-	if prop == 0 {
-		max := byte(0)
-		for p, _ := range obj.properties {
-			if p > max {
-				max = p
-			}
+	if prop != 0 {
+		propAddr := obj.GetPropertyAddr(prop)
+		if propAddr == 0 {
+			return 0, fmt.Errorf("property %d of object %d not found", prop, obj.number)
 		}
-		return max
-	} else {
-		nextMaxProp := byte(0)
-		for p, _ := range obj.properties {
-			if p < prop && p > nextMaxProp {
-				nextMaxProp = p
-			}
-		}
-		return nextMaxProp
+		addr = propAddr + uint32(GetPropertyLen(obj.mem, propAddr, obj.header))
 	}
+
+	next, _, _ := obj.propertyHeader(addr)
+	return next, nil
 }
 
-func ZObjectAddress(idx uint8, header *ZHeader) (uint32, error) {
+func ZObjectAddress(idx uint16, header *ZHeader) (uint32, error) {
+	layout := objectLayout(header)
+
 	if idx < 1 {
-		return 0, errors.New("objects are numbered from 1 to 255")
+		return 0, fmt.Errorf("objects are numbered from 1 to %d", layout.maxObjects)
 	}
-	// v3 skip 31 words containing property default table
-	addr := uint32(header.objTblPos) + 31*2 + uint32(idx-1)*zobjectSize
+	// skip the words containing property default table
+	addr := uint32(header.objTblPos) + layout.defaultsCount*2 + uint32(idx-1)*layout.size
 	return addr, nil
 }
 
-func ZObjectId(address uint32, header *ZHeader) uint8 {
-	res := (address - uint32(header.objTblPos) - 31*2) / zobjectSize
-	return uint8(res) + 1
+func ZObjectId(address uint32, header *ZHeader) uint16 {
+	layout := objectLayout(header)
+	res := (address - uint32(header.objTblPos) - layout.defaultsCount*2) / layout.size
+	return uint16(res) + 1
 }
 
-func ZObjectsCount(mem *ZMemory, header *ZHeader) (uint8, error) {
-	count := uint8(0)
+func ZObjectsCount(mem *ZMemory, header *ZHeader) (uint16, error) {
+	layout := objectLayout(header)
+
+	// object #N+1 is counted too
+	count := uint32(0)
 	firstPropertyPos := uint32(0)
 
 	addr, err := ZObjectAddress(1, header)
@@ -299,9 +388,12 @@ func ZObjectsCount(mem *ZMemory, header *ZHeader) (uint8, error) {
 	seq := mem.GetSequential(addr)
 
 	doCount := func() error {
+		if count > uint32(layout.maxObjects) {
+			return errors.New("too many objects")
+		}
 		count++
 
-		addr, err := ZObjectAddress(count, header)
+		addr, err := ZObjectAddress(uint16(count), header)
 		if err != nil {
 			return err
 		}
@@ -309,7 +401,7 @@ func ZObjectsCount(mem *ZMemory, header *ZHeader) (uint8, error) {
 		seq.pos = addr
 
 		if firstPropertyPos == 0 || seq.pos < firstPropertyPos {
-			seq.pos += propertyOffset
+			seq.pos += layout.propertyOffset
 
 			propertyPos := uint32(seq.PeekWord())
 			if firstPropertyPos == 0 || propertyPos < firstPropertyPos {
@@ -320,7 +412,7 @@ func ZObjectsCount(mem *ZMemory, header *ZHeader) (uint8, error) {
 		return nil
 	}
 
-	// v3 objects tree
+	// objects tree
 	// object #1
 	// object #2
 	// ...
@@ -331,76 +423,84 @@ func ZObjectsCount(mem *ZMemory, header *ZHeader) (uint8, error) {
 		err = doCount()
 	}
 
-	// do not count object #0
-	return count - 1, err
+	// do not count object #N+1
+	return uint16(count - 1), err
 }
 
 func (obj *ZObject) PropertiesIds() []byte {
-	var keys []int
-	for k := range obj.properties {
-		keys = append(keys, int(k))
-	}
-	sort.Sort(sort.Reverse(sort.IntSlice(keys)))
-
-	ret := make([]byte, len(keys))
+	ret := []byte{}
 
-	for i, v := range keys {
-		ret[i] = byte(v)
+	// props are sorted in descending order
+	addr := obj.GetFirstPropertySizeAddr()
+	for {
+		propno, length, headerSize := obj.propertyHeader(addr)
+		if propno == 0 {
+			break
+		}
+		ret = append(ret, propno)
+		addr += headerSize + length
 	}
 
 	return ret
 }
 
-func (obj *ZObject) Id() uint8 {
+func (obj *ZObject) Id() uint16 {
 	return obj.number
 }
 
 func (obj *ZObject) Name() string {
-	return obj.name
+	propertiesPos := uint32(obj.PropertiesPos())
+
+	// number of words
+	if obj.mem.ByteAt(propertiesPos) == 0 {
+		return ""
+	}
+
+	return obj.mem.DecodeZStringAt(propertiesPos+1, obj.header)
 }
 
-func (obj *ZObject) ParentId() uint8 {
-	return obj.parent
+func (obj *ZObject) ParentId() uint16 {
+	return obj.relativeAt(obj.layout.parentOffset)
 }
 
-func (obj *ZObject) SiblingId() uint8 {
-	return obj.sibling
+func (obj *ZObject) SiblingId() uint16 {
+	return obj.relativeAt(obj.layout.siblingOffset)
 }
 
-func (obj *ZObject) ChildId() uint8 {
-	return obj.child
+func (obj *ZObject) ChildId() uint16 {
+	return obj.relativeAt(obj.layout.childOffset)
 }
 
 func (obj *ZObject) String() string {
 	ret := ""
 
-	ret += fmt.Sprintf("Attributes: ")
-	if len(obj.attributes) > 0 {
-		for i, attr := range obj.attributes {
-			if attr {
-				ret += fmt.Sprintf("%d, ", i)
-			}
+	attrs := []string{}
+	for i := uint16(0); i < obj.AttributesCount(); i++ {
+		if obj.Attribute(i) {
+			attrs = append(attrs, fmt.Sprint(i))
 		}
-		// do not include " ,"
-		ret = ret[:len(ret)-2]
-		ret += fmt.Sprintln("")
+	}
+
+	ret += fmt.Sprintf("Attributes: ")
+	if len(attrs) > 0 {
+		ret += fmt.Sprintln(strings.Join(attrs, ", "))
 	} else {
-		ret += fmt.Sprintln("None\n")
+		ret += fmt.Sprintln("None")
 	}
 
-	ret += fmt.Sprintf("     Parent object: %3d  ", obj.parent)
-	ret += fmt.Sprintf("Sibling object: %3d  ", obj.sibling)
-	ret += fmt.Sprintf("Child object: %3d\n", obj.child)
+	ret += fmt.Sprintf("     Parent object: %3d  ", obj.ParentId())
+	ret += fmt.Sprintf("Sibling object: %3d  ", obj.SiblingId())
+	ret += fmt.Sprintf("Child object: %3d\n", obj.ChildId())
 
-	ret += fmt.Sprintf("     Property address: %04x\n", obj.propertiesPos)
-	ret += fmt.Sprintf("         Description: \"%s\"\n", obj.name)
+	ret += fmt.Sprintf("     Property address: %04x\n", obj.PropertiesPos())
+	ret += fmt.Sprintf("         Description: \"%s\"\n", obj.Name())
 
 	ret += fmt.Sprintln("          Properties:")
 
 	for _, k := range obj.PropertiesIds() {
 		ret += fmt.Sprintf("              [%2d] ", k)
-		for b := range obj.properties[k] {
-			ret += fmt.Sprintf("%02X ", obj.properties[k][b])
+		for _, b := range obj.PropertyData(k) {
+			ret += fmt.Sprintf("%02X ", b)
 		}
 		ret += fmt.Sprintln("")
 	}
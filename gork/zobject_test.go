@@ -2,6 +2,7 @@ package gork
 
 import (
 	"encoding/binary"
+	"sort"
 	"testing"
 )
 
@@ -23,9 +24,29 @@ var zobjectProps [][]byte = [][]byte{
 const defaultPropByte byte = 0xFF
 const defaultPropWord uint16 = uint16(defaultPropByte)<<8 | uint16(defaultPropByte)
 
+type expectedZObject struct {
+	number        uint16
+	attributes    [32]bool
+	parent        uint16
+	sibling       uint16
+	child         uint16
+	name          string
+	propertiesPos uint16
+	properties    map[byte][]byte
+}
+
+func (obj *expectedZObject) PropertiesIds() []byte {
+	ret := []byte{}
+	for k := range obj.properties {
+		ret = append(ret, k)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] > ret[j] })
+	return ret
+}
+
 // TODO generate automatically propertiesPos
-var zobjectExpected []ZObject = []ZObject{
-	ZObject{
+var zobjectExpected []expectedZObject = []expectedZObject{
+	expectedZObject{
 		number:        1,
 		attributes:    genAttrs(14, 28),
 		parent:        0,
@@ -38,7 +59,7 @@ var zobjectExpected []ZObject = []ZObject{
 			16: []byte{0x82},
 		},
 	},
-	ZObject{
+	expectedZObject{
 		number:        2,
 		attributes:    genAttrs(7, 22, 23),
 		parent:        1,
@@ -51,7 +72,7 @@ var zobjectExpected []ZObject = []ZObject{
 			16: []byte{0x82, 0x21},
 		},
 	},
-	ZObject{
+	expectedZObject{
 		number:        3,
 		attributes:    genAttrs(7, 22, 23),
 		parent:        1,
@@ -85,7 +106,7 @@ func createZObjectBuf() []byte {
 		ret[i] = defaultPropByte
 	}
 
-	firstPropPos := uint16(len(ret)) + uint16(len(zobjectData))*uint16(zobjectSize)
+	firstPropPos := uint16(len(ret)) + uint16(len(zobjectData))*uint16(zobjectLayoutV3.size)
 
 	lastPropPos := firstPropPos
 	for i := range zobjectData {
@@ -103,8 +124,8 @@ func createZObjectBuf() []byte {
 	return ret
 }
 
-func prelude() (*ZMemory, *ZHeader, uint8) {
-	mem := ZMemory(createZObjectBuf())
+func prelude() (*ZMemory, *ZHeader, uint16) {
+	mem := *NewZMemory(createZObjectBuf())
 	header := &ZHeader{objTblPos: 0x00}
 
 	count, err := ZObjectsCount(&mem, header)
@@ -118,7 +139,7 @@ func prelude() (*ZMemory, *ZHeader, uint8) {
 func TestZObjectCount(t *testing.T) {
 	_, _, count := prelude()
 
-	if count != uint8(len(zobjectExpected)) {
+	if count != uint16(len(zobjectExpected)) {
 		t.Fail()
 	}
 }
@@ -126,7 +147,7 @@ func TestZObjectCount(t *testing.T) {
 func TestZObject(t *testing.T) {
 	mem, header, count := prelude()
 
-	for i := uint8(0); i < count; i++ {
+	for i := uint16(0); i < count; i++ {
 		obj, err := NewZObject(mem, i+1, header)
 		if err != nil {
 			t.Fail()
@@ -134,24 +155,29 @@ func TestZObject(t *testing.T) {
 
 		expected := zobjectExpected[i]
 
-		if obj.number != expected.number ||
-			obj.attributes != expected.attributes ||
-			obj.parent != expected.parent ||
-			obj.sibling != expected.sibling ||
-			obj.child != expected.child ||
-			obj.name != expected.name ||
-			obj.propertiesPos != expected.propertiesPos ||
+		if obj.Id() != expected.number ||
+			obj.ParentId() != expected.parent ||
+			obj.SiblingId() != expected.sibling ||
+			obj.ChildId() != expected.child ||
+			obj.Name() != expected.name ||
+			obj.PropertiesPos() != expected.propertiesPos ||
 			obj.mem != mem {
 			t.Fail()
 		}
 
-		if len(obj.properties) != len(expected.properties) {
+		for attr, set := range expected.attributes {
+			if obj.Attribute(uint16(attr)) != set {
+				t.Fail()
+			}
+		}
+
+		if len(obj.PropertiesIds()) != len(expected.properties) {
 			t.Fail()
 		}
 
 		for k, v := range expected.properties {
-			p, ok := obj.properties[k]
-			if !ok {
+			p := obj.PropertyData(k)
+			if p == nil {
 				t.Fail()
 			}
 
@@ -172,7 +198,7 @@ func TestZObject(t *testing.T) {
 func TestZObjectGetProperty(t *testing.T) {
 	mem, header, count := prelude()
 
-	for i := byte(0); i < count; i++ {
+	for i := uint16(0); i < count; i++ {
 		obj, err := NewZObject(mem, i+1, header)
 		if err != nil {
 			t.Fail()
@@ -199,7 +225,7 @@ func TestZObjectGetProperty(t *testing.T) {
 		}
 
 		// should return default property
-		if _, ok := obj.properties[31]; !ok {
+		if obj.PropertyData(31) == nil {
 			p, err := obj.GetProperty(31)
 
 			if err != nil && p != defaultPropWord {
@@ -212,7 +238,7 @@ func TestZObjectGetProperty(t *testing.T) {
 func TestZObjectSetProperty(t *testing.T) {
 	mem, header, count := prelude()
 
-	for i := byte(0); i < count; i++ {
+	for i := uint16(0); i < count; i++ {
 		obj, err := NewZObject(mem, i+1, header)
 		if err != nil {
 			t.Fail()
@@ -220,7 +246,8 @@ func TestZObjectSetProperty(t *testing.T) {
 
 		var expected uint16
 
-		for id, prop := range obj.properties {
+		for _, id := range obj.PropertiesIds() {
+			prop := obj.PropertyData(id)
 			ok := true
 			switch len(prop) {
 			case 1:
@@ -244,13 +271,13 @@ func TestZObjectSetProperty(t *testing.T) {
 func TestZObjectPropertyLen(t *testing.T) {
 	mem, header, count := prelude()
 
-	for i := byte(0); i < count; i++ {
+	for i := uint16(0); i < count; i++ {
 		obj, err := NewZObject(mem, i+1, header)
 		if err != nil {
 			t.Fail()
 		}
 
-		seq := mem.GetSequential(uint32(obj.propertiesPos))
+		seq := mem.GetSequential(uint32(obj.PropertiesPos()))
 		if seq.ReadByte() != 0 {
 			// skip name
 			seq.DecodeZString(header)
@@ -261,9 +288,9 @@ func TestZObjectPropertyLen(t *testing.T) {
 		propertyPos := uint16(seq.pos)
 
 		for _, k := range obj.PropertiesIds() {
-			prop := obj.properties[k]
+			prop := obj.PropertyData(k)
 
-			if GetPropertyLen(mem, uint32(propertyPos)) != uint16(len(prop)) {
+			if GetPropertyLen(mem, uint32(propertyPos), header) != uint16(len(prop)) {
 				t.Fail()
 			}
 
@@ -276,13 +303,13 @@ func TestZObjectPropertyLen(t *testing.T) {
 func TestZObjectGetFirstPropertyAddr(t *testing.T) {
 	mem, header, count := prelude()
 
-	for i := byte(0); i < count; i++ {
+	for i := uint16(0); i < count; i++ {
 		obj, err := NewZObject(mem, i+1, header)
 		if err != nil {
 			t.Fail()
 		}
 
-		seq := mem.GetSequential(uint32(obj.propertiesPos))
+		seq := mem.GetSequential(uint32(obj.PropertiesPos()))
 
 		if seq.ReadByte() != 0 {
 			// skip name
@@ -298,13 +325,13 @@ func TestZObjectGetFirstPropertyAddr(t *testing.T) {
 func TestZObjectGetPropertyAddr(t *testing.T) {
 	mem, header, count := prelude()
 
-	for i := byte(0); i < count; i++ {
+	for i := uint16(0); i < count; i++ {
 		obj, err := NewZObject(mem, i+1, header)
 		if err != nil {
 			t.Fail()
 		}
 
-		seq := mem.GetSequential(uint32(obj.propertiesPos))
+		seq := mem.GetSequential(uint32(obj.PropertiesPos()))
 
 		if seq.ReadByte() != 0 {
 			// skip name
@@ -346,7 +373,7 @@ func TestZObjectGetPropertyAddr(t *testing.T) {
 func TestZObjectNextProperty(t *testing.T) {
 	mem, header, count := prelude()
 
-	for i := byte(0); i < count; i++ {
+	for i := uint16(0); i < count; i++ {
 		obj, err := NewZObject(mem, i+1, header)
 		if err != nil {
 			t.Fail()
@@ -354,27 +381,125 @@ func TestZObjectNextProperty(t *testing.T) {
 
 		exp := zobjectExpected[i]
 
-		prop := obj.NextProperty(0)
+		prop, err := obj.NextProperty(0)
 		for _, p := range exp.PropertiesIds() {
-			if prop != p {
+			if err != nil || prop != p {
 				t.Fail()
 			}
-			prop = obj.NextProperty(prop)
+			prop, err = obj.NextProperty(prop)
 		}
 
-		if prop != 0 {
+		if err != nil || prop != 0 {
+			t.Fail()
+		}
+
+		// missing properties have no next property
+		if _, err := obj.NextProperty(1); err == nil {
 			t.Fail()
 		}
 	}
 }
 
 func TestZObjectId(t *testing.T) {
-	header := &ZHeader{objTblPos: 0}
-	for i := byte(0); i < 255; i++ {
-		addr, err := ZObjectAddress(i+1, header)
+	for _, version := range []byte{3, 4} {
+		header := &ZHeader{objTblPos: 0, version: version}
+		for i := uint16(0); i < 255; i++ {
+			addr, err := ZObjectAddress(i+1, header)
 
-		if err != nil || ZObjectId(addr, header) != i+1 {
-			t.Fail()
+			if err != nil || ZObjectId(addr, header) != i+1 {
+				t.Fail()
+			}
 		}
 	}
 }
+
+func TestZObjectWriteThrough(t *testing.T) {
+	mem, header, _ := prelude()
+
+	obj, _ := NewZObject(mem, 3, header)
+	addr, _ := ZObjectAddress(3, header)
+
+	obj.SetAttribute(0, true)
+	obj.SetAttribute(7, false)
+	if mem.ByteAt(addr) != 0x80 || !obj.Attribute(0) || obj.Attribute(7) {
+		t.Fail()
+	}
+
+	// object 3 claims 1 as parent without being among its children,
+	// moving it must leave 1 untouched
+	if err := obj.ChangeParent(2); err != nil {
+		t.Fail()
+	}
+
+	parent, _ := NewZObject(mem, 1, header)
+	newParent, _ := NewZObject(mem, 2, header)
+
+	if parent.ChildId() != 2 || newParent.ChildId() != 3 ||
+		obj.ParentId() != 2 || obj.SiblingId() != 0 ||
+		mem.ByteAt(addr+zobjectLayoutV3.parentOffset) != 2 {
+		t.Fail()
+	}
+
+	if err := obj.SetProperty(18, 0x4242); err == nil {
+		t.Fail()
+	}
+
+	if err := newParent.SetProperty(16, 0x1234); err != nil ||
+		mem.WordAt(newParent.GetPropertyAddr(16)) != 0x1234 {
+		t.Fail()
+	}
+}
+
+func TestZObjectV4(t *testing.T) {
+	header := &ZHeader{objTblPos: 0, version: 4}
+
+	// 63 default properties followed by a single object
+	buf := make([]byte, 63*2)
+	propPos := len(buf) + int(zobjectLayoutV4.size)
+
+	buf = append(buf,
+		// attributes 0, 47
+		0x80, 0x00, 0x00, 0x00, 0x00, 0x01,
+		// parent, sibling, child
+		0x00, 0x00, 0x01, 0x2C, 0x00, 0x00,
+		byte(propPos>>8), byte(propPos))
+
+	buf = append(buf,
+		// no name
+		0x00,
+		// property 20, 5 bytes with a two bytes header
+		0x94, 0x85, 1, 2, 3, 4, 5,
+		// property 10, 2 bytes
+		0x4A, 0xAB, 0xCD,
+		// property 3, 1 byte
+		0x03, 0xEF,
+		0x00)
+
+	mem := *NewZMemory(buf)
+
+	obj, err := NewZObject(&mem, 1, header)
+	if err != nil {
+		t.FailNow()
+	}
+
+	if !obj.Attribute(0) || !obj.Attribute(47) || obj.Attribute(1) ||
+		obj.AttributesCount() != 48 || obj.SiblingId() != 300 {
+		t.Fail()
+	}
+
+	if len(obj.PropertyData(20)) != 5 || GetPropertyLen(&mem, obj.GetPropertyAddr(20), header) != 5 {
+		t.Fail()
+	}
+
+	if p, err := obj.GetProperty(10); err != nil || p != 0xABCD {
+		t.Fail()
+	}
+
+	if p, err := obj.GetProperty(3); err != nil || p != 0xEF {
+		t.Fail()
+	}
+
+	if next, err := obj.NextProperty(20); err != nil || next != 10 {
+		t.Fail()
+	}
+}
@@ -3,9 +3,6 @@ package gork
 import (
 	"errors"
 	"fmt"
-	"reflect"
-	"runtime"
-	"strings"
 )
 
 const (
@@ -21,31 +18,203 @@ const (
 	ONEOP  = byte(0x01)
 	TWOOP  = byte(0x02)
 	VAROP  = byte(0x03)
-	// v3 ignore EXTENDED OPERAND
+	// v5 opcodes following the 0xBE prefix
+	EXTOP = byte(0x04)
 )
 
 type ZOp struct {
-	zm       *ZMachine
+	// nil when the instruction is decoded without executing it
+	zm     *ZMachine
+	seq    *ZMemorySequential
+	header *ZHeader
+	// address of the instruction and of the following one
+	pos      uint32
+	next     uint32
 	opcode   byte
 	class    byte
 	optypes  []byte
 	operands []uint16 // actually not all operands are large constants
 	name     string
+	// nil if the opcode doesn't exist in the version of the story
+	info *ZOpInfo
+
+	// address of the store variable, or of the branch data,
+	// saves resume from there
+	resultPos uint32
+	store     byte
+	// branch when the condition is branchOnTrue, offsets 0 and 1
+	// return false and true
+	branchOnTrue bool
+	branchOffset int32
+	text         string
+	// operands popped from the stack, in the order they were read
+	popped []uint16
+}
+
+// ZOpInfo describes an opcode of the versions from MinVersion to MaxVersion
+type ZOpInfo struct {
+	Name       string
+	Class      byte
+	Number     byte
+	MinVersion byte
+	MaxVersion byte
+	// operands it needs at least
+	Operands int
+	// followed by a store variable, branch data and an encoded string
+	Store  bool
+	Branch bool
+	Text   bool
+	// execution doesn't go on with the following instruction
+	Stops bool
+	// the first operand is the packed address of a routine
+	Calls bool
+	// one of ZeroOpFunc, OneOpFunc, TwoOpFunc and VarOpFunc,
+	// nil if gork doesn't implement the opcode
+	handler interface{}
+}
+
+type zopKey struct {
+	version byte
+	class   byte
+	number  byte
+}
+
+var zopIndex map[zopKey]*ZOpInfo
+
+func newZOpInfo(class byte, number byte, name string, operands int, handler interface{}) ZOpInfo {
+	return ZOpInfo{
+		Name:       name,
+		Class:      class,
+		Number:     number,
+		MinVersion: 1,
+		MaxVersion: maxVersion,
+		Operands:   operands,
+		handler:    handler,
+	}
+}
+
+func zeroOp(number byte, name string, fn ZeroOpFunc) ZOpInfo {
+	return newZOpInfo(ZEROOP, number, name, 0, fn)
+}
+
+func oneOp(number byte, name string, fn OneOpFunc) ZOpInfo {
+	return newZOpInfo(ONEOP, number, name, 1, fn)
+}
+
+func twoOp(number byte, name string, fn TwoOpFunc) ZOpInfo {
+	return newZOpInfo(TWOOP, number, name, 2, fn)
+}
+
+func varOp(number byte, name string, fn VarOpFunc) ZOpInfo {
+	return newZOpInfo(VAROP, number, name, 0, fn)
+}
+
+// ext opcodes exist from v5 on
+func extOp(number byte, name string, fn VarOpFunc) ZOpInfo {
+	return newZOpInfo(EXTOP, number, name, 0, fn).since(5)
+}
+
+// missingOp describes an opcode of the standard gork doesn't implement,
+// instructions using it can be decoded but not executed
+func missingOp(class byte, number byte, name string) ZOpInfo {
+	return newZOpInfo(class, number, name, 0, nil)
+}
+
+// Implemented tells if gork can execute the opcode
+func (info *ZOpInfo) Implemented() bool {
+	return info.handler != nil
+}
+
+func (info ZOpInfo) stores() ZOpInfo {
+	info.Store = true
+	return info
+}
+
+func (info ZOpInfo) branches() ZOpInfo {
+	info.Branch = true
+	return info
+}
+
+func (info ZOpInfo) withText() ZOpInfo {
+	info.Text = true
+	return info
+}
+
+func (info ZOpInfo) stops() ZOpInfo {
+	info.Stops = true
+	return info
+}
+
+func (info ZOpInfo) calls() ZOpInfo {
+	info.Calls = true
+	return info
+}
+
+func (info ZOpInfo) needs(operands int) ZOpInfo {
+	info.Operands = operands
+	return info
+}
+
+func (info ZOpInfo) since(version byte) ZOpInfo {
+	info.MinVersion = version
+	return info
+}
 
-	// optional values are not read,
-	// the actual implementation of the functions will read them
-	// possible values are:
-	// - store  	byte
-	// - branch(es) word
-	// - text   	zstring
+func (info ZOpInfo) until(version byte) ZOpInfo {
+	info.MaxVersion = version
+	return info
 }
 
+func indexZOpInfos(infos []ZOpInfo) map[zopKey]*ZOpInfo {
+	index := map[zopKey]*ZOpInfo{}
+	for i := range infos {
+		info := &infos[i]
+		for v := info.MinVersion; v <= info.MaxVersion; v++ {
+			index[zopKey{v, info.Class, info.Number}] = info
+		}
+	}
+	return index
+}
+
+// LookupZOp returns the opcode number of class in the given version
+// of the story, nil if there is no such opcode
+func LookupZOp(version byte, class byte, number byte) *ZOpInfo {
+	return zopIndex[zopKey{version, class, number}]
+}
+
+// ZOpInfos returns every opcode gork knows, see Implemented
+func ZOpInfos() []ZOpInfo {
+	return append([]ZOpInfo{}, zopInfos...)
+}
+
+// NewZOp decodes the instruction at the PC of zm and moves past it,
+// variable operands are read, popping the stack
 func NewZOp(zm *ZMachine) (*ZOp, error) {
-	zop := new(ZOp)
+	return decodeZOp(zm.seq, zm.header, zm)
+}
 
-	zop.zm = zm
+// DecodeZOp decodes the instruction at addr without executing it,
+// variable operands are the numbers of the variables
+func DecodeZOp(mem *ZMemory, header *ZHeader, addr uint32) (zop *ZOp, err error) {
+	// reading past the end of the story panics
+	defer func() {
+		if r := recover(); r != nil {
+			if err, _ = r.(error); err == nil {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+
+	return decodeZOp(mem.GetSequential(addr), header, nil)
+}
 
-	opcode := zm.seq.ReadByte()
+func decodeZOp(seq *ZMemorySequential, header *ZHeader, zm *ZMachine) (*ZOp, error) {
+	zop := &ZOp{zm: zm, seq: seq, header: header, pos: seq.pos}
+	defer func() {
+		zop.next = seq.pos
+	}()
+
+	opcode := seq.ReadByte()
 
 	if opcode < 0x80 {
 		zop.class = TWOOP
@@ -58,19 +227,73 @@ func NewZOp(zm *ZMachine) (*ZOp, error) {
 	}
 
 	var err error = nil
-	switch opcode >> 6 {
-	case 0x03:
+	switch {
+	case opcode == 0xBE && zop.version() >= 5:
+		err = zop.configureExt()
+	case opcode>>6 == 0x03:
 		err = zop.configureVar(opcode)
-	case 0x02:
+	case opcode>>6 == 0x02:
 		zop.configureShort(opcode)
 	default:
 		zop.configureLong(opcode)
-		// v3 ignore EXTENDED
 	}
 
-	zop.name = zop.getOpName()
+	if err != nil {
+		return zop, err
+	}
+
+	zop.info = LookupZOp(zop.version(), zop.class, zop.opcode)
+	if zop.info == nil {
+		zop.name = "unknown opcode"
+		return zop, nil
+	}
+
+	zop.name = zop.info.Name
+	zop.readResult(seq)
+	if zop.info.Text {
+		zop.text = seq.DecodeZString(header)
+	}
 
-	return zop, err
+	return zop, nil
+}
+
+// readResult decodes the store variable and the branch data at seq,
+// restores decode them again at the PC of the save instruction
+func (zop *ZOp) readResult(seq *ZMemorySequential) {
+	zop.resultPos = seq.pos
+
+	if zop.info.Store {
+		zop.store = seq.ReadByte()
+	}
+
+	if !zop.info.Branch {
+		return
+	}
+
+	info := seq.ReadByte()
+
+	// if bit #7 is set than branch on true
+	zop.branchOnTrue = (info >> 7) != 0x00
+
+	// if bit #6 is set than the offset is stored in the bottom
+	// 6 bits
+	if info&0x40 != 0x00 {
+		zop.branchOffset = int32(info & 0x3F)
+		return
+	}
+
+	// if bit #6 is clear than the offset is store in a 14 bit signed
+	// integer composed by the bottom 5 bits of info and 8 bits of an
+	// additional byte
+	firstPart := uint16(info & 0x3F)
+
+	// if sign bit(#6) is set then it's a negative number
+	// in two complement form, so set the bits #6 and #7 too
+	if firstPart&0x20 != 0x00 {
+		firstPart |= 0x3 << 6
+	}
+
+	zop.branchOffset = int32(int16(firstPart<<8) | int16(seq.ReadByte()))
 }
 
 func (zop *ZOp) configureVar(op byte) error {
@@ -84,16 +307,51 @@ func (zop *ZOp) configureVar(op byte) error {
 		zop.class = VAROP
 	}
 
-	// types are stored in an additional byte
+	// v4 call_vs2 and v5 call_vn2 have a second byte
+	// of types for up to 8 operands
+	typesCount := 1
+	if zop.class == VAROP && (zop.opcode == 0x0C || zop.opcode == 0x1A) {
+		typesCount = 2
+	}
+
+	if err := zop.readVarOperands(typesCount); err != nil {
+		return err
+	}
+
+	// following seems reasonable, but in practice it's useless
+	// because for instance ZJe is TWOOP but it actually accepts
+	// 3 args
+	// if zop.class == TWOOP && len(zop.optypes) != 2 {
+	// 	log.Fatalf("PC: %d 2op %d in var form does not have 2 ops %v\n",
+	// 		zop.zm.seq.pos, zop.opcode, zop.operands)
+	// }
+	return nil
+}
+
+func (zop *ZOp) configureExt() error {
+	zop.class = EXTOP
+
+	// opcode is stored in the byte following the prefix
+	zop.opcode = zop.seq.ReadByte()
+
+	return zop.readVarOperands(1)
+}
+
+// readVarOperands reads the operands of VAR and EXT instructions
+func (zop *ZOp) readVarOperands(typesCount int) error {
+	// types are stored in additional bytes
 	// 2 bits per type
 	// bits #7 #6 are first operand's type
 	// bits #1 #0 are last operand's type
-	types := zop.zm.seq.ReadByte()
+	types := uint16(0)
+	for j := 0; j < typesCount; j++ {
+		types = types<<8 | uint16(zop.seq.ReadByte())
+	}
 
-	i := 6
+	i := 8*typesCount - 2
 
 	for ; i >= 0; i -= 2 {
-		ty := (types >> byte(i)) & 0x03
+		ty := byte(types>>uint(i)) & 0x03
 		if ty == OMMITTED_CONSTANT {
 			break
 		}
@@ -102,18 +360,11 @@ func (zop *ZOp) configureVar(op byte) error {
 	}
 
 	for ; i >= 0; i -= 2 {
-		if (types>>byte(i))&0x03 != OMMITTED_CONSTANT {
+		if byte(types>>uint(i))&0x03 != OMMITTED_CONSTANT {
 			return errors.New("non omitted type after omitted one!")
 		}
 	}
 
-	// following seems reasonable, but in practice it's useless
-	// because for instance ZJe is TWOOP but it actually accepts
-	// 3 args
-	// if zop.class == TWOOP && len(zop.optypes) != 2 {
-	// 	log.Fatalf("PC: %d 2op %d in var form does not have 2 ops %v\n",
-	// 		zop.zm.seq.pos, zop.opcode, zop.operands)
-	// }
 	return nil
 }
 
@@ -157,80 +408,131 @@ func (zop *ZOp) configureLong(op byte) {
 
 func (zop *ZOp) readOpType(optype byte) uint16 {
 	if optype == LARGE_CONSTANT {
-		return zop.zm.seq.ReadWord()
+		return zop.seq.ReadWord()
 	} else if optype == VARIABLE_CONSTANT {
-		tmp := zop.zm.GetVarAt(zop.zm.seq.ReadByte())
-		return tmp
+		varnum := zop.seq.ReadByte()
+		if zop.zm == nil {
+			return uint16(varnum)
+		}
+
+		depth := len(zop.zm.stack.Top().stack)
+		value := zop.zm.GetVarAt(varnum)
+		if len(zop.zm.stack.Top().stack) < depth {
+			zop.popped = append(zop.popped, value)
+		}
+		return value
 	} else {
-		return uint16(zop.zm.seq.ReadByte())
+		return uint16(zop.seq.ReadByte())
 	}
 }
 
-func (zop *ZOp) getOpName() string {
-	var fn interface{} = nil
-
-	switch zop.class {
-	case ZEROOP:
-		if int(zop.opcode) < len(zeroOpFuncs) {
-			fn = zeroOpFuncs[zop.opcode]
-		}
-	case ONEOP:
-		if int(zop.opcode) < len(oneOpFuncs) {
-			fn = oneOpFuncs[zop.opcode]
-		}
-	case TWOOP:
-		if zop.opcode == 1 {
-			// ZJe is a two op func but it accepts VAR count of args,
-			// so we must handle separetly
-			return "ZJe"
-		} else if int(zop.opcode) < len(twoOpFuncs) {
-			fn = twoOpFuncs[zop.opcode]
-		}
-	case VAROP:
-		if int(zop.opcode) < len(varOpFuncs) {
-			fn = varOpFuncs[zop.opcode]
-		}
+// version is the version of the story the instruction belongs to
+func (zop *ZOp) version() byte {
+	if zop.header == nil {
+		return 0
 	}
-	return getFuncName(fn, "unknown opcode name")
+	return zop.header.version
 }
 
-func getFuncName(fn interface{}, errFnName string) string {
-	value := reflect.ValueOf(fn)
+// Name is the name of the opcode as the standard calls it
+func (zop *ZOp) Name() string {
+	return zop.name
+}
 
-	if !value.IsValid() {
-		return errFnName
-	}
+// Info describes the opcode, it's nil if it doesn't exist
+func (zop *ZOp) Info() *ZOpInfo {
+	return zop.info
+}
 
-	completeName := runtime.FuncForPC(value.Pointer()).Name()
+// Class is one of ZEROOP, ONEOP, TWOOP, VAROP and EXTOP
+func (zop *ZOp) Class() byte {
+	return zop.class
+}
 
-	if completeName == "" {
-		return errFnName
-	}
+func (zop *ZOp) Opcode() byte {
+	return zop.opcode
+}
 
-	// keep only function name
-	parts := strings.Split(completeName, "/")
-	return strings.Split(parts[len(parts)-1], ".")[1]
+// Pos is the address of the instruction
+func (zop *ZOp) Pos() uint32 {
+	return zop.pos
 }
 
-func (zop *ZOp) String() string {
-	// not properly formatted
+// Next is the address of the instruction following it
+func (zop *ZOp) Next() uint32 {
+	return zop.next
+}
 
-	ret := ""
+// Operands returns the values of the operands, variables are their
+// numbers unless the instruction has been decoded by a ZMachine
+func (zop *ZOp) Operands() []uint16 {
+	return zop.operands
+}
 
-	ret += fmt.Sprintln("  Op:", zop.name)
-	ret += fmt.Sprintf("  Opcode: %d ", zop.opcode)
+// OperandTypes returns LARGE_CONSTANT, SMALL_CONSTANT or
+// VARIABLE_CONSTANT for each operand
+func (zop *ZOp) OperandTypes() []byte {
+	return zop.optypes
+}
+
+// Store returns the variable the result is stored to,
+// ok is false if the opcode doesn't store
+func (zop *ZOp) Store() (varnum byte, ok bool) {
+	return zop.store, zop.info != nil && zop.info.Store
+}
+
+// Branch returns when the instruction branches and the offset,
+// ok is false if the opcode doesn't branch
+func (zop *ZOp) Branch() (onTrue bool, offset int32, ok bool) {
+	return zop.branchOnTrue, zop.branchOffset, zop.info != nil && zop.info.Branch
+}
+
+// BranchTarget returns the address the instruction branches to,
+// offsets 0 and 1 return from the routine instead
+func (zop *ZOp) BranchTarget() uint32 {
+	// relative to the address after the branch data
+	return uint32(int64(zop.next) + int64(zop.branchOffset) - 2)
+}
 
-	switch zop.class {
+// Text is the string following print and print_ret
+func (zop *ZOp) Text() string {
+	return zop.text
+}
+
+// handler returns the function implementing the instruction, it's one
+// of ZeroOpFunc, OneOpFunc, TwoOpFunc and VarOpFunc or nil if there is none
+func (zop *ZOp) handler() interface{} {
+	if zop.info == nil {
+		return nil
+	}
+	return zop.info.handler
+}
+
+// ClassName returns the name of the opcode class as in the standard,
+// that is 0OP, 1OP, 2OP, VAR or EXT
+func ClassName(class byte) string {
+	switch class {
 	case ZEROOP:
-		ret += "0OP"
+		return "0OP"
 	case ONEOP:
-		ret += "1OP"
+		return "1OP"
 	case TWOOP:
-		ret += "2OP"
+		return "2OP"
 	case VAROP:
-		ret += "VAR"
+		return "VAR"
+	case EXTOP:
+		return "EXT"
 	}
-	ret += "\n"
+	return ""
+}
+
+func (zop *ZOp) String() string {
+	// not properly formatted
+
+	ret := ""
+
+	ret += fmt.Sprintln("  Op:", zop.name)
+	ret += fmt.Sprintf("  Opcode: %d %s\n", zop.opcode, ClassName(zop.class))
 
 	ret += fmt.Sprintln("  Operands:")
 
@@ -253,5 +555,15 @@ func (zop *ZOp) String() string {
 		ret += "\n"
 	}
 
+	if zop.info != nil && zop.info.Store {
+		ret += fmt.Sprintf("  Store: %X\n", zop.store)
+	}
+	if zop.info != nil && zop.info.Branch {
+		ret += fmt.Sprintf("  Branch: %t %d\n", zop.branchOnTrue, zop.branchOffset)
+	}
+	if zop.info != nil && zop.info.Text {
+		ret += fmt.Sprintf("  Text: %q\n", zop.text)
+	}
+
 	return ret
 }
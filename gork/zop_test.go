@@ -5,8 +5,9 @@ import "testing"
 // TODO test VARIABLE_CONSTANT
 
 var zopBuf [][]byte = [][]byte{
+	// call 2A39 8010 FFFF -> sp
 	[]byte{
-		0xE0, 0x3, 0x2A, 0x39, 0x80, 0x10, 0xFF, 0xFF,
+		0xE0, 0x3, 0x2A, 0x39, 0x80, 0x10, 0xFF, 0xFF, 0x00,
 	},
 	[]byte{
 		0x8C, 0xFF, 0xD7,
@@ -14,8 +15,13 @@ var zopBuf [][]byte = [][]byte{
 	[]byte{
 		0x0D, 0x10, 0xB4,
 	},
+	// print "hello"
 	[]byte{
-		0xB2,
+		0xB2, 0x35, 0x51, 0xC6, 0x85,
+	},
+	// call_vs2 with 5 operands -> g00
+	[]byte{
+		0xEC, 0x15, 0x7F, 0x12, 0x34, 0x01, 0x02, 0x03, 0x04, 0x10,
 	},
 }
 
@@ -31,7 +37,8 @@ var zopExpected []ZOp = []ZOp{
 		operands: []uint16{
 			0x2A39, 0x8010, 0xFFFF,
 		},
-		name: "ZCall",
+		name:  "call_vs",
+		store: 0x00,
 	},
 	ZOp{
 		opcode: 12,
@@ -42,7 +49,7 @@ var zopExpected []ZOp = []ZOp{
 		operands: []uint16{
 			0xFFD7,
 		},
-		name: "ZJump",
+		name: "jump",
 	},
 	ZOp{
 		opcode: 13,
@@ -54,23 +61,40 @@ var zopExpected []ZOp = []ZOp{
 		operands: []uint16{
 			0x10, 0xB4,
 		},
-		name: "ZStore",
+		name: "store",
 	},
 	ZOp{
 		opcode:   2,
 		class:    ZEROOP,
 		optypes:  []byte{},
 		operands: []uint16{},
-		name:     "ZPrint",
+		name:     "print",
+		text:     "hello",
+	},
+	ZOp{
+		opcode: 12,
+		class:  VAROP,
+		optypes: []byte{
+			LARGE_CONSTANT,
+			SMALL_CONSTANT,
+			SMALL_CONSTANT,
+			SMALL_CONSTANT,
+			SMALL_CONSTANT,
+		},
+		operands: []uint16{
+			0x1234, 0x01, 0x02, 0x03, 0x04,
+		},
+		name:  "call_vs2",
+		store: 0x10,
 	},
 }
 
 func TestZOP(t *testing.T) {
 	for i, mem := range zopBuf {
 
-		zmem := ZMemory(mem)
+		zmem := *NewZMemory(mem)
 		zmachine := &ZMachine{
-			header: &ZHeader{},
+			header: &ZHeader{version: 4},
 			seq:    zmem.GetSequential(0),
 		}
 
@@ -82,7 +106,13 @@ func TestZOP(t *testing.T) {
 		expected := zopExpected[i]
 
 		if zop.opcode != expected.opcode || zop.class != expected.class ||
-			zop.name != expected.name {
+			zop.name != expected.name || len(zop.operands) != len(expected.operands) ||
+			zop.store != expected.store || zop.text != expected.text {
+			t.Fail()
+		}
+
+		// the whole instruction has been read
+		if zmachine.seq.pos != uint32(len(mem)) {
 			t.Fail()
 		}
 
@@ -100,11 +130,103 @@ func TestZOP(t *testing.T) {
 	}
 }
 
-func TestGetOpName(t *testing.T) {
-	// must not crash
-	(&ZOp{class: TWOOP, opcode: 100}).getOpName()
-	(&ZOp{class: ONEOP, opcode: 99}).getOpName()
-	(&ZOp{class: TWOOP, opcode: 1}).getOpName()
-	(&ZOp{class: TWOOP, opcode: 4}).getOpName()
-	(&ZOp{class: ZEROOP, opcode: 99}).getOpName()
+func TestLookupZOp(t *testing.T) {
+	for _, test := range []struct {
+		version byte
+		class   byte
+		number  byte
+		name    string
+	}{
+		{3, ZEROOP, 0x05, "save"},
+		{5, ZEROOP, 0x05, ""},
+		{3, ZEROOP, 0x09, "pop"},
+		{5, ZEROOP, 0x09, "catch"},
+		{3, TWOOP, 0x00, ""},
+		{3, TWOOP, 0x19, ""},
+		{4, TWOOP, 0x19, "call_2s"},
+		{3, VAROP, 0x04, "sread"},
+		{5, VAROP, 0x04, "aread"},
+		{4, EXTOP, 0x02, ""},
+		{8, EXTOP, 0x02, "log_shift"},
+		{3, ONEOP, 99, ""},
+	} {
+		info := LookupZOp(test.version, test.class, test.number)
+		if (info == nil) != (test.name == "") || info != nil && info.Name != test.name {
+			t.Fail()
+		}
+	}
+
+	// v3 save branches, v4 stores
+	if !LookupZOp(3, ZEROOP, 0x05).Branch || !LookupZOp(4, ZEROOP, 0x05).Store {
+		t.Fail()
+	}
+}
+
+func TestZOPBranch(t *testing.T) {
+	for _, test := range []struct {
+		code   []byte
+		onTrue bool
+		offset int32
+	}{
+		// jz 0 ?~(+5)
+		{[]byte{0x90, 0x00, 0x45}, false, 5},
+		// jz 0 ?rtrue
+		{[]byte{0x90, 0x00, 0xC1}, true, 1},
+		// jz 0 ?(-2)
+		{[]byte{0x90, 0x00, 0xBF, 0xFE}, true, -2},
+		// jz 0 ?~(+300)
+		{[]byte{0x90, 0x00, 0x01, 0x2C}, false, 300},
+	} {
+		zmem := NewZMemory(test.code)
+		zmachine := &ZMachine{
+			header: &ZHeader{version: 3},
+			seq:    zmem.GetSequential(0),
+		}
+
+		zop, err := NewZOp(zmachine)
+		if err != nil || zop.name != "jz" || zop.resultPos != 2 ||
+			zop.branchOnTrue != test.onTrue || zop.branchOffset != test.offset ||
+			zmachine.seq.pos != uint32(len(test.code)) {
+			t.Fail()
+		}
+	}
+}
+
+func TestZOPExtended(t *testing.T) {
+	// log_shift 1 -2 -> sp
+	zmem := *NewZMemory([]byte{0xBE, 0x02, 0x5F, 0x01, 0xFE, 0x00})
+
+	zmachine := &ZMachine{
+		header: &ZHeader{version: 5},
+		seq:    zmem.GetSequential(0),
+	}
+
+	zop, err := NewZOp(zmachine)
+	if err != nil || zop.class != EXTOP || zop.opcode != 2 || zop.name != "log_shift" ||
+		len(zop.operands) != 2 || zop.operands[1] != 0xFE || zop.store != 0 || zmachine.seq.pos != 6 {
+		t.Fail()
+	}
+
+	// v3 there is no extended opcode
+	zmachine = &ZMachine{
+		header: &ZHeader{version: 3},
+		seq:    zmem.GetSequential(0),
+	}
+
+	zop, _ = NewZOp(zmachine)
+	if zop.class != ZEROOP || zop.handler() != nil {
+		t.Fail()
+	}
+}
+
+func TestZOPVersionedHandler(t *testing.T) {
+	zop := &ZOp{class: ONEOP, opcode: 0x0F, info: LookupZOp(3, ONEOP, 0x0F)}
+	if _, ok := zop.handler().(OneOpFunc); !ok || zop.info.Name != "not" {
+		t.Fail()
+	}
+
+	zop.info = LookupZOp(5, ONEOP, 0x0F)
+	if _, ok := zop.handler().(OneOpFunc); !ok || zop.info.Name != "call_1n" {
+		t.Fail()
+	}
 }
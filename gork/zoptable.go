@@ -1,10 +1,9 @@
 package gork
 
 import (
-	"fmt"
-	"math/rand"
+	"io/ioutil"
 	"strings"
-	"time"
+	"unicode"
 )
 
 type ZeroOpFunc func(*ZMachine)
@@ -12,108 +11,208 @@ type OneOpFunc func(*ZMachine, uint16)
 type TwoOpFunc func(*ZMachine, uint16, uint16)
 type VarOpFunc func(*ZMachine, []uint16)
 
-var zeroOpFuncs = []ZeroOpFunc{
-	ZReturnTrue,
-	ZReturnFalse,
-	ZPrint,
-	ZPrintRet,
-	nil,
-	nil,
-	nil,
-	nil,
-	ZRetPop,
-	nil,
-	nil,
-	ZNl,
-}
-
-var oneOpFuncs = []OneOpFunc{
-	ZJ0,
-	ZGetSibling,
-	ZGetChild,
-	ZGetParent,
-	ZGetPropLen,
-	ZInc,
-	ZDec,
-	ZPrintAt,
-	nil,
-	ZMakeObjOrphan,
-	ZPrintObject,
-	ZReturn,
-	ZJump,
-	ZPrintAtPacked,
-	ZLoad,
-	ZNot,
-}
-
-var twoOpFuncs = []TwoOpFunc{
-	ZNOOP,
-	nil, // ZJe is a two op func but it accepts VAR count of args
-	ZJl,
-	ZJg,
-	ZDecChk,
-	ZIncChk,
-	ZJin,
-	ZTest,
-	ZOr,
-	ZAnd,
-	ZTestAttr,
-	ZSetAttr,
-	ZClearAttr,
-	ZStore,
-	ZInsertObj,
-	ZLoadW,
-	ZLoadB,
-	ZGetProp,
-	ZGetPropAddr,
-	ZGetNextProp,
-	ZAdd,
-	ZSub,
-	ZMul,
-	ZDiv,
-	ZMod,
-}
-
-var varOpFuncs = []VarOpFunc{
-	ZCall,
-	ZStoreW,
-	ZStoreB,
-	ZPutProp,
-	ZRead,
-	ZPrintChar,
-	ZPrintNum,
-	ZRandom,
-	ZPush,
-	ZPull,
+// zopInfos describes the opcodes of the supported versions, opcodes
+// reusing the number of an older one have an entry per range of versions
+var zopInfos []ZOpInfo
+
+func init() {
+	// read opcodes run interrupt routines through Interpret, which
+	// dispatches through this table, so it can't be statically initialized
+	zopInfos = zopInfoTable()
+	zopIndex = indexZOpInfos(zopInfos)
+}
+
+func zopInfoTable() []ZOpInfo {
+	return []ZOpInfo{
+		zeroOp(0x00, "rtrue", ZReturnTrue).stops(),
+		zeroOp(0x01, "rfalse", ZReturnFalse).stops(),
+		zeroOp(0x02, "print", ZPrint).withText(),
+		zeroOp(0x03, "print_ret", ZPrintRet).withText().stops(),
+		zeroOp(0x04, "nop", ZNop),
+		zeroOp(0x05, "save", ZSave).branches().until(3),
+		zeroOp(0x05, "save", ZSave).stores().since(4).until(4),
+		zeroOp(0x06, "restore", ZRestore).branches().until(3),
+		zeroOp(0x06, "restore", ZRestore).stores().since(4).until(4),
+		zeroOp(0x07, "restart", ZRestart).stops(),
+		zeroOp(0x08, "ret_popped", ZRetPop).stops(),
+		zeroOp(0x09, "pop", ZPop).until(4),
+		zeroOp(0x09, "catch", ZCatch).stores().since(5),
+		zeroOp(0x0A, "quit", ZQuit).stops(),
+		zeroOp(0x0B, "new_line", ZNl),
+		// v1-3 illegal from v4, but some stories use it anyway
+		zeroOp(0x0C, "show_status", ZShowStatus),
+		zeroOp(0x0D, "verify", ZVerify).branches().since(3),
+		zeroOp(0x0F, "piracy", ZPiracy).branches().since(5),
+
+		oneOp(0x00, "jz", ZJ0).branches(),
+		oneOp(0x01, "get_sibling", ZGetSibling).stores().branches(),
+		oneOp(0x02, "get_child", ZGetChild).stores().branches(),
+		oneOp(0x03, "get_parent", ZGetParent).stores(),
+		oneOp(0x04, "get_prop_len", ZGetPropLen).stores(),
+		oneOp(0x05, "inc", ZInc),
+		oneOp(0x06, "dec", ZDec),
+		oneOp(0x07, "print_addr", ZPrintAt),
+		oneOp(0x08, "call_1s", ZCall1S).stores().since(4).calls(),
+		oneOp(0x09, "remove_obj", ZMakeObjOrphan),
+		oneOp(0x0A, "print_obj", ZPrintObject),
+		oneOp(0x0B, "ret", ZReturn).stops(),
+		oneOp(0x0C, "jump", ZJump).stops(),
+		oneOp(0x0D, "print_paddr", ZPrintAtPacked),
+		oneOp(0x0E, "load", ZLoad).stores(),
+		oneOp(0x0F, "not", ZNot).stores().until(4),
+		oneOp(0x0F, "call_1n", ZCall1N).since(5).calls(),
+
+		// it's a 2OP taking up to 4 operands in the VAR form
+		newZOpInfo(TWOOP, 0x01, "je", 1, VarOpFunc(ZJe)).branches(),
+		twoOp(0x02, "jl", ZJl).branches(),
+		twoOp(0x03, "jg", ZJg).branches(),
+		twoOp(0x04, "dec_chk", ZDecChk).branches(),
+		twoOp(0x05, "inc_chk", ZIncChk).branches(),
+		twoOp(0x06, "jin", ZJin).branches(),
+		twoOp(0x07, "test", ZTest).branches(),
+		twoOp(0x08, "or", ZOr).stores(),
+		twoOp(0x09, "and", ZAnd).stores(),
+		twoOp(0x0A, "test_attr", ZTestAttr).branches(),
+		twoOp(0x0B, "set_attr", ZSetAttr),
+		twoOp(0x0C, "clear_attr", ZClearAttr),
+		twoOp(0x0D, "store", ZStore),
+		twoOp(0x0E, "insert_obj", ZInsertObj),
+		twoOp(0x0F, "loadw", ZLoadW).stores(),
+		twoOp(0x10, "loadb", ZLoadB).stores(),
+		twoOp(0x11, "get_prop", ZGetProp).stores(),
+		twoOp(0x12, "get_prop_addr", ZGetPropAddr).stores(),
+		twoOp(0x13, "get_next_prop", ZGetNextProp).stores(),
+		twoOp(0x14, "add", ZAdd).stores(),
+		twoOp(0x15, "sub", ZSub).stores(),
+		twoOp(0x16, "mul", ZMul).stores(),
+		twoOp(0x17, "div", ZDiv).stores(),
+		twoOp(0x18, "mod", ZMod).stores(),
+		twoOp(0x19, "call_2s", ZCall2S).stores().since(4).calls(),
+		twoOp(0x1A, "call_2n", ZCall2N).since(5).calls(),
+		twoOp(0x1B, "set_colour", ZSetColour).since(5),
+		twoOp(0x1C, "throw", ZThrow).since(5).stops(),
+
+		varOp(0x00, "call", ZCall).needs(1).stores().until(3).calls(),
+		varOp(0x00, "call_vs", ZCall).needs(1).stores().since(4).calls(),
+		varOp(0x01, "storew", ZStoreW).needs(3),
+		varOp(0x02, "storeb", ZStoreB).needs(3),
+		varOp(0x03, "put_prop", ZPutProp).needs(3),
+		varOp(0x04, "sread", ZRead).needs(1).until(4),
+		varOp(0x04, "aread", ZRead).needs(1).stores().since(5),
+		varOp(0x05, "print_char", ZPrintChar).needs(1),
+		varOp(0x06, "print_num", ZPrintNum).needs(1),
+		varOp(0x07, "random", ZRandom).needs(1).stores(),
+		varOp(0x08, "push", ZPush).needs(1),
+		varOp(0x09, "pull", ZPull).needs(1),
+		varOp(0x0A, "split_window", ZSplitWindow).needs(1).since(3),
+		varOp(0x0B, "set_window", ZSetWindow).needs(1).since(3),
+		varOp(0x0C, "call_vs2", ZCallVS2).needs(1).stores().since(4).calls(),
+		varOp(0x0D, "erase_window", ZEraseWindow).needs(1).since(4),
+		varOp(0x0E, "erase_line", ZEraseLine).needs(1).since(4),
+		varOp(0x0F, "set_cursor", ZSetCursor).needs(2).since(4),
+		varOp(0x10, "get_cursor", ZGetCursor).needs(1).since(4),
+		varOp(0x11, "set_text_style", ZSetTextStyle).needs(1).since(4),
+		varOp(0x12, "buffer_mode", ZBufferMode).needs(1).since(4),
+		varOp(0x13, "output_stream", ZOutputStream).needs(1).since(3),
+		varOp(0x14, "input_stream", ZInputStream).needs(1).since(3),
+		varOp(0x15, "sound_effect", ZSoundEffect).since(3),
+		varOp(0x16, "read_char", ZReadChar).needs(1).stores().since(4),
+		varOp(0x17, "scan_table", ZScanTable).needs(3).stores().branches().since(4),
+		varOp(0x18, "not", ZNotVar).needs(1).stores().since(5),
+		varOp(0x19, "call_vn", ZCallVN).needs(1).since(5).calls(),
+		varOp(0x1A, "call_vn2", ZCallVN2).needs(1).since(5).calls(),
+		varOp(0x1B, "tokenise", ZTokenise).needs(2).since(5),
+		varOp(0x1C, "encode_text", ZEncodeText).needs(4).since(5),
+		varOp(0x1D, "copy_table", ZCopyTable).needs(3).since(5),
+		varOp(0x1E, "print_table", ZPrintTable).needs(2).since(5),
+		varOp(0x1F, "check_arg_count", ZCheckArgCount).needs(1).branches().since(5),
+
+		extOp(0x00, "save", ZSaveExt).stores(),
+		extOp(0x01, "restore", ZRestoreExt).stores(),
+		extOp(0x02, "log_shift", ZLogShift).needs(2).stores(),
+		extOp(0x03, "art_shift", ZArtShift).needs(2).stores(),
+		extOp(0x04, "set_font", ZSetFont).needs(1).stores(),
+		extOp(0x09, "save_undo", ZSaveUndo).stores(),
+		extOp(0x0A, "restore_undo", ZRestoreUndo).stores(),
+		extOp(0x0B, "print_unicode", ZPrintUnicode).needs(1),
+		extOp(0x0C, "check_unicode", ZCheckUnicode).needs(1).stores(),
+		missingOp(EXTOP, 0x0D, "set_true_colour").needs(2).since(5),
+	}
 }
 
 func ZCall(zm *ZMachine, operands []uint16) {
-	routineAddr := PackedAddress(uint32(operands[0]))
+	zm.call(operands[0], operands[1:], zm.op.store, false)
+}
 
-	retAddr := zm.seq.pos
-	zm.seq.pos = routineAddr
-	routine := NewZRoutine(zm.seq, retAddr)
+// v4
+func ZCall1S(zm *ZMachine, paddr uint16) {
+	ZCall(zm, []uint16{paddr})
+}
+
+// v4
+func ZCall2S(zm *ZMachine, paddr uint16, arg uint16) {
+	ZCall(zm, []uint16{paddr, arg})
+}
+
+// v4 it's ZCall with up to 8 operands
+func ZCallVS2(zm *ZMachine, operands []uint16) {
+	ZCall(zm, operands)
+}
 
-	zm.stack.Push(routine)
+// v5
+func ZCall1N(zm *ZMachine, paddr uint16) {
+	zm.call(paddr, nil, 0, true)
+}
+
+// v5
+func ZCall2N(zm *ZMachine, paddr uint16, arg uint16) {
+	zm.call(paddr, []uint16{arg}, 0, true)
+}
+
+// v5
+func ZCallVN(zm *ZMachine, operands []uint16) {
+	zm.call(operands[0], operands[1:], 0, true)
+}
+
+// v5 it's ZCallVN with up to 8 operands
+func ZCallVN2(zm *ZMachine, operands []uint16) {
+	ZCallVN(zm, operands)
+}
+
+// v5
+func ZCheckArgCount(zm *ZMachine, args []uint16) {
+	zm.Branch(args[0] <= uint16(zm.stack.Top().argCount))
+}
 
-	if routineAddr == 0 {
-		ZReturnFalse(zm)
+// v5 the stack frame is identified by the depth of the call stack
+func ZCatch(zm *ZMachine) {
+	zm.StoreReturn(uint16(len(zm.stack)))
+}
+
+// v5 returns from the routine that called catch
+func ZThrow(zm *ZMachine, retValue uint16, frame uint16) {
+	if frame == 0 || int(frame) > len(zm.stack) {
+		zm.faultf("throw to invalid stack frame %d", frame)
 		return
 	}
 
-	if len(operands) > 1 {
-		// copy operands to locals
-		for i, v := range operands[1:] {
-			routine.locals[i] = v
-		}
-	}
-	zm.logger.Print("Call ", routine)
+	zm.stack = zm.stack[:frame]
+	ZReturn(zm, retValue)
 }
 
 func ZReturn(zm *ZMachine, retValue uint16) {
-	zm.seq.pos = zm.stack.Pop().retAddr
+	if len(zm.stack) < 2 {
+		zm.faultf("return from the main routine")
+		return
+	}
+
+	routine := zm.stack.Pop()
+	zm.seq.pos = routine.retAddr
 	zm.logger.Printf("Returning to 0x%X\n", zm.seq.pos)
-	zm.StoreReturn(retValue)
+
+	if !routine.discard {
+		zm.StoreVarAt(routine.storeVar, retValue)
+	}
 }
 
 func ZReturnFalse(zm *ZMachine) {
@@ -156,8 +255,7 @@ func ZJump(zm *ZMachine, offset uint16) {
 }
 
 func ZPrint(zm *ZMachine) {
-	str := zm.seq.DecodeZString(zm.header)
-	zm.iodev.Print(str)
+	zm.print(zm.op.text)
 }
 
 func ZPrintRet(zm *ZMachine) {
@@ -166,32 +264,83 @@ func ZPrintRet(zm *ZMachine) {
 	ZReturnTrue(zm)
 }
 
-func ZPrintObject(zm *ZMachine, obj uint16) {
-	// objects are 1-based
-	zm.iodev.Print(zm.objects[obj-1].name)
+func ZPrintObject(zm *ZMachine, objectId uint16) {
+	obj := zm.object(objectId)
+	if obj == nil {
+		return
+	}
+	zm.print(obj.Name())
 }
 
 func ZPrintAt(zm *ZMachine, addr uint16) {
 	str := zm.seq.mem.DecodeZStringAt(uint32(addr), zm.header)
-	zm.iodev.Print(str)
+	zm.print(str)
 }
 
 func ZPrintAtPacked(zm *ZMachine, paddr uint16) {
-	str := zm.seq.mem.DecodeZStringAt(PackedAddress(uint32(paddr)), zm.header)
-	zm.iodev.Print(str)
+	str := zm.seq.mem.DecodeZStringAt(zm.header.PackedAddress(uint32(paddr)), zm.header)
+	zm.print(str)
 }
 
 func ZPrintNum(zm *ZMachine, args []uint16) {
-	zm.iodev.Print(args[0])
+	zm.print(int16(args[0]))
 }
 
 func ZPrintChar(zm *ZMachine, args []uint16) {
-	// print only ASCII
-	if args[0] == 13 {
-		ZNl(zm)
-	} else if args[0] >= 32 && args[0] <= 126 {
-		zm.iodev.Print(fmt.Sprintf("%c", args[0]))
-	} // ignore everything else
+	zm.printZSCII(args[0])
+}
+
+// v5
+func ZPrintUnicode(zm *ZMachine, args []uint16) {
+	zm.print(string(rune(args[0])))
+}
+
+// v5
+func ZCheckUnicode(zm *ZMachine, args []uint16) {
+	r := rune(args[0])
+
+	res := uint16(0)
+	if unicode.IsPrint(r) {
+		// can be printed
+		res |= 1
+	}
+	if _, ok := zm.header.RuneToZSCII(r); ok {
+		// can be typed
+		res |= 2
+	}
+	zm.StoreReturn(res)
+}
+
+// v5
+func ZPrintTable(zm *ZMachine, args []uint16) {
+	addr := uint32(args[0])
+	width := uint32(args[1])
+
+	height, skip := uint32(1), uint32(0)
+	if len(args) > 2 {
+		height = uint32(args[2])
+	}
+	if len(args) > 3 {
+		skip = uint32(args[3])
+	}
+
+	// the upper window keeps the column of the first row
+	line, column := zm.screen.Cursor()
+	upper := zm.screen.Window() == UpperWindow
+
+	for row := uint32(0); row < height; row++ {
+		if row > 0 && upper {
+			zm.screen.SetCursor(line+int(row), column)
+		} else if row > 0 {
+			ZNl(zm)
+		}
+
+		for col := uint32(0); col < width; col++ {
+			zm.printZSCII(uint16(zm.seq.mem.ByteAt(addr)))
+			addr++
+		}
+		addr += skip
+	}
 }
 
 func ZAdd(zm *ZMachine, lhs uint16, rhs uint16) {
@@ -208,14 +357,20 @@ func ZMul(zm *ZMachine, lhs uint16, rhs uint16) {
 
 func ZDiv(zm *ZMachine, lhs uint16, rhs uint16) {
 	if rhs == 0 {
-		zm.logger.Panic("division by zero error")
+		if zm.violatef(DivisionByZero, "division by zero") {
+			zm.StoreReturn(0)
+		}
+		return
 	}
 	zm.StoreReturn(lhs / rhs)
 }
 
 func ZMod(zm *ZMachine, lhs uint16, rhs uint16) {
 	if rhs == 0 {
-		zm.logger.Panic("mod by zero error")
+		if zm.violatef(DivisionByZero, "mod by zero") {
+			zm.StoreReturn(0)
+		}
+		return
 	}
 	zm.StoreReturn(lhs % rhs)
 }
@@ -233,76 +388,145 @@ func ZNot(zm *ZMachine, arg uint16) {
 	zm.StoreReturn(^arg)
 }
 
-func ZNOOP(zm *ZMachine, _ uint16, _ uint16) {
-	zm.logger.Panic("NO OP 2OP")
+// v5 not has been moved to VAR
+func ZNotVar(zm *ZMachine, args []uint16) {
+	ZNot(zm, args[0])
+}
+
+// v5
+func ZLogShift(zm *ZMachine, args []uint16) {
+	places := int16(args[1])
+	if places >= 0 {
+		zm.StoreReturn(args[0] << uint(places))
+	} else {
+		zm.StoreReturn(args[0] >> uint(-places))
+	}
+}
+
+// v5
+func ZArtShift(zm *ZMachine, args []uint16) {
+	places := int16(args[1])
+	if places >= 0 {
+		zm.StoreReturn(args[0] << uint(places))
+	} else {
+		zm.StoreReturn(uint16(int16(args[0]) >> uint(-places)))
+	}
 }
 
 func ZLoad(zm *ZMachine, varnum uint16) {
-	zm.StoreReturn(zm.GetVarAt(byte(varnum)))
+	zm.StoreReturn(zm.GetIndirectVarAt(byte(varnum)))
 }
 
 func ZLoadB(zm *ZMachine, array uint16, bidx uint16) {
-	// TODO access violation
 	zm.StoreReturn(uint16(zm.seq.mem.ByteAt(uint32(array + bidx))))
 }
 
 func ZLoadW(zm *ZMachine, array uint16, widx uint16) {
-	// TODO access violation
 	// index is the index of the nth word
 	zm.StoreReturn(zm.seq.mem.WordAt(uint32(array + widx*2)))
 }
 
 func ZStore(zm *ZMachine, varnum uint16, value uint16) {
-	zm.StoreVarAt(byte(varnum), value)
+	zm.StoreIndirectVarAt(byte(varnum), value)
 }
 
 func ZStoreB(zm *ZMachine, args []uint16) {
-	// TODO access violation
 	addr := args[0] + args[1]
 	zm.seq.mem.WriteByteAt(uint32(addr), byte(args[2]))
 }
 
 func ZStoreW(zm *ZMachine, args []uint16) {
-	// TODO access violation
 	// index is the index of the nth word
 	addr := uint32(args[0]) + uint32(args[1])*2
 	zm.seq.mem.WriteWordAt(addr, args[2])
 }
 
+// v5
+func ZCopyTable(zm *ZMachine, args []uint16) {
+	mem := zm.seq.mem
+	first, second := uint32(args[0]), uint32(args[1])
+	size := int16(args[2])
+
+	switch {
+	case second == 0:
+		n := uint32(size)
+		if size < 0 {
+			n = uint32(-size)
+		}
+		for i := uint32(0); i < n; i++ {
+			mem.WriteByteAt(first+i, 0)
+		}
+	case size < 0:
+		// copy forwards even if the tables overlap
+		for i := uint32(0); i < uint32(-size); i++ {
+			mem.WriteByteAt(second+i, mem.ByteAt(first+i))
+		}
+	default:
+		data := append([]byte{}, mem.Slice(first, first+uint32(size))...)
+		for i, b := range data {
+			mem.WriteByteAt(second+uint32(i), b)
+		}
+	}
+}
+
 func ZPush(zm *ZMachine, args []uint16) {
-	zm.stack.Top().locals = append(zm.stack.Top().locals, args[0])
+	zm.stack.Top().push(args[0])
 }
 
 func ZPull(zm *ZMachine, args []uint16) {
-	varnum := args[0]
-
-	r := zm.stack.Top().locals[len(zm.stack.Top().locals)-1]
-	ZPop(zm)
+	r, err := zm.stack.Top().pop()
+	if err != nil && !zm.violate(StackUnderflow, err) {
+		return
+	}
 
-	zm.StoreVarAt(byte(varnum), r)
+	zm.StoreIndirectVarAt(byte(args[0]), r)
 }
 
 func ZPop(zm *ZMachine) {
-	zm.stack.Top().locals = zm.stack.Top().locals[:len(zm.stack.Top().locals)-1]
+	if _, err := zm.stack.Top().pop(); err != nil {
+		zm.violate(StackUnderflow, err)
+	}
 }
 
 func ZRetPop(zm *ZMachine) {
-	ret := zm.stack.Top().locals[len(zm.stack.Top().locals)-1]
-	ZPop(zm)
+	ret, err := zm.stack.Top().pop()
+	if err != nil && !zm.violate(StackUnderflow, err) {
+		return
+	}
 	ZReturn(zm, ret)
 }
 
 func ZInsertObj(zm *ZMachine, objectId uint16, newParentId uint16) {
-	zm.objects[objectId-1].ChangeParent(uint8(newParentId), zm.objects)
+	// a violation is reported once per instruction
+	obj := zm.object(objectId)
+	if obj == nil {
+		return
+	}
+	parent := zm.object(newParentId)
+	if parent == nil {
+		return
+	}
+
+	if err := obj.ChangeParent(parent.number); err != nil {
+		zm.fault(err)
+	}
 }
 
 func ZMakeObjOrphan(zm *ZMachine, objectId uint16) {
-	zm.objects[objectId-1].MakeOrphan(zm.objects)
+	obj := zm.object(objectId)
+	if obj == nil {
+		return
+	}
+	obj.MakeOrphan()
 }
 
 func ZJin(zm *ZMachine, childId uint16, parentId uint16) {
-	condition := zm.objects[childId-1].parent == uint8(parentId)
-	zm.Branch(condition)
+	child := zm.object(childId)
+	if child == nil {
+		// the branch is not taken, see emptyResult
+		return
+	}
+	zm.Branch(child.ParentId() == parentId)
 }
 
 func ZTest(zm *ZMachine, bitmap uint16, flags uint16) {
@@ -310,67 +534,128 @@ func ZTest(zm *ZMachine, bitmap uint16, flags uint16) {
 }
 
 func ZGetSibling(zm *ZMachine, objectId uint16) {
-	sibling := zm.objects[objectId-1].sibling
+	obj := zm.object(objectId)
+	if obj == nil {
+		zm.emptyResult()
+		return
+	}
+	sibling := obj.SiblingId()
 	zm.StoreReturn(uint16(sibling))
 	zm.Branch(sibling != NULL_OBJECT_INDEX)
 }
 
 func ZGetChild(zm *ZMachine, objectId uint16) {
-	child := zm.objects[objectId-1].child
+	obj := zm.object(objectId)
+	if obj == nil {
+		zm.emptyResult()
+		return
+	}
+	child := obj.ChildId()
 	zm.StoreReturn(uint16(child))
 	zm.Branch(child != NULL_OBJECT_INDEX)
 }
 
 func ZGetParent(zm *ZMachine, objectId uint16) {
-	zm.StoreReturn(uint16(zm.objects[objectId-1].parent))
+	obj := zm.object(objectId)
+	if obj == nil {
+		zm.emptyResult()
+		return
+	}
+	zm.StoreReturn(uint16(obj.ParentId()))
 }
 
 func ZPutProp(zm *ZMachine, args []uint16) {
-	zm.objects[args[0]-1].SetProperty(byte(args[1]), args[2])
+	obj := zm.propertyObject(args[0], args[1], false)
+	if obj == nil {
+		return
+	}
+
+	if obj.GetPropertyAddr(byte(args[1])) == 0 {
+		zm.violatef(InvalidProperty, "property %d of object %d not found", args[1], args[0])
+		return
+	}
+
+	if err := obj.SetProperty(byte(args[1]), args[2]); err != nil {
+		zm.fault(err)
+	}
 }
 
 func ZGetProp(zm *ZMachine, objectId uint16, propertyId uint16) {
-	res, err := zm.objects[objectId-1].GetProperty(byte(propertyId))
+	obj := zm.propertyObject(objectId, propertyId, false)
+	if obj == nil {
+		zm.emptyResult()
+		return
+	}
+
+	res, err := obj.GetProperty(byte(propertyId))
 	if err != nil {
-		zm.logger.Panic(err)
+		zm.fault(err)
+		return
 	}
 	zm.StoreReturn(res)
 }
 
 func ZGetNextProp(zm *ZMachine, objectId uint16, prop uint16) {
-	res := zm.objects[objectId-1].NextProperty(byte(prop))
-	zm.StoreReturn(uint16(res))
+	obj := zm.propertyObject(objectId, prop, true)
+	if obj == nil {
+		zm.emptyResult()
+		return
+	}
+	next, err := obj.NextProperty(byte(prop))
+	if err != nil {
+		if zm.violate(InvalidProperty, err) {
+			zm.StoreReturn(0)
+		}
+		return
+	}
+	zm.StoreReturn(uint16(next))
 }
 
 func ZGetPropLen(zm *ZMachine, propertyAddr uint16) {
 	if propertyAddr == 0 {
 		zm.StoreReturn(0)
 	} else {
-		res := GetPropertyLen(zm.seq.mem, uint32(propertyAddr))
+		res := GetPropertyLen(zm.seq.mem, uint32(propertyAddr), zm.header)
 		zm.StoreReturn(res)
 	}
 }
 
 func ZGetPropAddr(zm *ZMachine, objectId uint16, propertyId uint16) {
-	addr := zm.objects[objectId-1].GetPropertyAddr(byte(propertyId))
-	zm.StoreReturn(uint16(addr))
+	obj := zm.propertyObject(objectId, propertyId, false)
+	if obj == nil {
+		zm.emptyResult()
+		return
+	}
+	zm.StoreReturn(uint16(obj.GetPropertyAddr(byte(propertyId))))
 }
 
 func ZTestAttr(zm *ZMachine, objectId uint16, attrId uint16) {
-	cond := zm.objects[objectId-1].attributes[attrId]
-	zm.Branch(cond)
+	obj := zm.attrObject(objectId, attrId)
+	if obj == nil {
+		// the branch is not taken, see emptyResult
+		return
+	}
+	zm.Branch(obj.Attribute(attrId))
 }
 
 func ZSetAttr(zm *ZMachine, objectId uint16, attrId uint16) {
-	zm.objects[objectId-1].attributes[attrId] = true
+	obj := zm.attrObject(objectId, attrId)
+	if obj == nil {
+		return
+	}
+	obj.SetAttribute(attrId, true)
 }
 
 func ZClearAttr(zm *ZMachine, objectId uint16, attrId uint16) {
-	zm.objects[objectId-1].attributes[attrId] = false
+	obj := zm.attrObject(objectId, attrId)
+	if obj == nil {
+		return
+	}
+	obj.SetAttribute(attrId, false)
 }
 
 func ZNl(zm *ZMachine) {
-	zm.iodev.Print("\n")
+	zm.print("\n")
 }
 
 func ZInc(zm *ZMachine, varnum uint16) {
@@ -393,60 +678,507 @@ func ZDecChk(zm *ZMachine, varnum uint16, value uint16) {
 
 func ZRead(zm *ZMachine, args []uint16) {
 	textPos := uint32(args[0])
-	parseTblPos := uint32(args[1])
 
-	s := zm.iodev.ReadLine()
+	parseTblPos := uint32(0)
+	if len(args) > 1 {
+		parseTblPos = uint32(args[1])
+	}
+
+	// v4 optional timed input
+	var tenths, routine uint16
+	if len(args) > 3 {
+		tenths, routine = args[2], args[3]
+	}
+
+	// interrupt routines change it while reading
+	readPos := zm.opPos
+	zm.snapshotTurn(readPos)
 
-	zm.logger.Printf("Read %s", s)
+	// v1-3 the status line is updated before every read
+	zm.showStatus()
 
-	seq := zm.seq.mem.GetSequential(textPos)
+	s, aborted := zm.readLine(tenths, routine)
+	if zm.err != nil {
+		return
+	}
 
-	maxLen := int(seq.ReadByte()) + 1
-	if maxLen < len(s) {
-		s = s[:maxLen]
+	if !aborted && zm.metaCommand(s, readPos) {
+		// another turn has been resumed or this read is done again
+		return
 	}
+
+	s, terminator := zm.splitTerminator(s)
+	if aborted {
+		// the interrupt routine asked to leave the buffer empty
+		s, terminator = "", 0
+	} else {
+		zm.echoInput(s)
+	}
+
 	// doubling ToLower and Trim :(
-	s = strings.Trim(strings.ToLower(s), " \r\n")
+	text := zm.inputToZSCII(strings.Trim(s, " \r\n"))
+
+	mem := zm.seq.mem
+
+	if zm.header.version <= 4 {
+		// byte #0 is the size of the buffer, terminator included
+		maxLen := int(mem.ByteAt(textPos)) - 1
+		if maxLen < 0 {
+			maxLen = 0
+		}
+		if maxLen < len(text) {
+			text = text[:maxLen]
+		}
+
+		seq := mem.GetSequential(textPos + 1)
+		for _, c := range text {
+			seq.WriteByte(c)
+		}
+		// null terminator
+		seq.WriteByte(0)
+
+		if parseTblPos != 0 {
+			// byte #0 is maxLen, so text starts from byte #1
+			zm.tokenise(string(text), 1, parseTblPos, zm.dictionary, false)
+		}
+		return
+	}
+
+	// v5 byte #0 is maxLen and byte #1 the number of chars already
+	// in the buffer, text is appended to them and it's not terminated
+	maxLen := int(mem.ByteAt(textPos))
+	prevLen := int(mem.ByteAt(textPos + 1))
+	if prevLen > maxLen {
+		prevLen = maxLen
+	}
+	if maxLen-prevLen < len(text) {
+		text = text[:maxLen-prevLen]
+	}
+
+	seq := mem.GetSequential(textPos + 2 + uint32(prevLen))
+	for _, c := range text {
+		seq.WriteByte(c)
+	}
+	mem.WriteByteAt(textPos+1, byte(prevLen+len(text)))
+
+	if parseTblPos != 0 {
+		all := mem.Slice(textPos+2, textPos+2+uint32(prevLen+len(text)))
+		zm.tokenise(string(all), 2, parseTblPos, zm.dictionary, false)
+	}
+
+	zm.StoreReturn(terminator)
+}
+
+// v5
+func ZTokenise(zm *ZMachine, args []uint16) {
+	textPos := uint32(args[0])
+	parseTblPos := uint32(args[1])
+
+	dict := zm.dictionary
+	if len(args) > 2 && args[2] != 0 {
+		dict = NewZDictionaryAt(zm.seq.mem, zm.header, uint32(args[2]))
+	}
+	skipUnknown := len(args) > 3 && args[3] != 0
+
+	n := uint32(zm.seq.mem.ByteAt(textPos + 1))
+	text := zm.seq.mem.Slice(textPos+2, textPos+2+n)
 
-	for i := range s {
-		seq.WriteByte(s[i])
+	zm.tokenise(string(text), 2, parseTblPos, dict, skipUnknown)
+}
+
+// v5
+func ZEncodeText(zm *ZMachine, args []uint16) {
+	mem := zm.seq.mem
+
+	start := uint32(args[0]) + uint32(args[2])
+	text := mem.Slice(start, start+uint32(args[1]))
+
+	encoded := ZStringEncode(zm.zsciiToString(text), zm.header)
+	for i, w := range encoded {
+		mem.WriteWordAt(uint32(args[3])+uint32(i)*2, w)
+	}
+}
+
+// v4 reads a single key, frontends are line based so it's the first
+// char of the line, or a newline if the line is empty
+func ZReadChar(zm *ZMachine, args []uint16) {
+	var tenths, routine uint16
+	if len(args) > 2 {
+		tenths, routine = args[1], args[2]
+	}
+
+	s, aborted := zm.readLine(tenths, routine)
+	if zm.err != nil {
+		return
+	}
+
+	if aborted {
+		zm.StoreReturn(0)
+		return
 	}
-	// null terminator
-	seq.WriteByte(0)
 
-	// ignore incomplete reads :)
+	char := uint16(13)
+	if key, _ := functionKeyAt(s); key != 0 {
+		char = key
+	} else if text := zm.inputToZSCII(strings.TrimRight(s, "\r\n")); len(text) > 0 {
+		char = uint16(text[0])
+	}
+	zm.StoreReturn(char)
+}
+
+// v4
+func ZScanTable(zm *ZMachine, args []uint16) {
+	x, table, length := args[0], uint32(args[1]), uint32(args[2])
+
+	// word fields of 2 bytes
+	form := uint16(0x82)
+	if len(args) > 3 {
+		form = args[3]
+	}
+
+	fieldLen := uint32(form & 0x7F)
+	words := form&0x80 != 0
+
+	for i := uint32(0); fieldLen > 0 && i < length; i++ {
+		addr := table + i*fieldLen
+
+		var v uint16
+		if words {
+			v = zm.seq.mem.WordAt(addr)
+		} else {
+			v = uint16(zm.seq.mem.ByteAt(addr))
+		}
+
+		if v == x {
+			zm.StoreReturn(uint16(addr))
+			zm.Branch(true)
+			return
+		}
+	}
+
+	zm.StoreReturn(0)
+	zm.Branch(false)
+}
+
+func ZSave(zm *ZMachine) {
+	filename, err := zm.askFilename()
+	if err != nil {
+		zm.fault(err)
+		return
+	}
 
-	words := SplitSentence(s, string(zm.dictionary.wordSeparators))
+	err = zm.saveToFile(filename)
+	if err != nil {
+		zm.logger.Print("Save failed: ", err)
+	}
 
-	seq = zm.seq.mem.GetSequential(parseTblPos)
-	maxWords := seq.ReadByte()
-	if int(maxWords) < len(words) {
-		words = words[:maxWords]
+	if zm.header.version <= 3 {
+		zm.Branch(err == nil)
+	} else if err != nil {
+		zm.StoreReturn(0)
+	} else {
+		zm.StoreReturn(1)
 	}
+}
 
-	seq.WriteByte(byte(len(words)))
+func ZRestore(zm *ZMachine) {
+	filename, err := zm.askFilename()
+	if err != nil {
+		zm.fault(err)
+		return
+	}
 
-	// byte #0 is maxLen, so start from byte #1
-	lastWordPos := byte(1)
-	for _, w := range words {
-		// truncate words to the max entrysize
-		originalLen := len(w)
-		if len(w) > int(zm.dictionary.entrySize)-1 {
-			w = w[:zm.dictionary.entrySize-1]
+	err = zm.restoreFromFile(filename)
+	if err != nil {
+		zm.logger.Print("Restore failed: ", err)
+		if zm.header.version <= 3 {
+			zm.Branch(false)
+		} else {
+			zm.StoreReturn(0)
 		}
+		return
+	}
 
-		// 4 byte block
-		// word: address of word searched in the dict
-		// byte: #chars of the word
-		// byte: position of the first letter of the word in text-buffer
+	// execution continues from the branch data (v4 the store
+	// variable) of the save instruction as if it had succeeded,
+	// they're laid out as the ones of restore
+	zm.op.readResult(zm.seq)
+	if zm.header.version <= 3 {
+		zm.Branch(true)
+	} else {
+		zm.StoreReturn(2)
+	}
+}
 
-		seq.WriteWord(zm.dictionary.Search(w))
-		seq.WriteByte(uint8(originalLen))
-		seq.WriteByte(lastWordPos)
+// v5 with no operands it's ZSave, otherwise it saves
+// the given table to an auxiliary file
+func ZSaveExt(zm *ZMachine, args []uint16) {
+	if len(args) < 2 {
+		ZSave(zm)
+		return
+	}
 
-		lastWordPos += byte(len(w))
+	filename, err := zm.auxFilename(args)
+	if err != nil {
+		zm.fault(err)
+		return
 	}
 
+	table := uint32(args[0])
+	data := zm.seq.mem.Slice(table, table+uint32(args[1]))
+
+	path, err := zm.playerFile(filename)
+	if err == nil {
+		err = ioutil.WriteFile(path, data, 0644)
+	}
+	if err != nil {
+		zm.logger.Print("Save failed: ", err)
+		zm.StoreReturn(0)
+		return
+	}
+	zm.StoreReturn(1)
+}
+
+// v5 with no operands it's ZRestore, otherwise it loads
+// an auxiliary file into the given table
+func ZRestoreExt(zm *ZMachine, args []uint16) {
+	if len(args) < 2 {
+		ZRestore(zm)
+		return
+	}
+
+	filename, err := zm.auxFilename(args)
+	if err != nil {
+		zm.fault(err)
+		return
+	}
+
+	path, err := zm.playerFile(filename)
+	var data []byte
+	if err == nil {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		zm.logger.Print("Restore failed: ", err)
+		zm.StoreReturn(0)
+		return
+	}
+
+	table := uint32(args[0])
+	if len(data) > int(args[1]) {
+		data = data[:args[1]]
+	}
+	for i, b := range data {
+		zm.seq.mem.WriteByteAt(table+uint32(i), b)
+	}
+	zm.StoreReturn(uint16(len(data)))
+}
+
+// auxFilename returns the name suggested by the story
+// for an auxiliary file or asks for one
+func (zm *ZMachine) auxFilename(args []uint16) (string, error) {
+	if len(args) < 3 || args[2] == 0 {
+		return zm.askFilename()
+	}
+
+	// the name is stored as a length byte followed by its chars
+	addr := uint32(args[2])
+	n := uint32(zm.seq.mem.ByteAt(addr))
+	name := zm.zsciiToString(zm.seq.mem.Slice(addr+1, addr+1+n))
+
+	return strings.TrimSpace(name) + ".aux", nil
+}
+
+// v5
+func ZSaveUndo(zm *ZMachine, args []uint16) {
+	if zm.undo.depth <= 0 {
+		// -1, undo is not available
+		zm.StoreReturn(0xFFFF)
+		return
+	}
+
+	zm.redo.clear()
+	// the state resumes after the instruction, storing its result
+	state := zm.snapshot(zm.seq.pos, true)
+	state.store = zm.op.store
+	zm.undo.push(state)
+	zm.StoreReturn(1)
+}
+
+// v5
+func ZRestoreUndo(zm *ZMachine, args []uint16) {
+	state, ok := zm.undo.pop()
+	if !ok {
+		zm.StoreReturn(0)
+		return
+	}
+
+	// as ZRestore, save_undo returns 2
+	zm.restoreSnapshot(state)
+}
+
+func ZRestart(zm *ZMachine) {
+	zm.Restart()
+}
+
+func ZVerify(zm *ZMachine) {
+	zm.Branch(zm.Verify())
+}
+
+func ZQuit(zm *ZMachine) {
+	zm.quitted = true
+}
+
+func ZNop(zm *ZMachine) {
+}
+
+// v5 the game is always genuine
+func ZPiracy(zm *ZMachine) {
+	zm.Branch(true)
+}
+
+// v1-3 illegal from v4, but some stories use it anyway
+func ZShowStatus(zm *ZMachine) {
+	zm.showStatus()
+}
+
+func ZSplitWindow(zm *ZMachine, args []uint16) {
+	zm.screen.Split(int(args[0]), zm.header.version)
+}
+
+func ZSetWindow(zm *ZMachine, args []uint16) {
+	zm.screen.SelectWindow(int(args[0]))
+}
+
+// v4
+func ZEraseWindow(zm *ZMachine, args []uint16) {
+	zm.screen.Erase(int(int16(args[0])))
+}
+
+// v4 only 1 is a valid operand
+func ZEraseLine(zm *ZMachine, args []uint16) {
+	if args[0] == 1 {
+		zm.screen.EraseLine()
+	}
+}
+
+// v4 only the upper window has a cursor that can be moved
+func ZSetCursor(zm *ZMachine, args []uint16) {
+	if len(args) < 2 {
+		zm.faultf("set_cursor with %d operands", len(args))
+		return
+	}
+	zm.screen.SetCursor(int(int16(args[0])), int(int16(args[1])))
+}
+
+// v4
+func ZGetCursor(zm *ZMachine, args []uint16) {
+	line, column := zm.screen.Cursor()
+	zm.seq.mem.WriteWordAt(uint32(args[0]), uint16(line))
+	zm.seq.mem.WriteWordAt(uint32(args[0])+2, uint16(column))
+}
+
+// v4
+func ZSetTextStyle(zm *ZMachine, args []uint16) {
+	zm.screen.SetStyle(args[0])
+}
+
+// v5 only the normal and the fixed pitch fonts are available
+func ZSetFont(zm *ZMachine, args []uint16) {
+	prev := zm.font
+
+	switch args[0] {
+	case 0:
+		// just query the current font
+	case 1, 4:
+		zm.font = args[0]
+	default:
+		prev = 0
+	}
+	zm.StoreReturn(prev)
+}
+
+// v5 colours are not available, see Flags 1
+func ZSetColour(zm *ZMachine, foreground uint16, background uint16) {
+}
+
+// v4
+func ZBufferMode(zm *ZMachine, args []uint16) {
+	zm.screen.BufferMode = args[0] != 0
+}
+
+func ZOutputStream(zm *ZMachine, args []uint16) {
+	stream := int16(args[0])
+
+	switch stream {
+	case 0:
+		// nothing to do
+	case ScreenStream:
+		zm.streams.screen = true
+	case -ScreenStream:
+		zm.streams.screen = false
+	case TranscriptStream:
+		// the story asks for the file through Flags 2 bit 0
+		zm.setTranscriptFlag(true)
+		zm.syncTranscript()
+	case -TranscriptStream:
+		zm.setTranscriptFlag(false)
+		zm.syncTranscript()
+	case MemoryStream:
+		if len(args) < 2 {
+			zm.faultf("output stream 3 without a table")
+			return
+		}
+		zm.openMemoryStream(uint32(args[1]))
+	case -MemoryStream:
+		zm.closeMemoryStream()
+	case CommandsStream:
+		filename, err := zm.askFilename()
+		if err == nil {
+			err = zm.OpenCommandsStream(filename)
+		}
+		if err != nil {
+			zm.logger.Print("Commands stream failed: ", err)
+		}
+	case -CommandsStream:
+		zm.closeCommandsStream()
+	default:
+		zm.logger.Printf("Output stream %d is not supported\n", stream)
+	}
+}
+
+func ZInputStream(zm *ZMachine, args []uint16) {
+	switch args[0] {
+	case 0:
+		// back to the keyboard
+		zm.input.setCommands(nil)
+	case 1:
+		filename, err := zm.askFilename()
+		if err == nil {
+			err = zm.OpenCommandsFile(filename)
+		}
+		if err != nil {
+			zm.logger.Print("Input stream 1 failed: ", err)
+		}
+	default:
+		zm.logger.Printf("Input stream %d is not supported\n", args[0])
+	}
+}
+
+func ZSoundEffect(zm *ZMachine, args []uint16) {
+	number := uint16(1)
+	if len(args) > 0 {
+		number = args[0]
+	}
+
+	// v3 only high and low pitched bleeps are available, the bell
+	// goes to the device as it's not text of any output stream
+	if number == 1 || number == 2 {
+		zm.render()
+		zm.iodev.Print("\a")
+	} else {
+		zm.logger.Printf("Sound effect %d is not supported\n", number)
+	}
 }
 
 func ZRandom(zm *ZMachine, args []uint16) {
@@ -455,11 +1187,11 @@ func ZRandom(zm *ZMachine, args []uint16) {
 	retVal := uint16(0)
 
 	if value > 0 {
-		retVal = uint16(rand.Intn(int(value)) + 1)
+		retVal = zm.random.next(uint16(value))
 	} else if value < 0 {
-		rand.Seed(int64(value))
+		zm.random.seed(-int64(value))
 	} else {
-		rand.Seed(time.Now().Unix())
+		zm.random.randomize()
 	}
 
 	zm.StoreReturn(retVal)
@@ -0,0 +1,162 @@
+package gork
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ZErrorPolicy tells what to do when the story breaks one of the rules
+// listed by ZViolation, older Infocom releases rely on lenient handling
+type ZErrorPolicy int
+
+const (
+	// ErrorsFatal stops the story with a runtime error, it's the default
+	ErrorsFatal ZErrorPolicy = iota
+	// ErrorsWarnAlways logs every violation and goes on
+	ErrorsWarnAlways
+	// ErrorsWarnOnce logs the first violation of each kind and goes on
+	ErrorsWarnOnce
+	// ErrorsIgnore just goes on
+	ErrorsIgnore
+)
+
+var errorPolicyNames = [...]string{"fatal", "always", "once", "ignore"}
+
+func (policy ZErrorPolicy) String() string {
+	if policy < 0 || int(policy) >= len(errorPolicyNames) {
+		return fmt.Sprintf("ZErrorPolicy(%d)", int(policy))
+	}
+	return errorPolicyNames[policy]
+}
+
+// ParseErrorPolicy returns the policy named name, that is one of
+// fatal, always, once and ignore
+func ParseErrorPolicy(name string) (ZErrorPolicy, error) {
+	for i, policyName := range errorPolicyNames {
+		if strings.EqualFold(name, policyName) {
+			return ZErrorPolicy(i), nil
+		}
+	}
+	return ErrorsFatal, fmt.Errorf("unknown error policy %q, expected one of %s",
+		name, strings.Join(errorPolicyNames[:], ", "))
+}
+
+// ZViolation is a kind of spec violation handled by the error policy,
+// when the policy lets the story go on the instruction gets a harmless
+// result: 0 is stored and branches are not taken, whatever their
+// condition, execution goes on with the next instruction
+type ZViolation int
+
+const (
+	DivisionByZero ZViolation = iota
+	// object 0 given to an object opcode
+	ObjectZero
+	// property number out of range, or missing when it must exist
+	InvalidProperty
+	// attribute number beyond the attributes of the version
+	InvalidAttribute
+	// read from an empty evaluation stack
+	StackUnderflow
+	violationsCount
+)
+
+var violationNames = [...]string{
+	"division by zero",
+	"object 0",
+	"invalid property",
+	"invalid attribute",
+	"stack underflow",
+}
+
+func (kind ZViolation) String() string {
+	if kind < 0 || kind >= violationsCount {
+		return fmt.Sprintf("ZViolation(%d)", int(kind))
+	}
+	return violationNames[kind]
+}
+
+type zviolations struct {
+	policy ZErrorPolicy
+	counts [violationsCount]int
+	// log the counts when the story ends
+	report bool
+}
+
+// ErrorPolicy selects what happens on spec violations, see ZViolation
+func ErrorPolicy(policy ZErrorPolicy) ZOption {
+	return func(zm *ZMachine) {
+		zm.violations.policy = policy
+	}
+}
+
+// ReportViolations makes InterpretAll log how many violations
+// of each kind the story did
+func ReportViolations() ZOption {
+	return func(zm *ZMachine) {
+		zm.violations.report = true
+	}
+}
+
+// Violations returns how many violations of each kind
+// the story did, kinds that never happened are left out
+func (zm *ZMachine) Violations() map[ZViolation]int {
+	ret := map[ZViolation]int{}
+	for kind, count := range zm.violations.counts {
+		if count > 0 {
+			ret[ZViolation(kind)] = count
+		}
+	}
+	return ret
+}
+
+// violate handles a violation of kind according to the error policy,
+// it returns true if the instruction can go on with a harmless result,
+// otherwise it has raised a fault
+func (zm *ZMachine) violate(kind ZViolation, err error) bool {
+	zm.violations.counts[kind]++
+
+	switch zm.violations.policy {
+	case ErrorsFatal:
+		zm.fault(err)
+		return false
+	case ErrorsWarnAlways:
+		zm.warn(err)
+	case ErrorsWarnOnce:
+		if zm.violations.counts[kind] == 1 {
+			zm.warn(err)
+		}
+	}
+	return true
+}
+
+func (zm *ZMachine) violatef(kind ZViolation, format string, a ...interface{}) bool {
+	return zm.violate(kind, fmt.Errorf(format, a...))
+}
+
+func (zm *ZMachine) warn(err error) {
+	if zm.logger != nil {
+		zm.logger.Printf("Warning at PC %X: %s", zm.opPos, err)
+	}
+}
+
+// emptyResult completes a store instruction cut short by a violation
+// the error policy let through, it stores 0 and it doesn't branch:
+// instructions cut short go on with the next one, even the ones
+// branching on false
+func (zm *ZMachine) emptyResult() {
+	if zm.err == nil {
+		zm.StoreReturn(0)
+	}
+}
+
+func (zm *ZMachine) reportViolations() {
+	if !zm.violations.report || zm.logger == nil {
+		return
+	}
+
+	for kind, count := range zm.violations.counts {
+		if count > 0 {
+			zm.logger.Printf("%s: %d", ZViolation(kind), count)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package gork
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// recordLogger keeps the warnings and the report, not the trace
+type recordLogger struct {
+	lines []string
+}
+
+func (logger *recordLogger) Print(a ...interface{}) {
+	logger.record(fmt.Sprint(a...))
+}
+
+func (logger *recordLogger) Printf(format string, a ...interface{}) {
+	logger.record(fmt.Sprintf(format, a...))
+}
+
+func (logger *recordLogger) record(line string) {
+	if !strings.HasPrefix(line, "Interpreting") {
+		logger.lines = append(logger.lines, line)
+	}
+}
+
+func TestParseErrorPolicy(t *testing.T) {
+	for _, policy := range []ZErrorPolicy{ErrorsFatal, ErrorsWarnAlways, ErrorsWarnOnce, ErrorsIgnore} {
+		parsed, err := ParseErrorPolicy(policy.String())
+		if err != nil || parsed != policy {
+			t.Fail()
+		}
+	}
+
+	if _, err := ParseErrorPolicy("lenient"); err == nil {
+		t.Fail()
+	}
+}
+
+var violatingInstructions = []struct {
+	kind ZViolation
+	code []byte
+	// value left on the stack by lenient policies, -1 if none
+	result int
+}{
+	// div 5 0 -> sp
+	{DivisionByZero, []byte{0x17, 0x05, 0x00, 0x00}, 0},
+	// mod 5 0 -> sp
+	{DivisionByZero, []byte{0x18, 0x05, 0x00, 0x00}, 0},
+	// get_parent 0 -> sp
+	{ObjectZero, []byte{0x93, 0x00, 0x00}, 0},
+	// set_attr 0 1
+	{ObjectZero, []byte{0x0B, 0x00, 0x01}, -1},
+	// pop
+	{StackUnderflow, []byte{0xB9}, -1},
+	// add sp 1 -> sp
+	{StackUnderflow, []byte{0x54, 0x00, 0x01, 0x00}, 1},
+}
+
+func runViolation(code []byte, policy ZErrorPolicy, logger ZLogger) (*ZMachine, error) {
+	mem := NewZMemory(append(make([]byte, 4), code...))
+	zm := &ZMachine{
+		header: &ZHeader{version: 3},
+		seq:    mem.GetSequential(4),
+		stack:  ZStack{&ZRoutine{addr: 4, locals: []uint16{}}},
+		logger: logger,
+	}
+	ErrorPolicy(policy)(zm)
+
+	return zm, zm.Interpret()
+}
+
+func TestZMachineErrorPolicy(t *testing.T) {
+	for _, test := range violatingInstructions {
+		zm, err := runViolation(test.code, ErrorsFatal, nullLogger{})
+		if _, ok := err.(*ZRuntimeError); !ok || zm.Violations()[test.kind] != 1 {
+			t.Fail()
+		}
+
+		for _, policy := range []ZErrorPolicy{ErrorsWarnAlways, ErrorsWarnOnce, ErrorsIgnore} {
+			logger := &recordLogger{}
+			zm, err := runViolation(test.code, policy, logger)
+			if err != nil || zm.Violations()[test.kind] != 1 {
+				t.Fail()
+				continue
+			}
+
+			stack := zm.stack.Top().stack
+			if test.result < 0 && len(stack) != 0 {
+				t.Fail()
+			}
+			if test.result >= 0 && (len(stack) != 1 || stack[0] != uint16(test.result)) {
+				t.Fail()
+			}
+
+			if (len(logger.lines) == 1) != (policy != ErrorsIgnore) {
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestZMachineWarnOnce(t *testing.T) {
+	// div 5 0 -> sp twice, then quit
+	code := []byte{0x17, 0x05, 0x00, 0x00, 0x17, 0x05, 0x00, 0x00, 0xBA}
+
+	for _, test := range []struct {
+		policy ZErrorPolicy
+		lines  int
+	}{
+		{ErrorsWarnAlways, 2},
+		{ErrorsWarnOnce, 1},
+		{ErrorsIgnore, 0},
+	} {
+		logger := &recordLogger{}
+		mem := NewZMemory(append(make([]byte, 4), code...))
+		zm := &ZMachine{
+			header: &ZHeader{version: 3},
+			seq:    mem.GetSequential(4),
+			stack:  ZStack{&ZRoutine{addr: 4, locals: []uint16{}}},
+			logger: logger,
+		}
+		ErrorPolicy(test.policy)(zm)
+
+		for i := 0; i < 3; i++ {
+			if err := zm.Interpret(); err != nil {
+				t.FailNow()
+			}
+		}
+
+		if len(logger.lines) != test.lines || zm.Violations()[DivisionByZero] != 2 {
+			t.Fail()
+		}
+
+		// the report adds a line per kind
+		zm.violations.report = true
+		zm.reportViolations()
+		if len(logger.lines) != test.lines+1 || logger.lines[test.lines] != "division by zero: 2" {
+			t.Fail()
+		}
+	}
+}
+
+func TestZMachineViolationInsertObj(t *testing.T) {
+	// insert_obj 0 0
+	logger := &recordLogger{}
+	zm, err := runViolation([]byte{0x0E, 0x00, 0x00}, ErrorsWarnAlways, logger)
+	if err != nil || zm.Violations()[ObjectZero] != 1 || len(logger.lines) != 1 {
+		t.Fail()
+	}
+}
+
+func TestZMachineViolationBranch(t *testing.T) {
+	for _, code := range [][]byte{
+		// jin 0 1 ?~(+10)
+		{0x06, 0x00, 0x01, 0x4A},
+		// test_attr 0 1 ?~(+10)
+		{0x0A, 0x00, 0x01, 0x4A},
+		// get_child 0 -> sp ?~(+10)
+		{0x92, 0x00, 0x00, 0x4A},
+	} {
+		zm, err := runViolation(code, ErrorsIgnore, nullLogger{})
+		if err != nil || zm.seq.pos != 4+uint32(len(code)) {
+			t.Fail()
+		}
+	}
+}
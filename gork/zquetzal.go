@@ -0,0 +1,402 @@
+package gork
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Quetzal (IFZS) save file format, see
+// http://inform-fiction.org/zmachine/standards/quetzal/index.html
+//
+// FORM <len> IFZS
+//   IFhd: release, serial, checksum and PC
+//   CMem: dynamic memory xor-ed with the original story and run length
+//         encoded on zeros
+//   Stks: call stack frames, from the bottom of the stack to the top
+//
+// the slots of #save are FORM <len> GSLT with the same chunks
+
+const ifhdLen = 13
+
+// form types: Quetzal saves resume from a save instruction, the slots
+// of #save have the same chunks but they resume from the read
+// instruction the command has been typed at, so other interpreters
+// must not take them for saves
+const (
+	quetzalForm = "IFZS"
+	slotForm    = "GSLT"
+)
+
+func (zm *ZMachine) SaveQuetzal(w io.Writer) error {
+	// v3 PC is the address of the branch data of the save instruction,
+	// v4 the one of its store variable
+	pc := zm.seq.pos
+	if zm.op != nil {
+		pc = zm.op.resultPos
+	}
+
+	dynMem := zm.seq.mem.Slice(0, uint32(zm.header.dynMemSize))
+	return zm.writeQuetzal(w, quetzalForm, pc, dynMem, zm.stack)
+}
+
+// writeQuetzal writes the chunks of a Quetzal save resuming from pc
+// in a form of type formType
+func (zm *ZMachine) writeQuetzal(w io.Writer, formType string, pc uint32, dynMem []byte, stack ZStack) error {
+	form := new(bytes.Buffer)
+	form.WriteString(formType)
+
+	writeIFFChunk(form, "IFhd", zm.quetzalIFhd(pc))
+	writeIFFChunk(form, "CMem", quetzalCompress(zm.original, dynMem))
+
+	stks, err := quetzalStks(stack)
+	if err != nil {
+		return err
+	}
+	writeIFFChunk(form, "Stks", stks)
+
+	out := new(bytes.Buffer)
+	writeIFFChunk(out, "FORM", form.Bytes())
+
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+func (zm *ZMachine) RestoreQuetzal(r io.Reader) error {
+	state, err := zm.readQuetzal(r, quetzalForm)
+	if err != nil {
+		return err
+	}
+
+	// everything has been validated, the machine state can be replaced
+	zm.loadDynamicMemory(state.mem)
+
+	zm.stack = state.stack
+	zm.seq.pos = state.pc
+	zm.initHeader()
+
+	return nil
+}
+
+// readQuetzal reads and validates a form of type formType
+// written as writeQuetzal does
+func (zm *ZMachine) readQuetzal(r io.Reader, formType string) (state zsnapshot, err error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return state, err
+	}
+
+	if len(data) < 12 || string(data[:4]) != "FORM" || string(data[8:12]) != formType {
+		return state, quetzalFormError(formType)
+	}
+
+	// the form holds its type at least
+	formLen := binary.BigEndian.Uint32(data[4:8])
+	if formLen < 4 {
+		return state, quetzalFormError(formType)
+	}
+	if int(formLen)+8 > len(data) {
+		return state, errors.New("truncated quetzal save file")
+	}
+
+	chunks, err := readIFFChunks(data[12 : 8+formLen])
+	if err != nil {
+		return state, err
+	}
+
+	ifhd, ok := chunks["IFhd"]
+	if !ok || len(ifhd) < ifhdLen {
+		return state, errors.New("quetzal IFhd chunk missing")
+	}
+
+	if state.pc, err = zm.checkQuetzalIFhd(ifhd); err != nil {
+		return state, err
+	}
+
+	if cmem, ok := chunks["CMem"]; ok {
+		state.mem, err = quetzalUncompress(zm.original, cmem)
+		if err != nil {
+			return state, err
+		}
+	} else if umem, ok := chunks["UMem"]; ok {
+		if len(umem) != len(zm.original) {
+			return state, errors.New("quetzal UMem chunk has wrong size")
+		}
+		state.mem = umem
+	} else {
+		return state, errors.New("quetzal memory chunk missing")
+	}
+
+	stks, ok := chunks["Stks"]
+	if !ok {
+		return state, errors.New("quetzal Stks chunk missing")
+	}
+
+	if state.stack, err = readQuetzalStks(stks); err != nil {
+		return state, err
+	}
+
+	return state, nil
+}
+
+func quetzalFormError(formType string) error {
+	if formType == slotForm {
+		return errors.New("not a slot file")
+	}
+	return errors.New("not a quetzal save file")
+}
+
+func (zm *ZMachine) askFilename() (string, error) {
+	zm.screen.Print("Please enter a filename: ")
+	zm.render()
+
+	filename, _, err := zm.input.readLine(0)
+	return strings.TrimSpace(filename), err
+}
+
+func (zm *ZMachine) saveToFile(filename string) error {
+	path, err := zm.playerFile(filename)
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := zm.SaveQuetzal(buf); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+func (zm *ZMachine) restoreFromFile(filename string) error {
+	path, err := zm.playerFile(filename)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return zm.RestoreQuetzal(f)
+}
+
+func (zm *ZMachine) quetzalIFhd(pc uint32) []byte {
+	ifhd := make([]byte, ifhdLen)
+
+	binary.BigEndian.PutUint16(ifhd[0:], zm.header.release)
+	copy(ifhd[2:], zm.header.serial[:])
+	binary.BigEndian.PutUint16(ifhd[8:], zm.header.fileChecksum)
+	putUint24(ifhd[10:], pc)
+
+	return ifhd
+}
+
+func (zm *ZMachine) checkQuetzalIFhd(ifhd []byte) (uint32, error) {
+	if binary.BigEndian.Uint16(ifhd[0:]) != zm.header.release ||
+		!bytes.Equal(ifhd[2:8], zm.header.serial[:]) ||
+		binary.BigEndian.Uint16(ifhd[8:]) != zm.header.fileChecksum {
+		return 0, errors.New("save file was created by a different story")
+	}
+
+	return uint24(ifhd[10:]), nil
+}
+
+func quetzalStks(stack ZStack) ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	for _, routine := range stack {
+		if len(routine.locals) > maxLocals {
+			return nil, fmt.Errorf("routine at %X has too many locals", routine.addr)
+		}
+
+		frame := make([]byte, 8)
+
+		putUint24(frame[0:], routine.retAddr)
+
+		frame[3] = byte(len(routine.locals))
+		if routine.discard {
+			frame[3] |= 0x10
+		}
+
+		frame[4] = routine.storeVar
+
+		// bit #n is set if argument #n+1 has been supplied
+		frame[5] = byte(1<<routine.argCount - 1)
+
+		binary.BigEndian.PutUint16(frame[6:], uint16(len(routine.stack)))
+
+		buf.Write(frame)
+		for _, v := range routine.locals {
+			binary.Write(buf, binary.BigEndian, v)
+		}
+		for _, v := range routine.stack {
+			binary.Write(buf, binary.BigEndian, v)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func readQuetzalStks(stks []byte) (ZStack, error) {
+	stack := ZStack{}
+
+	readWords := func(n int) ([]uint16, error) {
+		if len(stks) < n*2 {
+			return nil, errors.New("truncated quetzal stack frame")
+		}
+
+		ret := make([]uint16, n)
+		for i := range ret {
+			ret[i] = binary.BigEndian.Uint16(stks[i*2:])
+		}
+		stks = stks[n*2:]
+
+		return ret, nil
+	}
+
+	for len(stks) > 0 {
+		if len(stks) < 8 {
+			return nil, errors.New("truncated quetzal stack frame")
+		}
+
+		routine := new(ZRoutine)
+
+		routine.retAddr = uint24(stks[0:])
+		routine.discard = stks[3]&0x10 != 0
+		routine.storeVar = stks[4]
+
+		for args := stks[5]; args&0x01 != 0; args >>= 1 {
+			routine.argCount++
+		}
+
+		numLocals := int(stks[3] & 0x0F)
+		evalCount := int(binary.BigEndian.Uint16(stks[6:]))
+		stks = stks[8:]
+
+		var err error
+		if routine.locals, err = readWords(numLocals); err != nil {
+			return nil, err
+		}
+		if routine.stack, err = readWords(evalCount); err != nil {
+			return nil, err
+		}
+
+		stack.Push(routine)
+	}
+
+	if len(stack) == 0 {
+		return nil, errors.New("quetzal stack is empty")
+	}
+
+	return stack, nil
+}
+
+// quetzalCompress xors current with original and encodes runs of zeros
+// as a zero byte followed by the length of the run minus 1
+func quetzalCompress(original, current []byte) []byte {
+	ret := []byte{}
+
+	zeros := 0
+	for i := range current {
+		b := current[i] ^ original[i]
+
+		if b == 0 {
+			zeros++
+			continue
+		}
+
+		for zeros > 0 {
+			run := zeros
+			if run > 256 {
+				run = 256
+			}
+			ret = append(ret, 0, byte(run-1))
+			zeros -= run
+		}
+		ret = append(ret, b)
+	}
+
+	// trailing zeros are implicit
+	return ret
+}
+
+func quetzalUncompress(original, cmem []byte) ([]byte, error) {
+	ret := make([]byte, len(original))
+	copy(ret, original)
+
+	pos := 0
+	for i := 0; i < len(cmem); i++ {
+		if cmem[i] == 0 {
+			i++
+			if i >= len(cmem) {
+				return nil, errors.New("quetzal CMem chunk ends inside a run")
+			}
+			pos += int(cmem[i]) + 1
+			continue
+		}
+
+		if pos >= len(ret) {
+			return nil, errors.New("quetzal CMem chunk is bigger than dynamic memory")
+		}
+		ret[pos] ^= cmem[i]
+		pos++
+	}
+
+	if pos > len(ret) {
+		return nil, errors.New("quetzal CMem chunk is bigger than dynamic memory")
+	}
+
+	return ret, nil
+}
+
+func writeIFFChunk(buf *bytes.Buffer, id string, data []byte) {
+	buf.WriteString(id)
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+
+	// chunks are padded to an even length
+	if len(data)%2 != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+func readIFFChunks(data []byte) (map[string][]byte, error) {
+	chunks := make(map[string][]byte)
+
+	for len(data) >= 8 {
+		id := string(data[:4])
+		size := binary.BigEndian.Uint32(data[4:8])
+		data = data[8:]
+
+		if uint64(size) > uint64(len(data)) {
+			return nil, fmt.Errorf("truncated %s chunk", id)
+		}
+
+		chunks[id] = data[:size]
+
+		if size%2 != 0 && size < uint32(len(data)) {
+			size++
+		}
+		data = data[size:]
+	}
+
+	return chunks, nil
+}
+
+func putUint24(buf []byte, v uint32) {
+	buf[0] = byte(v >> 16)
+	buf[1] = byte(v >> 8)
+	buf[2] = byte(v)
+}
+
+func uint24(buf []byte) uint32 {
+	return uint32(buf[0])<<16 | uint32(buf[1])<<8 | uint32(buf[2])
+}
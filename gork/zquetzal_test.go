@@ -0,0 +1,199 @@
+package gork
+
+import (
+	"bytes"
+	"testing"
+)
+
+var quetzalOriginal []byte = make([]byte, 600)
+
+var quetzalCurrent [][]byte = [][]byte{
+	// untouched memory
+	make([]byte, 600),
+	// a change at the very beginning
+	append([]byte{42}, make([]byte, 599)...),
+	// a change after a run longer than 256 zeros
+	append(make([]byte, 599), 73),
+}
+
+var quetzalCompressed [][]byte = [][]byte{
+	[]byte{},
+	[]byte{42},
+	[]byte{0, 255, 0, 255, 0, 86, 73},
+}
+
+func TestQuetzalCompress(t *testing.T) {
+	for i, current := range quetzalCurrent {
+		cmem := quetzalCompress(quetzalOriginal, current)
+
+		if !bytes.Equal(cmem, quetzalCompressed[i]) {
+			t.Fail()
+		}
+
+		uncompressed, err := quetzalUncompress(quetzalOriginal, cmem)
+		if err != nil || !bytes.Equal(uncompressed, current) {
+			t.Fail()
+		}
+	}
+}
+
+func TestQuetzalUncompressOverflow(t *testing.T) {
+	if _, err := quetzalUncompress(make([]byte, 2), []byte{1, 2, 3}); err == nil {
+		t.Fail()
+	}
+
+	if _, err := quetzalUncompress(make([]byte, 2), []byte{0}); err == nil {
+		t.Fail()
+	}
+}
+
+func TestQuetzalStks(t *testing.T) {
+	zm := &ZMachine{
+		stack: ZStack{
+			&ZRoutine{locals: []uint16{}, stack: []uint16{7}},
+			&ZRoutine{
+				retAddr:  4,
+				storeVar: 0x10,
+				argCount: 1,
+				locals:   []uint16{42, 73},
+				stack:    []uint16{96},
+			},
+			&ZRoutine{
+				retAddr: 42,
+				discard: true,
+				locals:  []uint16{},
+				stack:   []uint16{},
+			},
+		},
+	}
+
+	stks, err := quetzalStks(zm.stack)
+	if err != nil {
+		t.Fail()
+	}
+
+	stack, err := readQuetzalStks(stks)
+	if err != nil || len(stack) != len(zm.stack) {
+		t.FailNow()
+	}
+
+	for i, routine := range stack {
+		expected := zm.stack[i]
+
+		if routine.retAddr != expected.retAddr ||
+			routine.storeVar != expected.storeVar ||
+			routine.discard != expected.discard ||
+			routine.argCount != expected.argCount ||
+			len(routine.locals) != len(expected.locals) ||
+			len(routine.stack) != len(expected.stack) {
+			t.FailNow()
+		}
+
+		for j := range routine.locals {
+			if routine.locals[j] != expected.locals[j] {
+				t.Fail()
+			}
+		}
+
+		for j := range routine.stack {
+			if routine.stack[j] != expected.stack[j] {
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestIFFChunks(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writeIFFChunk(buf, "IFhd", []byte{1, 2, 3})
+	writeIFFChunk(buf, "CMem", []byte{4, 5})
+
+	// odd chunks are padded
+	if buf.Len() != 8+4+8+2 {
+		t.Fail()
+	}
+
+	chunks, err := readIFFChunks(buf.Bytes())
+	if err != nil ||
+		!bytes.Equal(chunks["IFhd"], []byte{1, 2, 3}) ||
+		!bytes.Equal(chunks["CMem"], []byte{4, 5}) {
+		t.Fail()
+	}
+}
+
+func TestQuetzalShortForm(t *testing.T) {
+	zm := &ZMachine{header: &ZHeader{}}
+	for _, formLen := range []byte{0, 3} {
+		data := append([]byte("FORM\x00\x00\x00"), formLen)
+		data = append(data, "IFZS"...)
+		if zm.RestoreQuetzal(bytes.NewReader(data)) == nil {
+			t.Fail()
+		}
+	}
+}
+
+func newQuetzalZMachine(t *testing.T, version byte, code []byte) (*ZMachine, []byte) {
+	buf := make([]byte, 0x80)
+	copy(buf[0x40:], code)
+	mem := *NewZMemory(buf)
+
+	dev := &scriptedIODev{lines: []string{"game", "game"}}
+	zm := &ZMachine{
+		header:   &ZHeader{version: version, dynMemSize: 0x80, globalsPos: 0x60},
+		seq:      mem.GetSequential(0x40),
+		stack:    ZStack{&ZRoutine{locals: []uint16{}}},
+		iodev:    dev,
+		input:    newZInput(dev),
+		screen:   NewZScreen(80, 25),
+		logger:   nullLogger{},
+		original: make([]byte, 0x80),
+	}
+	SaveDir(t.TempDir())(zm)
+
+	return zm, buf
+}
+
+func TestQuetzalSaveRestoreBranch(t *testing.T) {
+	zm, buf := newQuetzalZMachine(t, 3, []byte{
+		// save ?(0x43), quit
+		0xB5, 0xC3, 0xBA,
+		// restore ?(0x46), quit, quit
+		0xB6, 0xC3, 0xBA, 0xBA,
+	})
+
+	if zm.Interpret() != nil || zm.seq.pos != 0x43 {
+		t.FailNow()
+	}
+
+	buf[0x30] = 9
+	zm.stack.Top().push(42)
+
+	// the restore goes on as the save did
+	if zm.Interpret() != nil || zm.seq.pos != 0x43 {
+		t.FailNow()
+	}
+	if buf[0x30] != 0 || len(zm.stack.Top().stack) != 0 {
+		t.Fail()
+	}
+}
+
+func TestQuetzalSaveRestoreStore(t *testing.T) {
+	zm, buf := newQuetzalZMachine(t, 4, []byte{
+		// save -> g0, restore -> g1
+		0xB5, 0x10, 0xB6, 0x11,
+	})
+
+	if zm.Interpret() != nil || zm.seq.pos != 0x42 || buf[0x61] != 1 {
+		t.FailNow()
+	}
+
+	buf[0x30] = 9
+
+	// 2 is stored to the variable of the save
+	if zm.Interpret() != nil || zm.seq.pos != 0x42 {
+		t.FailNow()
+	}
+	if buf[0x30] != 0 || buf[0x61] != 2 || buf[0x63] != 0 {
+		t.Fail()
+	}
+}
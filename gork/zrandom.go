@@ -0,0 +1,79 @@
+package gork
+
+import "time"
+
+// seeds below it select the predictable mode, see zrandom
+const predictableSeeds = 1000
+
+// zrandom generates the numbers of the random opcode, it's a xorshift
+// generator so its whole state can be copied into snapshots
+// the story selects one of 3 modes:
+//   - random, seeded with the time (or the seed given by the player)
+//   - predictable, it counts 1, 2, ..., S, 1, 2, ... with S < 1000
+//   - seeded, the numbers depend only on the seed S >= 1000
+type zrandom struct {
+	state uint64
+	// S in predictable mode, 0 otherwise
+	limit uint16
+	count uint16
+	// seed chosen by the player, it replaces the time in random mode
+	fixed int64
+}
+
+// RandomSeed makes the random numbers reproducible: seeds below 1000
+// count 1, 2, ..., seed as the predictable mode of the random opcode
+// does, the others seed the generator, 0 keeps them random
+func RandomSeed(seed int64) ZOption {
+	return func(zm *ZMachine) {
+		zm.random.fixed = seed
+		zm.random.randomize()
+	}
+}
+
+// seed selects the predictable mode or the seeded one, see zrandom
+func (r *zrandom) seed(seed int64) {
+	if seed > 0 && seed < predictableSeeds {
+		r.limit, r.count = uint16(seed), 0
+		return
+	}
+
+	r.limit = 0
+
+	// splitmix64 spreads close seeds apart, xorshift must not start at 0
+	z := uint64(seed) + 0x9E3779B97F4A7C15
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	r.state = z ^ (z >> 31)
+	if r.state == 0 {
+		r.state = 1
+	}
+}
+
+// randomize selects the random mode
+func (r *zrandom) randomize() {
+	if r.fixed != 0 {
+		r.seed(r.fixed)
+	} else {
+		r.seed(time.Now().UnixNano())
+	}
+}
+
+// next returns a number between 1 and n
+func (r *zrandom) next(n uint16) uint16 {
+	if r.limit > 0 {
+		r.count = r.count%r.limit + 1
+		return (r.count-1)%n + 1
+	}
+
+	if r.state == 0 {
+		r.randomize()
+	}
+
+	// xorshift64*
+	r.state ^= r.state >> 12
+	r.state ^= r.state << 25
+	r.state ^= r.state >> 27
+	v := r.state * 0x2545F4914F6CDD1D
+
+	return uint16((v>>32)%uint64(n)) + 1
+}
@@ -0,0 +1,78 @@
+package gork
+
+import "testing"
+
+func TestZRandomPredictable(t *testing.T) {
+	r := zrandom{}
+	r.seed(3)
+
+	expected := []uint16{1, 2, 3, 1, 2, 3, 1}
+	for _, e := range expected {
+		if r.next(10) != e {
+			t.Fail()
+		}
+	}
+
+	// numbers are still in range
+	if r.next(1) != 1 {
+		t.Fail()
+	}
+}
+
+func TestZRandomSeeded(t *testing.T) {
+	a, b := zrandom{}, zrandom{}
+	a.seed(12345)
+	b.seed(12345)
+
+	for i := 0; i < 100; i++ {
+		n := a.next(6)
+		if n != b.next(6) || n < 1 || n > 6 {
+			t.FailNow()
+		}
+	}
+
+	// the player's seed survives random(0)
+	a.fixed, b.fixed = 4242, 4242
+	a.next(6)
+	a.randomize()
+	b.randomize()
+	if a.next(1000) != b.next(1000) {
+		t.Fail()
+	}
+}
+
+func TestZMachineRandom(t *testing.T) {
+	mem := *NewZMemory(make([]byte, 0x80))
+	zm := newMetaZMachine(mem)
+	RandomSeed(7)(zm)
+
+	// store the result to the stack
+	zm.op = &ZOp{store: 0}
+	random := func(n int16) uint16 {
+		ZRandom(zm, []uint16{uint16(n)})
+		v, _ := zm.stack.Top().pop()
+		return v
+	}
+
+	if random(100) != 1 || random(100) != 2 {
+		t.FailNow()
+	}
+
+	// seeded mode
+	random(-5000)
+	first := random(1000)
+
+	if random(-5000) != 0 || random(1000) != first {
+		t.FailNow()
+	}
+
+	// the generator is part of snapshots
+	zm.snapshotTurn(0x10)
+	second := random(1000)
+	zm.snapshotTurn(0x20)
+	zm.metaCommand("#undo", 0x20)
+
+	if random(1000) != second {
+		t.Fail()
+	}
+}
@@ -1,20 +1,34 @@
 package gork
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"strings"
 )
 
 // aka StackFrame
 type ZRoutine struct {
-	addr    uint32
+	addr uint32
+	// address of the instruction following the call
 	retAddr uint32
-	locals  []uint16
+	// variable the return value is stored to, unless discarded
+	storeVar byte
+	discard  bool
+	// number of arguments supplied by the caller
+	argCount byte
+	locals   []uint16
+	// evaluation stack
+	stack []uint16
 }
 
-func NewZRoutine(seq *ZMemorySequential, retAddr uint32) *ZRoutine {
-	if !IsPackedAddress(seq.pos) {
-		log.Fatal("attempt to read routine at non packed address")
+// max number of locals a routine can have
+const maxLocals = 15
+
+var errStackUnderflow = errors.New("evaluation stack underflow")
+
+func NewZRoutine(seq *ZMemorySequential, retAddr uint32, header *ZHeader) (*ZRoutine, error) {
+	if !header.IsPackedAddress(seq.pos) {
+		return nil, errors.New("attempt to read routine at non packed address")
 	}
 
 	routine := new(ZRoutine)
@@ -23,30 +37,72 @@ func NewZRoutine(seq *ZMemorySequential, retAddr uint32) *ZRoutine {
 	routine.addr = seq.pos
 	numLocals := seq.ReadByte()
 
+	if numLocals > maxLocals {
+		return nil, fmt.Errorf("routine at %X has %d locals", routine.addr, numLocals)
+	}
+
 	routine.locals = make([]uint16, numLocals)
 
-	for i := byte(0); i < numLocals; i++ {
-		routine.locals[i] = seq.ReadWord()
+	// v5 locals start at 0 and their initial values are not stored
+	if header.version <= 4 {
+		for i := byte(0); i < numLocals; i++ {
+			routine.locals[i] = seq.ReadWord()
+		}
 	}
 
-	return routine
+	return routine, nil
 }
 
 func MainRoutine(mem *ZMemory, header *ZHeader) *ZRoutine {
-	return NewZRoutine(mem.GetSequential(PackedAddress(uint32(header.pc))), 0)
+	// v3 execution starts at an instruction, not at a routine header,
+	// so the main routine is a dummy frame without locals
+	return &ZRoutine{
+		addr:   uint32(header.pc),
+		locals: []uint16{},
+	}
 }
 
-func (routine *ZRoutine) String() string {
-	ret := fmt.Sprintf("Routine at %X Locals: [", routine.addr)
+func (routine *ZRoutine) push(val uint16) {
+	routine.stack = append(routine.stack, val)
+}
+
+func (routine *ZRoutine) pop() (uint16, error) {
+	last := len(routine.stack) - 1
+	if last < 0 {
+		return 0, errStackUnderflow
+	}
 
-	tmp := ""
-	if len(routine.locals) > 0 {
-		for _, local := range routine.locals {
-			tmp += fmt.Sprintf("%X, ", local)
+	val := routine.stack[last]
+	routine.stack = routine.stack[:last]
+	return val, nil
+}
+
+// top returns the address of the value on top of the evaluation stack
+// so that it can be read or written in place
+func (routine *ZRoutine) top() (*uint16, error) {
+	if len(routine.stack) == 0 {
+		return nil, errStackUnderflow
+	}
+	return &routine.stack[len(routine.stack)-1], nil
+}
+
+// local returns the address of the local variable #n (1-based)
+func (routine *ZRoutine) local(n byte) (*uint16, error) {
+	if n < 1 || int(n) > len(routine.locals) {
+		return nil, fmt.Errorf("routine at %X has no local variable %d", routine.addr, n)
+	}
+	return &routine.locals[n-1], nil
+}
+
+func (routine *ZRoutine) String() string {
+	hex := func(values []uint16) string {
+		tmp := make([]string, len(values))
+		for i, v := range values {
+			tmp[i] = fmt.Sprintf("%X", v)
 		}
-		ret += tmp[:len(tmp)-2]
+		return strings.Join(tmp, ", ")
 	}
-	ret += "]\n"
 
-	return ret
+	return fmt.Sprintf("Routine at %X Locals: [%s] Stack: [%s]\n",
+		routine.addr, hex(routine.locals), hex(routine.stack))
 }
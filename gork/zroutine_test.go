@@ -32,7 +32,10 @@ func TestZRoutine(t *testing.T) {
 	for i, buf := range zroutineBuf {
 		mem := NewZMemory(buf)
 
-		routine := NewZRoutine(mem.GetSequential(0), 42)
+		routine, err := NewZRoutine(mem.GetSequential(0), 42, &ZHeader{version: 3})
+		if err != nil {
+			t.FailNow()
+		}
 		expected := zroutineExpected[i]
 
 		if expected.addr != routine.addr ||
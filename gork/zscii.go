@@ -0,0 +1,115 @@
+package gork
+
+// zcharset holds the alphabets and the unicode translation table,
+// v5 stories can replace both of them with their own
+type zcharset struct {
+	alphabets [3]string
+	// unicode characters of ZSCII codes from 155 onwards
+	unicode []rune
+}
+
+const firstExtraZSCII = 155
+
+var defaultCharset = &zcharset{
+	alphabets: Alphabets,
+	unicode: []rune("äöüÄÖÜß»«ëïÿËÏáéíóúýÁÉÍÓÚÝàèìòùÀÈÌÒÙ" +
+		"âêîôûÂÊÎÔÛåÅøØãñõÃÑÕæÆçÇþðÞÐ£œŒ¡¿"),
+}
+
+// v1 A2 has no newline and it has '<' instead
+var charsetV1 = &zcharset{
+	alphabets: [3]string{
+		Alphabets[0],
+		Alphabets[1],
+		" 0123456789.,!?_#'\"/\\<-:()",
+	},
+	unicode: defaultCharset.unicode,
+}
+
+// loadCharset reads the custom alphabet and unicode tables,
+// it returns nil if the story uses the default ones
+func loadCharset(mem *ZMemory, alphabetTblPos uint16, unicodeTblPos uint16) *zcharset {
+	if alphabetTblPos == 0 && unicodeTblPos == 0 {
+		return nil
+	}
+
+	charset := &zcharset{
+		alphabets: defaultCharset.alphabets,
+		unicode:   defaultCharset.unicode,
+	}
+
+	if alphabetTblPos != 0 {
+		// 3 alphabets of 26 ZSCII codes each
+		for i := range charset.alphabets {
+			start := uint32(alphabetTblPos) + uint32(i)*26
+			alphabet := mem.Slice(start, start+26)
+			charset.alphabets[i] = string(alphabet)
+		}
+
+		// A2 #0 is the ZSCII escape and A2 #1 is always a newline
+		a2 := []byte(charset.alphabets[2])
+		a2[0], a2[1] = ' ', '\n'
+		charset.alphabets[2] = string(a2)
+	}
+
+	if unicodeTblPos != 0 {
+		seq := mem.GetSequential(uint32(unicodeTblPos))
+		n := seq.ReadByte()
+
+		charset.unicode = make([]rune, n)
+		for i := range charset.unicode {
+			charset.unicode[i] = rune(seq.ReadWord())
+		}
+	}
+
+	return charset
+}
+
+func (header *ZHeader) charset() *zcharset {
+	switch {
+	case header == nil:
+		return defaultCharset
+	case header.customCharset != nil:
+		return header.customCharset
+	case header.version == 1:
+		return charsetV1
+	}
+	return defaultCharset
+}
+
+// ZSCIIToRune converts an output ZSCII code to unicode,
+// codes that cannot be printed become '?'
+func (header *ZHeader) ZSCIIToRune(c uint16) rune {
+	switch {
+	case c == 0:
+		return 0
+	case c == 13 || c == '\n':
+		return '\n'
+	case c >= 32 && c <= 126:
+		return rune(c)
+	case c >= firstExtraZSCII:
+		table := header.charset().unicode
+		if int(c-firstExtraZSCII) < len(table) {
+			return table[c-firstExtraZSCII]
+		}
+	}
+	return '?'
+}
+
+// RuneToZSCII is the inverse of ZSCIIToRune, ok is false if r
+// has no ZSCII code
+func (header *ZHeader) RuneToZSCII(r rune) (c uint16, ok bool) {
+	switch {
+	case r == '\n':
+		return 13, true
+	case r >= 32 && r <= 126:
+		return uint16(r), true
+	}
+
+	for i, u := range header.charset().unicode {
+		if u == r {
+			return uint16(firstExtraZSCII + i), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,294 @@
+package gork
+
+import "strings"
+
+// text styles, they can be combined
+const (
+	StyleRoman   = uint16(0x00)
+	StyleReverse = uint16(0x01)
+	StyleBold    = uint16(0x02)
+	StyleItalic  = uint16(0x04)
+	StyleFixed   = uint16(0x08)
+)
+
+const (
+	LowerWindow = 0
+	UpperWindow = 1
+)
+
+// ZCell is a character of the upper window
+type ZCell struct {
+	Char  rune
+	Style uint16
+}
+
+// ZSpan is text printed to the lower window with the same style
+type ZSpan struct {
+	Text  string
+	Style uint16
+}
+
+// ZScreen is a headless model of the screen, the story writes to it
+// and frontends render it
+// the upper window is a grid of Width columns addressed by the cursor,
+// the lower window scrolls so only the text not yet rendered is kept,
+// v1-3 stories have a status line above the upper window
+type ZScreen struct {
+	Width  int
+	Height int
+	// v4 buffering of the lower window, frontends may use it to wrap text
+	BufferMode bool
+
+	window int
+	style  uint16
+	// upper window cursor, 1-based
+	cursorLine   int
+	cursorColumn int
+
+	status     []ZCell
+	upper      [][]ZCell
+	upperDirty bool
+
+	lower        []ZSpan
+	lowerCleared bool
+}
+
+func NewZScreen(width int, height int) *ZScreen {
+	screen := &ZScreen{Width: width, Height: height}
+	screen.Reset()
+	return screen
+}
+
+// Reset unsplits the screen and clears it
+func (screen *ZScreen) Reset() {
+	screen.BufferMode = true
+	screen.window = LowerWindow
+	screen.style = StyleRoman
+	screen.cursorLine, screen.cursorColumn = 1, 1
+	// frontends must know the screen has been unsplit
+	screen.upperDirty = len(screen.upper) > 0 || screen.status != nil
+	screen.status = nil
+	screen.upper = nil
+	screen.lower = nil
+	screen.lowerCleared = false
+}
+
+// Split makes the upper window lines long, v3 clears it too
+func (screen *ZScreen) Split(lines int, version byte) {
+	if max := screen.Height - len(screen.statusRows()); lines > max {
+		lines = max
+	}
+
+	upper := make([][]ZCell, lines)
+	for i := range upper {
+		if i < len(screen.upper) && version > 3 {
+			upper[i] = screen.upper[i]
+		} else {
+			upper[i] = screen.blankLine()
+		}
+	}
+	screen.upper = upper
+	screen.upperDirty = true
+
+	if screen.cursorLine > lines {
+		screen.cursorLine, screen.cursorColumn = 1, 1
+	}
+	if lines == 0 {
+		screen.window = LowerWindow
+	}
+}
+
+// SelectWindow selects the window text is printed to,
+// selecting the upper one moves the cursor to its top left corner
+func (screen *ZScreen) SelectWindow(window int) {
+	screen.window = window
+	if window == UpperWindow {
+		screen.cursorLine, screen.cursorColumn = 1, 1
+	}
+}
+
+func (screen *ZScreen) Window() int {
+	return screen.window
+}
+
+// Erase clears window, -1 unsplits the screen and clears
+// it and -2 clears it without unsplitting
+func (screen *ZScreen) Erase(window int) {
+	switch window {
+	case -1:
+		screen.Split(0, 0)
+		screen.window = LowerWindow
+		fallthrough
+	case -2:
+		screen.eraseUpper()
+		screen.eraseLower()
+	case LowerWindow:
+		screen.eraseLower()
+	case UpperWindow:
+		screen.eraseUpper()
+	}
+}
+
+func (screen *ZScreen) eraseUpper() {
+	for i := range screen.upper {
+		screen.upper[i] = screen.blankLine()
+	}
+	screen.cursorLine, screen.cursorColumn = 1, 1
+	screen.upperDirty = true
+}
+
+func (screen *ZScreen) eraseLower() {
+	screen.lower = nil
+	screen.lowerCleared = true
+}
+
+// EraseLine clears the upper window from the cursor to the end of the line
+func (screen *ZScreen) EraseLine() {
+	if screen.window != UpperWindow || screen.cursorLine > len(screen.upper) {
+		return
+	}
+
+	line := screen.upper[screen.cursorLine-1]
+	for i := screen.cursorColumn - 1; i >= 0 && i < len(line); i++ {
+		line[i] = ZCell{' ', StyleRoman}
+	}
+	screen.upperDirty = true
+}
+
+// SetCursor moves the cursor of the upper window, it's ignored
+// when the lower one is selected
+func (screen *ZScreen) SetCursor(line int, column int) {
+	if screen.window != UpperWindow {
+		return
+	}
+
+	if line < 1 {
+		line = 1
+	}
+	if column < 1 {
+		column = 1
+	}
+	screen.cursorLine, screen.cursorColumn = line, column
+}
+
+// Cursor returns the position of the cursor of the upper window
+func (screen *ZScreen) Cursor() (line int, column int) {
+	return screen.cursorLine, screen.cursorColumn
+}
+
+// SetStyle combines style with the current one, roman resets it
+func (screen *ZScreen) SetStyle(style uint16) {
+	if style == StyleRoman {
+		screen.style = StyleRoman
+	} else {
+		screen.style |= style
+	}
+}
+
+func (screen *ZScreen) Style() uint16 {
+	return screen.style
+}
+
+// Print writes text to the selected window
+func (screen *ZScreen) Print(text string) {
+	if text == "" {
+		return
+	}
+
+	if screen.window == LowerWindow {
+		last := len(screen.lower) - 1
+		if last >= 0 && screen.lower[last].Style == screen.style {
+			screen.lower[last].Text += text
+		} else {
+			screen.lower = append(screen.lower, ZSpan{text, screen.style})
+		}
+		return
+	}
+
+	for _, r := range text {
+		if r == '\n' {
+			screen.cursorLine++
+			screen.cursorColumn = 1
+			continue
+		}
+
+		// text past the end of the upper window is lost
+		if screen.cursorLine <= len(screen.upper) && screen.cursorColumn <= screen.Width {
+			screen.upper[screen.cursorLine-1][screen.cursorColumn-1] = ZCell{r, screen.style}
+			screen.upperDirty = true
+		}
+		screen.cursorColumn++
+	}
+}
+
+// SetStatus draws the status line in reverse video, it's cut at Width
+func (screen *ZScreen) SetStatus(text string) {
+	line := screen.blankLine()
+	for i := range line {
+		line[i].Style = StyleReverse
+	}
+
+	column := 0
+	for _, r := range text {
+		if column >= len(line) {
+			break
+		}
+		line[column].Char = r
+		column++
+	}
+
+	screen.status = line
+	screen.upperDirty = true
+}
+
+// UpperLines returns the size of the upper window
+func (screen *ZScreen) UpperLines() int {
+	return len(screen.upper)
+}
+
+// Upper returns the cells of the upper window, preceded by the status
+// line if any, and whether they changed since the last call
+func (screen *ZScreen) Upper() (cells [][]ZCell, changed bool) {
+	changed = screen.upperDirty
+	screen.upperDirty = false
+	return append(screen.statusRows(), screen.upper...), changed
+}
+
+// UpperText returns the rows of Upper as plain text, without
+// the trailing spaces of each line
+func (screen *ZScreen) UpperText() []string {
+	rows := append(screen.statusRows(), screen.upper...)
+	ret := make([]string, len(rows))
+	for i, line := range rows {
+		runes := make([]rune, len(line))
+		for j, cell := range line {
+			runes[j] = cell.Char
+		}
+		ret[i] = strings.TrimRight(string(runes), " ")
+	}
+	return ret
+}
+
+// TakeLower returns the text printed to the lower window since the
+// last call, cleared tells if the window has been erased meanwhile
+func (screen *ZScreen) TakeLower() (spans []ZSpan, cleared bool) {
+	spans, cleared = screen.lower, screen.lowerCleared
+	screen.lower = nil
+	screen.lowerCleared = false
+	return spans, cleared
+}
+
+func (screen *ZScreen) statusRows() [][]ZCell {
+	if screen.status == nil {
+		return nil
+	}
+	return [][]ZCell{screen.status}
+}
+
+func (screen *ZScreen) blankLine() []ZCell {
+	line := make([]ZCell, screen.Width)
+	for i := range line {
+		line[i] = ZCell{' ', StyleRoman}
+	}
+	return line
+}
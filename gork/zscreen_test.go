@@ -0,0 +1,138 @@
+package gork
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestZScreenUpperWindow(t *testing.T) {
+	screen := NewZScreen(10, 5)
+
+	screen.Split(2, 4)
+	screen.SelectWindow(UpperWindow)
+	screen.Print("Score")
+	screen.SetCursor(2, 4)
+	screen.SetStyle(StyleReverse)
+	screen.Print("abcdefghij")
+
+	upper := screen.UpperText()
+	if len(upper) != 2 || upper[0] != "Score" || upper[1] != "   abcdefg" {
+		t.Fail()
+	}
+
+	cells, changed := screen.Upper()
+	if !changed || cells[1][3].Style != StyleReverse || cells[0][0].Style != StyleRoman {
+		t.Fail()
+	}
+
+	if _, changed := screen.Upper(); changed {
+		t.Fail()
+	}
+
+	// the lower window has no cursor
+	screen.SelectWindow(LowerWindow)
+	screen.SetCursor(1, 1)
+	if line, column := screen.Cursor(); line != 2 || column != 14 {
+		t.Fail()
+	}
+
+	// v4 resizing keeps the content, v3 clears it
+	screen.Split(3, 4)
+	if screen.UpperText()[0] != "Score" {
+		t.Fail()
+	}
+
+	screen.Split(3, 3)
+	if screen.UpperText()[0] != "" {
+		t.Fail()
+	}
+}
+
+func TestZScreenLowerWindow(t *testing.T) {
+	screen := NewZScreen(10, 5)
+
+	screen.Print("West of ")
+	screen.Print("House\n")
+	screen.SetStyle(StyleBold)
+	screen.SetStyle(StyleItalic)
+	screen.Print("You are")
+	screen.SetStyle(StyleRoman)
+	screen.Print(" here")
+
+	spans, cleared := screen.TakeLower()
+	if cleared || len(spans) != 3 ||
+		spans[0] != (ZSpan{"West of House\n", StyleRoman}) ||
+		spans[1] != (ZSpan{"You are", StyleBold | StyleItalic}) ||
+		spans[2] != (ZSpan{" here", StyleRoman}) {
+		t.Fail()
+	}
+
+	if spans, _ := screen.TakeLower(); len(spans) != 0 {
+		t.Fail()
+	}
+
+	screen.Split(1, 5)
+	screen.SelectWindow(UpperWindow)
+	screen.Print("upper")
+	screen.Print("lost")
+	screen.Erase(-1)
+
+	spans, cleared = screen.TakeLower()
+	if !cleared || len(spans) != 0 || screen.UpperLines() != 0 || screen.Window() != LowerWindow {
+		t.Fail()
+	}
+}
+
+func TestRenderANSI(t *testing.T) {
+	screen := NewZScreen(10, 5)
+
+	screen.Split(1, 4)
+	screen.SelectWindow(UpperWindow)
+	screen.Print("top")
+	screen.SelectWindow(LowerWindow)
+	screen.SetStyle(StyleBold)
+	screen.Print("a\nb")
+
+	out := renderANSI(screen, "\r\n")
+
+	// scroll region below the upper window, then the text at the bottom
+	if !strings.HasPrefix(out, "\x1b[2;5r\x1b[1;1H") ||
+		!strings.HasSuffix(out, "\x1b[5;1H\x1b[0;1ma\r\nb\x1b[0m") {
+		t.Fail()
+	}
+
+	if renderANSI(screen, "\n") != "" {
+		t.Fail()
+	}
+}
+
+func TestZScreenStatus(t *testing.T) {
+	screen := NewZScreen(10, 5)
+
+	screen.SetStatus(" Room    12345")
+	screen.Split(1, 3)
+	screen.SelectWindow(UpperWindow)
+	screen.Print("top")
+
+	// the status line is above the upper window, cut at the width
+	upper, changed := screen.Upper()
+	if !changed || len(upper) != 2 || screen.UpperLines() != 1 ||
+		upper[0][0].Style != StyleReverse || upper[0][9].Char != '1' {
+		t.FailNow()
+	}
+	if text := screen.UpperText(); text[0] != " Room    1" || text[1] != "top" {
+		t.Fail()
+	}
+
+	// and the scroll region starts below both
+	screen.SetStatus("")
+	if !strings.HasPrefix(renderANSI(screen, "\n"), "\x1b[3;5r\x1b[1;1H\x1b[0;7m ") {
+		t.Fail()
+	}
+
+	// the status line leaves room for one line less
+	screen.Split(5, 3)
+	if screen.UpperLines() != 4 {
+		t.Fail()
+	}
+}
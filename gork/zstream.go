@@ -0,0 +1,243 @@
+package gork
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// stream 3 can be nested up to 16 times
+const maxMemoryStreams = 16
+
+const (
+	ScreenStream     = 1
+	TranscriptStream = 2
+	MemoryStream     = 3
+	CommandsStream   = 4
+)
+
+// zmemoryStream is a table output is redirected to
+type zmemoryStream struct {
+	table uint32
+	// number of chars written so far
+	length uint16
+}
+
+// zstreams routes the text printed by the story
+type zstreams struct {
+	screen bool
+
+	// kept open once created, Flags 2 bit 0 tells if it's selected
+	transcript   io.WriteCloser
+	transcribing bool
+
+	memory []zmemoryStream
+
+	commands io.WriteCloser
+}
+
+// print sends text to the output streams, stream 3
+// takes it all when it's selected
+func (zm *ZMachine) print(a ...interface{}) {
+	text := fmt.Sprint(a...)
+
+	if len(zm.streams.memory) > 0 {
+		zm.printToMemory(text)
+		return
+	}
+
+	if zm.streams.screen {
+		zm.screen.Print(text)
+	}
+
+	// the transcript is a copy of the lower window
+	if zm.syncTranscript() && zm.screen.Window() == LowerWindow {
+		zm.writeTranscript(text)
+	}
+}
+
+// printToMemory writes text to the innermost stream 3 table
+func (zm *ZMachine) printToMemory(text string) {
+	stream := &zm.streams.memory[len(zm.streams.memory)-1]
+
+	for _, r := range text {
+		c, ok := zm.header.RuneToZSCII(r)
+		if !ok {
+			c = '?'
+		}
+
+		zm.seq.mem.WriteByteAt(stream.table+2+uint32(stream.length), byte(c))
+		stream.length++
+	}
+}
+
+// openMemoryStream selects stream 3 redirecting output to table
+func (zm *ZMachine) openMemoryStream(table uint32) {
+	if len(zm.streams.memory) >= maxMemoryStreams {
+		zm.faultf("output stream 3 nested more than %d times", maxMemoryStreams)
+		return
+	}
+	zm.streams.memory = append(zm.streams.memory, zmemoryStream{table: table})
+}
+
+// closeMemoryStream deselects the innermost stream 3 storing
+// the number of chars written in the first word of its table
+func (zm *ZMachine) closeMemoryStream() {
+	last := len(zm.streams.memory) - 1
+	if last < 0 {
+		// nothing to close, stories do that
+		return
+	}
+
+	stream := zm.streams.memory[last]
+	zm.seq.mem.WriteWordAt(stream.table, stream.length)
+	zm.streams.memory = zm.streams.memory[:last]
+}
+
+// syncTranscript starts or stops the transcript according to Flags 2
+// bit 0, which stories can change directly, and tells if it's on
+func (zm *ZMachine) syncTranscript() bool {
+	wanted := zm.seq.mem.ByteAt(0x11)&0x01 != 0
+	if wanted == zm.streams.transcribing {
+		return wanted
+	}
+
+	zm.streams.transcribing = wanted
+	if wanted && zm.streams.transcript == nil {
+		if err := zm.openTranscript(); err != nil {
+			zm.logger.Print("Transcript failed: ", err)
+			zm.setTranscriptFlag(false)
+			return false
+		}
+	}
+	return wanted
+}
+
+func (zm *ZMachine) openTranscript() error {
+	filename, err := zm.askFilename()
+	if err != nil {
+		return err
+	}
+	return zm.OpenTranscript(filename)
+}
+
+// OpenTranscript starts copying the lower window to the file name,
+// the file is appended to if it already exists
+func (zm *ZMachine) OpenTranscript(name string) error {
+	path, err := zm.playerFile(name)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	if zm.streams.transcript != nil {
+		zm.streams.transcript.Close()
+	}
+	zm.streams.transcript = f
+	zm.streams.transcribing = true
+	zm.setTranscriptFlag(true)
+
+	return nil
+}
+
+// CloseTranscript stops the transcript
+func (zm *ZMachine) CloseTranscript() {
+	zm.setTranscriptFlag(false)
+	zm.streams.transcribing = false
+	if zm.streams.transcript != nil {
+		zm.streams.transcript.Close()
+		zm.streams.transcript = nil
+	}
+}
+
+// Transcribing tells if the transcript is on
+func (zm *ZMachine) Transcribing() bool {
+	return zm.syncTranscript()
+}
+
+func (zm *ZMachine) setTranscriptFlag(on bool) {
+	flags2 := zm.seq.mem.ByteAt(0x11)
+	if on {
+		flags2 |= 0x01
+	} else {
+		flags2 &^= 0x01
+	}
+	zm.seq.mem.WriteByteAt(0x11, flags2)
+}
+
+func (zm *ZMachine) writeTranscript(text string) {
+	if _, err := io.WriteString(zm.streams.transcript, text); err != nil {
+		zm.logger.Print("Transcript failed: ", err)
+		zm.CloseTranscript()
+	}
+}
+
+// OpenCommandsStream starts recording the player input to the file name
+func (zm *ZMachine) OpenCommandsStream(name string) error {
+	path, err := zm.playerFile(name)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	zm.closeCommandsStream()
+	zm.streams.commands = f
+	return nil
+}
+
+func (zm *ZMachine) closeCommandsStream() {
+	if zm.streams.commands != nil {
+		zm.streams.commands.Close()
+		zm.streams.commands = nil
+	}
+}
+
+// OpenCommandsFile selects input stream 1, the lines of the file name
+// (as recorded by stream 4) are read instead of the keyboard until
+// the end of the file
+func (zm *ZMachine) OpenCommandsFile(name string) error {
+	path, err := zm.playerFile(name)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	zm.input.setCommands(f)
+	return nil
+}
+
+// echoInput copies a line typed by the player to
+// the transcript and to the commands stream
+func (zm *ZMachine) echoInput(line string) {
+	if zm.syncTranscript() {
+		zm.writeTranscript(line + "\n")
+	}
+
+	if zm.streams.commands != nil {
+		if _, err := io.WriteString(zm.streams.commands, line+"\n"); err != nil {
+			zm.logger.Print("Commands stream failed: ", err)
+			zm.closeCommandsStream()
+		}
+	}
+}
+
+// closeStreams closes the files of streams 2 and 4
+func (zm *ZMachine) closeStreams() {
+	if zm.streams.transcript != nil {
+		zm.streams.transcript.Close()
+		zm.streams.transcript = nil
+	}
+	zm.closeCommandsStream()
+}
@@ -0,0 +1,198 @@
+package gork
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (_ *bufferCloser) Close() error {
+	return nil
+}
+
+func newStreamsZMachine(mem ZMemory) *ZMachine {
+	return &ZMachine{
+		header:  &ZHeader{version: 5},
+		seq:     mem.GetSequential(0),
+		screen:  NewZScreen(80, 25),
+		streams: zstreams{screen: true},
+		logger:  nullLogger{},
+	}
+}
+
+func TestZStreamMemory(t *testing.T) {
+	buf := make([]byte, 0x80)
+	mem := *NewZMemory(buf)
+	zm := newStreamsZMachine(mem)
+
+	ZOutputStream(zm, []uint16{3, 0x40})
+	zm.print("outer ")
+
+	// nested tables take the output until they're closed
+	ZOutputStream(zm, []uint16{3, 0x60})
+	zm.print("Zork\n")
+	ZOutputStream(zm, []uint16{0xFFFD})
+
+	zm.print("end")
+	ZOutputStream(zm, []uint16{0xFFFD})
+
+	if mem.WordAt(0x60) != 5 || string(buf[0x62:0x66]) != "Zork" || buf[0x66] != 13 {
+		t.Fail()
+	}
+	if mem.WordAt(0x40) != 9 || string(buf[0x42:0x4B]) != "outer end" {
+		t.Fail()
+	}
+
+	// nothing reached the screen
+	if spans, _ := zm.screen.TakeLower(); len(spans) != 0 {
+		t.Fail()
+	}
+
+	zm.print("visible")
+	if spans, _ := zm.screen.TakeLower(); len(spans) != 1 || spans[0].Text != "visible" {
+		t.Fail()
+	}
+}
+
+func TestZStreamMemoryBell(t *testing.T) {
+	buf := make([]byte, 0x80)
+	mem := *NewZMemory(buf)
+	zm := newStreamsZMachine(mem)
+	dev := &scriptedIODev{}
+	zm.iodev = dev
+
+	// the bell is not text, the table doesn't get it
+	ZOutputStream(zm, []uint16{3, 0x40})
+	ZSoundEffect(zm, []uint16{1})
+	ZOutputStream(zm, []uint16{0xFFFD})
+
+	if mem.WordAt(0x40) != 0 || dev.output != "\a" {
+		t.Fail()
+	}
+
+	// stream 3 without a table is a fault
+	ZOutputStream(zm, []uint16{3})
+	if zm.err == nil || len(zm.streams.memory) != 0 {
+		t.Fail()
+	}
+}
+
+func TestZStreamMemoryNesting(t *testing.T) {
+	mem := *NewZMemory(make([]byte, 0x80))
+	zm := newStreamsZMachine(mem)
+
+	for i := 0; i < maxMemoryStreams; i++ {
+		zm.openMemoryStream(0x40)
+	}
+	if zm.err != nil {
+		t.Fail()
+	}
+
+	func() {
+		defer func() { recover() }()
+		zm.openMemoryStream(0x40)
+	}()
+
+	if zm.err == nil || len(zm.streams.memory) != maxMemoryStreams {
+		t.Fail()
+	}
+}
+
+func TestZStreamTranscript(t *testing.T) {
+	buf := make([]byte, 0x80)
+	mem := *NewZMemory(buf)
+	zm := newStreamsZMachine(mem)
+
+	transcript := &bufferCloser{}
+	zm.streams.transcript = transcript
+
+	zm.print("hidden ")
+
+	// stories can start the transcript through Flags 2
+	buf[0x11] |= 0x01
+	zm.print("lower ")
+
+	zm.screen.Split(1, 5)
+	zm.screen.SelectWindow(UpperWindow)
+	zm.print("upper ")
+	zm.screen.SelectWindow(LowerWindow)
+
+	zm.echoInput("look")
+
+	ZOutputStream(zm, []uint16{0xFFFE})
+	zm.print("stopped")
+
+	if transcript.String() != "lower look\n" || buf[0x11]&0x01 != 0 {
+		t.Fail()
+	}
+
+	// the file is reused when the transcript starts again
+	ZOutputStream(zm, []uint16{2})
+	zm.print("again")
+
+	if transcript.String() != "lower look\nagain" || buf[0x11]&0x01 == 0 {
+		t.Fail()
+	}
+}
+
+func TestZStreamScreen(t *testing.T) {
+	mem := *NewZMemory(make([]byte, 0x80))
+	zm := newStreamsZMachine(mem)
+
+	commands := &bufferCloser{}
+	zm.streams.commands = commands
+
+	ZOutputStream(zm, []uint16{0xFFFF})
+	zm.print("hidden")
+	ZOutputStream(zm, []uint16{1})
+	zm.print("shown")
+
+	if spans, _ := zm.screen.TakeLower(); len(spans) != 1 || spans[0].Text != "shown" {
+		t.Fail()
+	}
+
+	zm.echoInput("open mailbox")
+	if commands.String() != "open mailbox\n" {
+		t.Fail()
+	}
+}
+
+func TestZStreamFiles(t *testing.T) {
+	dir := t.TempDir()
+	mem := *NewZMemory(make([]byte, 0x80))
+	zm := newStreamsZMachine(mem)
+	zm.input = newZInput(&scriptedIODev{})
+	SaveDir(dir)(zm)
+
+	// remote players can't name files outside the save directory
+	outside := filepath.Join(t.TempDir(), "authorized_keys")
+	for _, name := range []string{outside, "../keys"} {
+		if zm.OpenTranscript(name) == nil || zm.OpenCommandsStream(name) == nil ||
+			zm.OpenCommandsFile(name) == nil {
+			t.Fail()
+		}
+	}
+	if _, err := os.Stat(outside); !os.IsNotExist(err) {
+		t.Fail()
+	}
+
+	if err := zm.OpenCommandsStream("logs/zork.rec"); err != nil {
+		t.FailNow()
+	}
+	zm.echoInput("look")
+	zm.closeStreams()
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "zork.rec"))
+	if err != nil || string(data) != "look\n" {
+		t.Fail()
+	}
+	if err := zm.OpenCommandsFile("zork.rec"); err != nil {
+		t.Fail()
+	}
+}
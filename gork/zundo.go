@@ -0,0 +1,210 @@
+package gork
+
+// snapshots kept by default, see UndoDepth
+const defaultUndoDepth = 16
+
+// zsnapshot is the state of the machine at some point in time
+type zsnapshot struct {
+	pc    uint32
+	stack ZStack
+	// dynamic memory
+	mem    []byte
+	random zrandom
+	// taken by save_undo, restoring it stores 2 to store as
+	// restore_undo does, otherwise pc is a read instruction that
+	// is executed again
+	fromOpcode bool
+	store      byte
+}
+
+// zsnapshots is a stack of snapshots, only the newest one keeps its
+// dynamic memory as is, the others are stored as the difference with
+// the following one (compressed as Quetzal CMem) since few bytes
+// change every turn
+type zsnapshots struct {
+	// max number of snapshots, the oldest ones are dropped
+	depth  int
+	states []zsnapshot
+	// memory of the newest snapshot
+	latest []byte
+}
+
+func (s *zsnapshots) push(state zsnapshot) {
+	if s.depth <= 0 {
+		return
+	}
+
+	if last := len(s.states) - 1; last >= 0 {
+		s.states[last].mem = quetzalCompress(state.mem, s.latest)
+	}
+
+	s.latest = state.mem
+	state.mem = nil
+	s.states = append(s.states, state)
+
+	if len(s.states) > s.depth {
+		s.states = s.states[len(s.states)-s.depth:]
+	}
+}
+
+func (s *zsnapshots) pop() (state zsnapshot, ok bool) {
+	last := len(s.states) - 1
+	if last < 0 {
+		return zsnapshot{}, false
+	}
+
+	state = s.states[last]
+	state.mem = s.latest
+	s.states = s.states[:last]
+
+	s.latest = nil
+	if last > 0 {
+		// the diffs are made by push, they can't be malformed
+		s.latest, _ = quetzalUncompress(state.mem, s.states[last-1].mem)
+		s.states[last-1].mem = nil
+	}
+
+	return state, true
+}
+
+func (s *zsnapshots) clear() {
+	s.states = nil
+	s.latest = nil
+}
+
+func (s *zsnapshots) len() int {
+	return len(s.states)
+}
+
+// UndoDepth sets how many turns can be undone, 0 disables undo
+func UndoDepth(depth int) ZOption {
+	return func(zm *ZMachine) {
+		zm.undo.depth = depth
+		zm.redo.depth = depth
+	}
+}
+
+// snapshot copies the current state, pc is where it resumes from:
+// after the current instruction if fromOpcode, otherwise the current
+// instruction itself, which decodes its operands again
+func (zm *ZMachine) snapshot(pc uint32, fromOpcode bool) zsnapshot {
+	mem := make([]byte, zm.header.dynMemSize)
+	copy(mem, zm.seq.mem.Slice(0, uint32(zm.header.dynMemSize)))
+
+	stack := zm.stack.clone()
+	if !fromOpcode {
+		stack = zm.stackBeforeOp()
+	}
+
+	return zsnapshot{
+		pc:         pc,
+		stack:      stack,
+		mem:        mem,
+		random:     zm.random,
+		fromOpcode: fromOpcode,
+	}
+}
+
+// stackBeforeOp returns a copy of the stack as it was before
+// the operands of the current instruction were popped from it
+func (zm *ZMachine) stackBeforeOp() ZStack {
+	stack := zm.stack.clone()
+	if zm.op != nil {
+		top := stack.Top()
+		for i := len(zm.op.popped) - 1; i >= 0; i-- {
+			top.push(zm.op.popped[i])
+		}
+	}
+	return stack
+}
+
+func (zm *ZMachine) restoreSnapshot(state zsnapshot) {
+	zm.loadDynamicMemory(state.mem)
+
+	zm.stack = state.stack
+	zm.seq.pos = state.pc
+	zm.random = state.random
+
+	if state.fromOpcode {
+		zm.StoreVarAt(state.store, 2)
+	}
+}
+
+// autoUndo tells if a snapshot is taken before every read, which is
+// the case unless the story manages undo itself through save_undo
+func (zm *ZMachine) autoUndo() bool {
+	return zm.header.version < 5 || zm.seq.mem.ByteAt(0x11)&0x10 == 0
+}
+
+// snapshotTurn is called before reading the command of a turn at the
+// read instruction at pc
+func (zm *ZMachine) snapshotTurn(pc uint32) {
+	if !zm.autoUndo() {
+		return
+	}
+
+	// a new turn makes the undone ones unreachable, unless it's
+	// the read the player went back to
+	if !zm.resumed {
+		zm.redo.clear()
+	}
+	zm.resumed = false
+
+	zm.undo.push(zm.snapshot(pc, false))
+}
+
+// undoTurn goes back to the previous turn, current is the state of
+// the turn returned by resumeTurn
+func (zm *ZMachine) undoTurn(current zsnapshot) bool {
+	previous, ok := zm.undo.pop()
+	if !ok {
+		zm.print("[Nothing to undo.]\n")
+		return false
+	}
+
+	zm.redo.push(current)
+	if !previous.fromOpcode {
+		// stories print it themselves after restore_undo
+		zm.print("[Previous turn undone.]\n")
+	}
+	zm.restoreSnapshot(previous)
+
+	return true
+}
+
+// redoTurn goes forward to the turn undone last, as undoTurn
+// current is the state of the turn returned by resumeTurn
+func (zm *ZMachine) redoTurn(current zsnapshot) bool {
+	next, ok := zm.redo.pop()
+	if !ok {
+		zm.print("[Nothing to redo.]\n")
+		return false
+	}
+
+	if zm.autoUndo() {
+		zm.undo.push(current)
+	}
+	zm.print("[Turn redone.]\n")
+	zm.restoreSnapshot(next)
+
+	return true
+}
+
+// resumeTurn prepares the read instruction at pc to be executed again,
+// unless the state is replaced, and it returns the state of the
+// current turn
+func (zm *ZMachine) resumeTurn(pc uint32) zsnapshot {
+	current := zm.snapshot(pc, false)
+	if zm.autoUndo() {
+		// the newest snapshot is the current turn, it's taken
+		// again when its read is executed again
+		zm.undo.pop()
+	}
+
+	// the read pops its operands again
+	zm.stack = current.stack.clone()
+	zm.seq.pos = pc
+	zm.resumed = true
+
+	return current
+}
@@ -0,0 +1,171 @@
+package gork
+
+import "testing"
+
+func TestZSnapshots(t *testing.T) {
+	s := zsnapshots{depth: 3}
+
+	for i := 0; i < 5; i++ {
+		mem := make([]byte, 0x100)
+		mem[0x80] = byte(i)
+		mem[i] = 0xFF
+		s.push(zsnapshot{pc: uint32(i), mem: mem})
+	}
+
+	// the oldest ones have been dropped
+	if s.len() != 3 {
+		t.FailNow()
+	}
+
+	for i := 4; i >= 2; i-- {
+		state, ok := s.pop()
+		if !ok || state.pc != uint32(i) || state.mem[0x80] != byte(i) || state.mem[i] != 0xFF {
+			t.FailNow()
+		}
+
+		for j, b := range state.mem {
+			if j != 0x80 && j != i && b != 0 {
+				t.FailNow()
+			}
+		}
+	}
+
+	if _, ok := s.pop(); ok {
+		t.Fail()
+	}
+}
+
+func TestZMachineUndo(t *testing.T) {
+	buf := make([]byte, 0x80)
+	mem := *NewZMemory(buf)
+	zm := &ZMachine{
+		header:  &ZHeader{version: 3, dynMemSize: 0x80},
+		seq:     mem.GetSequential(0),
+		stack:   ZStack{&ZRoutine{locals: []uint16{1}}},
+		screen:  NewZScreen(80, 25),
+		streams: zstreams{screen: true},
+		undo:    zsnapshots{depth: defaultUndoDepth},
+		redo:    zsnapshots{depth: defaultUndoDepth},
+		meta:    newZMeta(),
+	}
+
+	// two turns reading at 0x10 and then at 0x20
+	zm.snapshotTurn(0x10)
+	buf[0x40] = 1
+	zm.stack.Top().locals[0] = 2
+	zm.snapshotTurn(0x20)
+
+	if !zm.metaCommand("#UNDO ", 0x20) {
+		t.FailNow()
+	}
+	if buf[0x40] != 0 || zm.stack.Top().locals[0] != 1 || zm.seq.pos != 0x10 {
+		t.FailNow()
+	}
+
+	// the read of the first turn is executed again
+	zm.snapshotTurn(0x10)
+	zm.metaCommand("#undo", 0x10)
+	if zm.seq.pos != 0x10 {
+		t.FailNow()
+	}
+
+	zm.snapshotTurn(0x10)
+	zm.metaCommand("#redo", 0x10)
+	if buf[0x40] != 1 || zm.stack.Top().locals[0] != 2 || zm.seq.pos != 0x20 {
+		t.FailNow()
+	}
+
+	zm.snapshotTurn(0x20)
+	if zm.undo.len() != 2 || zm.redo.len() != 0 {
+		t.Fail()
+	}
+
+	// a new turn forgets the undone ones
+	zm.metaCommand("#undo", 0x20)
+	zm.snapshotTurn(0x10)
+	zm.snapshotTurn(0x10)
+	if zm.redo.len() != 0 || zm.metaCommand("look", 0x10) {
+		t.Fail()
+	}
+
+	spans, _ := zm.screen.TakeLower()
+	if len(spans) != 1 || spans[0].Text != "[Previous turn undone.]\n[Nothing to undo.]\n"+
+		"[Turn redone.]\n[Previous turn undone.]\n" {
+		t.Fail()
+	}
+}
+
+func TestZMachineSaveUndo(t *testing.T) {
+	buf := make([]byte, 0x80)
+	mem := *NewZMemory(buf)
+
+	zm := &ZMachine{
+		header: &ZHeader{version: 5, dynMemSize: 0x80, globalsPos: 0x40},
+		seq:    mem.GetSequential(0x21),
+		// store the result to global 0 at 0x40
+		op:    &ZOp{store: 0x10},
+		stack: ZStack{&ZRoutine{locals: []uint16{}}},
+		undo:  zsnapshots{depth: defaultUndoDepth},
+		redo:  zsnapshots{depth: defaultUndoDepth},
+	}
+
+	ZSaveUndo(zm, nil)
+	if mem.WordAt(0x40) != 1 {
+		t.FailNow()
+	}
+
+	// it resumes after save_undo
+	zm.seq.pos = 0x30
+	ZRestoreUndo(zm, nil)
+	if mem.WordAt(0x40) != 2 || zm.seq.pos != 0x21 {
+		t.Fail()
+	}
+
+	ZRestoreUndo(zm, nil)
+	if mem.WordAt(0x40) != 0 {
+		t.Fail()
+	}
+
+	zm.undo.depth = 0
+	ZSaveUndo(zm, nil)
+	if mem.WordAt(0x40) != 0xFFFF {
+		t.Fail()
+	}
+}
+
+func TestZMachineUndoStackOperands(t *testing.T) {
+	buf := make([]byte, 0x80)
+	mem := *NewZMemory(buf)
+	// sread sp sp, then sread 0x40 0
+	copy(buf[0x20:], []byte{0xE4, 0xAF, 0x00, 0x00, 0xE4, 0x5F, 0x40, 0x00})
+	buf[0x40] = 10
+
+	dev := &scriptedIODev{lines: []string{"#dance", "look", "#undo", "inventory"}}
+	zm := newMetaZMachine(mem)
+	zm.header.globalsPos = 0x60
+	zm.seq.pos = 0x20
+	zm.iodev, zm.input, zm.logger = dev, newZInput(dev), nullLogger{}
+
+	// the parse table and the text buffer
+	zm.stack.Top().push(0)
+	zm.stack.Top().push(0x40)
+
+	// meta commands execute the read again, popping its operands again
+	for _, pos := range []uint32{0x20, 0x24, 0x20, 0x24} {
+		if err := zm.Interpret(); err != nil || zm.seq.pos != pos {
+			t.FailNow()
+		}
+
+		depth := 0
+		if pos == 0x20 {
+			depth = 2
+		}
+		if len(zm.stack.Top().stack) != depth {
+			t.FailNow()
+		}
+	}
+
+	if string(buf[0x41:0x4A]) != "inventory" {
+		t.Fail()
+	}
+}